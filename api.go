@@ -1,28 +1,111 @@
 package gateway
 
 import (
-	"bytes"
-	"reflect"
+	"encoding/json"
+	"errors"
+	"net/http"
 	"time"
 )
 
 type ServiceInfo struct {
 	*ServiceConfig
+	State          string              `json:"state"`
+	LastCheck      *HealthCheckInfo    `json:"lastCheck,omitempty"`
+	CircuitBreaker *CircuitBreakerInfo `json:"circuitBreakerState"`
+	Bulkhead       *BulkheadInfo       `json:"bulkhead,omitempty"`
+	WebSocket      *WebSocketInfo      `json:"webSocket,omitempty"`
+	Instances      []*InstanceInfo     `json:"instanceStates"`
+}
+
+// CircuitBreakerInfo is the current state of the circuit breaker of a service.
+type CircuitBreakerInfo struct {
 	State string `json:"state"`
+
+	// The state was set manually by the system API.
+	Forced bool       `json:"forced"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+// BulkheadInfo is the current load of the bulkhead of a service.
+type BulkheadInfo struct {
+	MaxConcurrent int    `json:"maxConcurrent"`
+	InFlight      int    `json:"inFlight"`
+	Queued        int64  `json:"queued"`
+	Rejected      uint64 `json:"rejected"`
+}
+
+// WebSocketInfo is the count of the tunneled – eg. WebSocket – connections of a service.
+type WebSocketInfo struct {
+	MaxConnections int    `json:"maxConnections"`
+	Open           int    `json:"open"`
+	Total          uint64 `json:"total"`
+	Rejected       uint64 `json:"rejected"`
+}
+
+type InstanceInfo struct {
+	Address   string           `json:"address"`
+	Weight    int              `json:"weight"`
+	State     string           `json:"state"`
+	InFlight  int64            `json:"inFlight"`
+	LastCheck *HealthCheckInfo `json:"lastCheck,omitempty"`
+	Ejection  *EjectionInfo    `json:"ejection,omitempty"`
+}
+
+// EjectionInfo describes the ejection of an instance by the outlier detection.
+type EjectionInfo struct {
+	Until     time.Time `json:"until"`
+	Ejections int       `json:"ejections"`
+
+	// The ejection time is over, but the instance
+	// is waiting for a successful healthcheck.
+	AwaitingProbe bool `json:"awaitingProbe"`
+}
+
+// HealthCheckInfo is the outcome of the last healthcheck.
+type HealthCheckInfo struct {
+	At      time.Time `json:"at"`
+	Latency string    `json:"latency"`
+	Error   string    `json:"error,omitempty"`
 }
 
 type infoResponse struct {
-	TotalConnectionService uint64         `json:"totalConnectionServed"`
-	IsProd                 bool           `json:"isProd"`
-	AreMiddlewaresEnabled  bool           `json:"areMiddlewaresEnabled"`
-	Uptime                 string         `json:"uptime"`
-	Services               []*ServiceInfo `json:"services"`
+	TotalConnectionService uint64                `json:"totalConnectionServed"`
+	IsProd                 bool                  `json:"isProd"`
+	AreMiddlewaresEnabled  bool                  `json:"areMiddlewaresEnabled"`
+	Uptime                 string                `json:"uptime"`
+	Services               []*ServiceInfo        `json:"services"`
+	TrafficSplits          []*TrafficSplitConfig `json:"trafficSplits"`
 }
 
 type updateServiceStateRequest struct {
 	ServiceName string `json:"serviceName"`
 }
 
+type deregisterServiceRequest struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type setCircuitBreakerRequest struct {
+	ServiceName string `json:"serviceName"`
+	State       string `json:"state"`
+}
+
+type removeTrafficSplitRequest struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type removeConsumerRequest struct {
+	ConsumerID string `json:"consumerId"`
+}
+
+type consumersResponse struct {
+	Consumers []*ConsumerInfo `json:"consumers"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
 const (
 	IncomingDecodedKey ContextKey = "incomingDecoded"
 	X_GW_HEADER_KEY    string     = "X-GATEWAY-KEY"
@@ -30,25 +113,28 @@ const (
 
 type decodeFunction func([]byte) (any, error)
 
-// getCleanedBody makes the incoming request body as tight as possible.
-func getCleanedBody(b []byte) []byte {
-	b = bytes.ReplaceAll(b, []byte(" "), []byte(""))
-	return bytes.ReplaceAll(b, []byte("\n"), []byte(""))
+// decodeInto returns a decodeFunction, which unmarshals
+// the incoming body into a new instance of T.
+func decodeInto[T any]() decodeFunction {
+	return func(b []byte) (any, error) {
+		var (
+			in  = new(T)
+			err = json.Unmarshal(b, in)
+		)
+
+		return in, err
+	}
 }
 
-// validateIncomingRequest validates all the incoming requests by its header key.
-func validateIncomingRequest(g *Gateway, df decodeFunction) MiddlewareFunc {
+// validateIncomingRequest validates all the incoming requests by their
+// signatures, timestamps and nonces. See SignRequest.
+func validateIncomingRequest(sv *signatureVerifier, df decodeFunction) MiddlewareFunc {
 	return func(ctx Context, next HandlerFunc) {
-		b := getCleanedBody(ctx.GetBody())
-		var (
-			key   = ctx.GetRequestHeader(X_GW_HEADER_KEY)
-			plain = append(b, []byte(g.info.secretKey)...)
-		)
+		b := ctx.GetBody()
 
-		// If the value if the header is not deeply equal to
-		// the one that we constructed based on the shared secret key
-		// we simply return with 401.
-		if h := createHash(plain); !reflect.DeepEqual(h, []byte(key)) {
+		// If the signature is not the one that we constructed based on the shared
+		// secret key, or the request is too old or replayed, we simply return with 401.
+		if err := sv.verify(ctx.GetRequest(), b, time.Now()); err != nil {
 			ctx.SendUnauthorized()
 			return
 		}
@@ -83,6 +169,182 @@ func serviceStateUpdateHandler(g *Gateway) HandlerFunc {
 	}
 }
 
+// registerServiceHandler returns a HandlerFunc which registers a new service
+// based on the incoming config, then performs its first healthcheck.
+func registerServiceHandler(g *Gateway) HandlerFunc {
+	return func(ctx Context) {
+		conf, ok := ctx.GetBindedValue(IncomingDecodedKey).(*ServiceConfig)
+		if !ok {
+			ctx.SendUnauthorized()
+			return
+		}
+
+		if err := g.RegisterService(conf); err != nil {
+			sendServiceError(ctx, err)
+			return
+		}
+
+		g.checkServiceStatusAsync(conf.Name)
+		ctx.SendOk()
+	}
+}
+
+// updateServiceConfigHandler returns a HandlerFunc which replaces
+// the config of an already registered service.
+func updateServiceConfigHandler(g *Gateway) HandlerFunc {
+	return func(ctx Context) {
+		conf, ok := ctx.GetBindedValue(IncomingDecodedKey).(*ServiceConfig)
+		if !ok {
+			ctx.SendUnauthorized()
+			return
+		}
+
+		if err := g.UpdateService(conf); err != nil {
+			sendServiceError(ctx, err)
+			return
+		}
+
+		g.checkServiceStatusAsync(conf.Name)
+		ctx.SendOk()
+	}
+}
+
+// deregisterServiceHandler returns a HandlerFunc which removes
+// the corresponding service from the registry.
+func deregisterServiceHandler(g *Gateway) HandlerFunc {
+	return func(ctx Context) {
+		inc, ok := ctx.GetBindedValue(IncomingDecodedKey).(*deregisterServiceRequest)
+		if !ok {
+			ctx.SendUnauthorized()
+			return
+		}
+
+		if err := g.DeregisterService(inc.ServiceName); err != nil {
+			sendServiceError(ctx, err)
+			return
+		}
+
+		ctx.SendOk()
+	}
+}
+
+// setCircuitBreakerHandler returns a HandlerFunc which sets
+// the state of the circuit breaker of a service manually.
+func setCircuitBreakerHandler(g *Gateway) HandlerFunc {
+	return func(ctx Context) {
+		inc, ok := ctx.GetBindedValue(IncomingDecodedKey).(*setCircuitBreakerRequest)
+		if !ok {
+			ctx.SendUnauthorized()
+			return
+		}
+
+		if err := g.SetCircuitBreakerState(inc.ServiceName, inc.State); err != nil {
+			sendServiceError(ctx, err)
+			return
+		}
+
+		ctx.SendOk()
+	}
+}
+
+// updateTrafficSplitHandler returns a HandlerFunc which sets the traffic split
+// of a service, eg. to ramp up the traffic of a canary version.
+func updateTrafficSplitHandler(g *Gateway) HandlerFunc {
+	return func(ctx Context) {
+		conf, ok := ctx.GetBindedValue(IncomingDecodedKey).(*TrafficSplitConfig)
+		if !ok {
+			ctx.SendUnauthorized()
+			return
+		}
+
+		if err := g.SetTrafficSplit(conf); err != nil {
+			sendServiceError(ctx, err)
+			return
+		}
+
+		ctx.SendOk()
+	}
+}
+
+// removeTrafficSplitHandler returns a HandlerFunc which removes the traffic split of a service.
+func removeTrafficSplitHandler(g *Gateway) HandlerFunc {
+	return func(ctx Context) {
+		inc, ok := ctx.GetBindedValue(IncomingDecodedKey).(*removeTrafficSplitRequest)
+		if !ok {
+			ctx.SendUnauthorized()
+			return
+		}
+
+		if err := g.RemoveTrafficSplit(inc.ServiceName); err != nil {
+			sendServiceError(ctx, err)
+			return
+		}
+
+		ctx.SendOk()
+	}
+}
+
+// listConsumersHandler returns a HandlerFunc which lists the registered consumers.
+// The hashes of the keys are not sent, only their count.
+func listConsumersHandler(g *Gateway) HandlerFunc {
+	return func(ctx Context) {
+		ctx.SendJson(&consumersResponse{Consumers: g.consumers.getInfo()})
+	}
+}
+
+// registerConsumerHandler returns a HandlerFunc which registers a new consumer
+// – or replaces an already registered one – based on the incoming config.
+func registerConsumerHandler(g *Gateway) HandlerFunc {
+	return func(ctx Context) {
+		conf, ok := ctx.GetBindedValue(IncomingDecodedKey).(*ConsumerConfig)
+		if !ok {
+			ctx.SendUnauthorized()
+			return
+		}
+
+		if err := g.RegisterConsumer(conf); err != nil {
+			sendServiceError(ctx, err)
+			return
+		}
+
+		ctx.SendOk()
+	}
+}
+
+// removeConsumerHandler returns a HandlerFunc which removes the corresponding consumer.
+func removeConsumerHandler(g *Gateway) HandlerFunc {
+	return func(ctx Context) {
+		inc, ok := ctx.GetBindedValue(IncomingDecodedKey).(*removeConsumerRequest)
+		if !ok {
+			ctx.SendUnauthorized()
+			return
+		}
+
+		if err := g.RemoveConsumer(inc.ConsumerID); err != nil {
+			sendServiceError(ctx, err)
+			return
+		}
+
+		ctx.SendOk()
+	}
+}
+
+// sendServiceError sends the given error of a registry
+// operation with the corresponding status code.
+func sendServiceError(ctx Context, err error) {
+	statusCode := func() int {
+		if errors.Is(err, ErrServiceNotExists) || errors.Is(err, errConsumerNotExists) {
+			return http.StatusNotFound
+		}
+		if errors.Is(err, errServiceExists) || errors.Is(err, errConsumerKeyExists) || errors.Is(err, errServiceInSplit) {
+			return http.StatusConflict
+		}
+		return http.StatusBadRequest
+	}()
+
+	ctx.SendJson(&errorResponse{Error: err.Error()}, statusCode)
+}
+
 // getSystemInfoHandler returns a response with the Gateway's info.
 // Currently it only returns the slice of registered services – with all its info –
 // the system's uptime and the count of served connections so far.
@@ -93,9 +355,27 @@ func getSystemInfoHandler(g *Gateway) HandlerFunc {
 		info := make([]*ServiceInfo, len(services))
 
 		for i, e := range services {
+			instances := make([]*InstanceInfo, len(e.instances))
+
+			for j, inst := range e.instances {
+				instances[j] = &InstanceInfo{
+					Address:   inst.GetAddress(),
+					Weight:    inst.GetWeight(),
+					State:     stateTexts[inst.getState()],
+					InFlight:  inst.GetInFlight(),
+					LastCheck: inst.lastCheck.getInfo(),
+					Ejection:  inst.outlier.getInfo(),
+				}
+			}
+
 			info[i] = &ServiceInfo{
-				ServiceConfig: e.ServiceConfig,
-				State:         stateTexts[e.state],
+				ServiceConfig:  e.ServiceConfig,
+				State:          stateTexts[e.getState()],
+				LastCheck:      e.lastCheck.getInfo(),
+				CircuitBreaker: e.breaker.getInfo(),
+				Bulkhead:       e.bulkhead.getInfo(),
+				WebSocket:      e.tunnels.getInfo(),
+				Instances:      instances,
 			}
 		}
 
@@ -105,6 +385,7 @@ func getSystemInfoHandler(g *Gateway) HandlerFunc {
 			IsProd:                 g.isProd(),
 			AreMiddlewaresEnabled:  g.areMiddlewaresEnabled(),
 			Uptime:                 getElapsedTime(g.info.startTime, time.Now()),
+			TrafficSplits:          g.serviceRegisty.getTrafficSplits(),
 		}
 
 		ctx.SendJson(res)
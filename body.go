@@ -0,0 +1,191 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// The bodies up to this size are buffered, if they must be sent
+	// more than once. The larger ones are streamed, so not retried.
+	maxReplayableBodySize = 1 << 20
+
+	// The buffers grown above this are not put back to the pool,
+	// so a few large bodies do not keep the memory forever.
+	maxPooledBufferSize = 2 * maxReplayableBodySize
+)
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf == nil || buf.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// requestBody is the body of a proxied request. It is either streamed
+// from the incoming request as it is, or buffered, so it can be sent again.
+type requestBody struct {
+	// The buffered part of the body. If rest is nil, it is the whole body.
+	buf *bytes.Buffer
+
+	// The part of the body, which is not read yet.
+	rest io.Reader
+
+	contentLength int64
+}
+
+// newRequestBody prepares the body of the given request to be proxied. If the
+// body is larger than maxSize – if it is given –, reading it results in an error
+// of type *http.MaxBytesError. The body is only buffered, if isReplayNeeded is set.
+func newRequestBody(r *http.Request, maxSize int64, isReplayNeeded bool) (*requestBody, error) {
+	rb := &requestBody{contentLength: r.ContentLength}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return rb, nil
+	}
+
+	var body io.Reader = r.Body
+	if maxSize > 0 {
+		body = http.MaxBytesReader(nil, r.Body, maxSize)
+	}
+
+	body = &clientBodyReader{r: body}
+
+	if !isReplayNeeded || r.ContentLength > maxReplayableBodySize {
+		rb.rest = body
+		return rb, nil
+	}
+
+	rb.buf = getBuffer()
+
+	// Reading one more byte than the limit, tells if the body is above it.
+	n, err := io.CopyN(rb.buf, body, maxReplayableBodySize+1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		rb.release()
+		return nil, err
+	}
+
+	if n > maxReplayableBodySize {
+		rb.rest = body
+	}
+
+	return rb, nil
+}
+
+// clientBodyError is the error of reading the body of the incoming request –
+// eg. the client aborted the upload –, which is not the fault of the instance.
+type clientBodyError struct {
+	err error
+}
+
+func (e *clientBodyError) Error() string {
+	return e.err.Error()
+}
+
+func (e *clientBodyError) Unwrap() error {
+	return e.err
+}
+
+// clientBodyReader marks the errors of reading the body of the incoming
+// request, so they can be told apart from the errors of the instance.
+type clientBodyReader struct {
+	r io.Reader
+}
+
+func (cr *clientBodyReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &clientBodyError{err: err}
+	}
+
+	return n, err
+}
+
+// isReplayable tells if the body can be sent more than once.
+func (rb *requestBody) isReplayable() bool {
+	return rb.rest == nil
+}
+
+// getReader returns the reader of the body for one attempt.
+// The body which is not replayable must only be read once.
+func (rb *requestBody) getReader() io.Reader {
+	if rb.buf == nil && rb.rest == nil {
+		return nil
+	}
+
+	if rb.buf == nil {
+		return rb.rest
+	}
+
+	buffered := bytes.NewReader(rb.buf.Bytes())
+	if rb.rest == nil {
+		return buffered
+	}
+
+	return io.MultiReader(buffered, rb.rest)
+}
+
+// release puts the buffer back to the pool. The
+// body must not be used after it is released.
+func (rb *requestBody) release() {
+	putBuffer(rb.buf)
+	rb.buf = nil
+}
+
+// isBodyTooLarge tells if the error is caused by a body above the size limit.
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// readBody is the body reader of the router. The bodies of the requests,
+// which are proxied to a service, are not read here, so they can be
+// streamed to the upstream, while the system and custom routes – even
+// under the prefix of a service – get the whole body by ctx.GetBody.
+func (gw *Gateway) readBody(r *http.Request) []byte {
+	if r == nil || r.Body == nil {
+		return nil
+	}
+
+	url, _, _ := strings.Cut(r.RequestURI, "?")
+
+	if gw.isProxiedRequest(r, url) {
+		return nil
+	}
+
+	defer r.Body.Close()
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
+
+// isProxiedRequest tells if the request with the given url is dispatched to a
+// service, ie. it is neither a system, nor a custom route, and there is a
+// service for it.
+func (gw *Gateway) isProxiedRequest(r *http.Request, url string) bool {
+	if strings.HasPrefix(url, routeSystemPrefix) || gw.isCustomRoute(r.Method, url) {
+		return false
+	}
+
+	return gw.serviceRegisty.findServiceByHost(getRequestHost(r), url) != nil
+}
@@ -0,0 +1,266 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/balazskvancz/rtree"
+)
+
+func TestNewRequestBody(t *testing.T) {
+	type testCase struct {
+		name           string
+		body           io.Reader
+		maxSize        int64
+		isReplayNeeded bool
+
+		isTooLarge      bool
+		expIsReplayable bool
+		expBody         string
+	}
+
+	largeBody := strings.Repeat("a", maxReplayableBodySize+10)
+
+	tt := []testCase{
+		{
+			name:            "the empty body is replayable",
+			body:            nil,
+			expIsReplayable: true,
+			expBody:         "",
+		},
+		{
+			name:            "the body is streamed, if it is not needed to be replayed",
+			body:            strings.NewReader("mock-body"),
+			expIsReplayable: false,
+			expBody:         "mock-body",
+		},
+		{
+			name:            "the body is buffered, if it is needed to be replayed",
+			body:            strings.NewReader("mock-body"),
+			isReplayNeeded:  true,
+			expIsReplayable: true,
+			expBody:         "mock-body",
+		},
+		{
+			name:            "the too large body is streamed, even if it is needed to be replayed",
+			body:            strings.NewReader(largeBody),
+			isReplayNeeded:  true,
+			expIsReplayable: false,
+			expBody:         largeBody,
+		},
+		{
+			name:           "the function returns error if the buffered body is above the max size",
+			body:           strings.NewReader("mock-body"),
+			maxSize:        4,
+			isReplayNeeded: true,
+			isTooLarge:     true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/mock", tc.body)
+			// The length is unknown, as it would be with chunked encoding.
+			r.ContentLength = -1
+
+			rb, err := newRequestBody(r, tc.maxSize, tc.isReplayNeeded)
+			if tc.isTooLarge {
+				if !isBodyTooLarge(err) {
+					t.Errorf("expected body too large error; got error: %v\n", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+			}
+			defer rb.release()
+
+			if rb.isReplayable() != tc.expIsReplayable {
+				t.Errorf("expected replayable: %t; got replayable: %t\n", tc.expIsReplayable, rb.isReplayable())
+			}
+
+			var got []byte
+			if reader := rb.getReader(); reader != nil {
+				got, _ = io.ReadAll(reader)
+			}
+
+			if string(got) != tc.expBody {
+				t.Errorf("expected body length: %d; got body length: %d\n", len(tc.expBody), len(got))
+			}
+
+			// The replayable body must be the same on the next read.
+			if tc.expIsReplayable && rb.getReader() != nil {
+				again, _ := io.ReadAll(rb.getReader())
+				if !bytes.Equal(again, got) {
+					t.Errorf("expected body: %s; got body: %s\n", got, again)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleBody(t *testing.T) {
+	type testCase struct {
+		name          string
+		method        string
+		body          string
+		contentLength int64
+		maxBodySize   int64
+
+		expCode     int
+		expUpstream bool
+	}
+
+	tt := []testCase{
+		{
+			name:          "the body is streamed to the service",
+			method:        http.MethodPatch,
+			body:          "mock-body",
+			contentLength: 9,
+			expCode:       http.StatusOK,
+			expUpstream:   true,
+		},
+		{
+			name:          "the request is rejected right away, if the content length is above the limit",
+			method:        http.MethodPost,
+			body:          "mock-body",
+			contentLength: 9,
+			maxBodySize:   4,
+			expCode:       http.StatusRequestEntityTooLarge,
+			expUpstream:   false,
+		},
+		{
+			name:          "the request is rejected, if the chunked body is above the limit",
+			method:        http.MethodPost,
+			body:          strings.Repeat("a", 64*1024),
+			contentLength: -1,
+			maxBodySize:   1024,
+			expCode:       http.StatusRequestEntityTooLarge,
+			expUpstream:   false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotBody []byte
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, err := io.ReadAll(r.Body)
+				if err != nil {
+					return
+				}
+				gotBody = b
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			s := newTestService(t, srv, &ServiceConfig{
+				Name:        "mock-name",
+				Prefix:      "/api/mock",
+				MaxBodySize: tc.maxBodySize,
+			})
+
+			r := httptest.NewRequest(tc.method, "/api/mock/foo", strings.NewReader(tc.body))
+			r.ContentLength = tc.contentLength
+
+			ctx, rec := newTestContext(r)
+
+			s.Handle(ctx)
+			ctx.WriteToResponseNow()
+
+			if rec.Code != tc.expCode {
+				t.Errorf("expected status code: %d; got status code: %d\n", tc.expCode, rec.Code)
+			}
+
+			if tc.expUpstream && string(gotBody) != tc.body {
+				t.Errorf("expected body: %s; got body: %s\n", tc.body, gotBody)
+			}
+
+			if !tc.expUpstream && gotBody != nil {
+				t.Errorf("expected the body not to reach the service; got body length: %d\n", len(gotBody))
+			}
+
+			// The body above the limit is not the fault of the service.
+			if info := s.breaker.getInfo(); info.State != circuitStateTexts[CircuitClosed] {
+				t.Errorf("expected breaker state: %s; got breaker state: %s\n", circuitStateTexts[CircuitClosed], info.State)
+			}
+		})
+	}
+}
+
+func TestReadBody(t *testing.T) {
+	type testCase struct {
+		name    string
+		method  string
+		url     string
+		expBody string
+	}
+
+	tt := []testCase{
+		{
+			name:    "the body of a system route is read",
+			url:     routeSystemInfo,
+			expBody: "mock-body",
+		},
+		{
+			name:    "the body of a custom route is read",
+			url:     "/custom",
+			expBody: "mock-body",
+		},
+		{
+			name:    "the body of a service is not read",
+			url:     "/api/mock/foo?bar=baz",
+			expBody: "",
+		},
+		{
+			name:    "the body of a custom route under the prefix of a service is read",
+			url:     "/api/mock/custom?bar=baz",
+			expBody: "mock-body",
+		},
+		{
+			name:    "the body of an other method under the url of a custom route is not read",
+			method:  http.MethodPut,
+			url:     "/api/mock/custom",
+			expBody: "",
+		},
+	}
+
+	gw := &Gateway{
+		serviceRegisty: newRegistry(),
+		customRoutes:   make(map[string]*rtree.Tree[bool]),
+	}
+
+	gw.addCustomRoute(http.MethodPost, "/custom")
+	gw.addCustomRoute(http.MethodPost, "/api/mock/custom")
+
+	err := gw.serviceRegisty.addService(&ServiceConfig{
+		Name:     "mock-name",
+		Prefix:   "/api/mock",
+		Protocol: "http",
+		Host:     "localhost",
+		Port:     "3000",
+	})
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+
+			r := httptest.NewRequest(method, tc.url, strings.NewReader("mock-body"))
+
+			if got := gw.readBody(r); string(got) != tc.expBody {
+				t.Errorf("expected body: %s; got body: %s\n", tc.expBody, got)
+			}
+		})
+	}
+}
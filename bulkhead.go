@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBulkheadQueueTimeout = time.Second
+)
+
+// BulkheadConfig limits the count of requests, which are served by a service
+// at the same time, so a slow service can not exhaust the resources shared
+// by the other services. The requests above the limit wait in a bounded queue,
+// and the ones which do not fit – or time out while waiting – get HTTP 503.
+type BulkheadConfig struct {
+	// The maximum count of requests in flight. If it is not given, there is no limit.
+	MaxConcurrent int `json:"maxConcurrent"`
+
+	// The maximum count of requests waiting for a free slot.
+	// By default there is no queue, so the requests are rejected right away.
+	MaxQueue int `json:"maxQueue"`
+
+	// How long a request waits in the queue – eg. "500ms". By default it is 1 second.
+	QueueTimeout string `json:"queueTimeout"`
+}
+
+// bulkhead is the parsed, ready to use form of BulkheadConfig.
+type bulkhead struct {
+	maxConcurrent int
+	maxQueue      int64
+	queueTimeout  time.Duration
+
+	// The semaphore of the requests in flight.
+	slots chan struct{}
+
+	queued   int64
+	rejected uint64
+}
+
+// newBulkhead creates the bulkhead from the given – already validated – config.
+// It returns nil, if there is no config, or it does not limit the concurrency.
+func newBulkhead(conf *BulkheadConfig) *bulkhead {
+	if conf == nil || conf.MaxConcurrent <= 0 {
+		return nil
+	}
+
+	b := &bulkhead{
+		maxConcurrent: conf.MaxConcurrent,
+		maxQueue:      int64(conf.MaxQueue),
+		queueTimeout:  defaultBulkheadQueueTimeout,
+		slots:         make(chan struct{}, conf.MaxConcurrent),
+	}
+
+	if d, err := time.ParseDuration(conf.QueueTimeout); err == nil {
+		b.queueTimeout = d
+	}
+
+	return b
+}
+
+// validateBulkhead validates the given config.
+// It returns the first error that occured.
+func validateBulkhead(conf *BulkheadConfig) error {
+	if conf == nil {
+		return nil
+	}
+
+	if conf.MaxConcurrent < 0 || conf.MaxQueue < 0 {
+		return errBadBulkheadLimit
+	}
+
+	if conf.QueueTimeout != "" {
+		if v, err := time.ParseDuration(conf.QueueTimeout); err != nil || v <= 0 {
+			return errBadBulkheadDuration
+		}
+	}
+
+	return nil
+}
+
+// acquire takes a slot, waiting in the queue if there is no free one.
+// It returns errBulkheadFull, if the request can not be served, or the
+// error of the ctx, if it is done while waiting. Every successful
+// acquire must be followed by a call of release.
+func (b *bulkhead) acquire(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if atomic.AddInt64(&b.queued, 1) > b.maxQueue {
+		atomic.AddInt64(&b.queued, -1)
+		atomic.AddUint64(&b.rejected, 1)
+
+		return errBulkheadFull
+	}
+	defer atomic.AddInt64(&b.queued, -1)
+
+	t := time.NewTimer(b.queueTimeout)
+	defer t.Stop()
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-t.C:
+		atomic.AddUint64(&b.rejected, 1)
+
+		return errBulkheadFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees the slot taken by acquire.
+func (b *bulkhead) release() {
+	if b == nil {
+		return
+	}
+	<-b.slots
+}
+
+// getRetryAfter returns the time, which the rejected clients should wait.
+func (b *bulkhead) getRetryAfter() time.Duration {
+	if b == nil || b.queueTimeout < time.Second {
+		return time.Second
+	}
+	return b.queueTimeout
+}
+
+// getInfo returns the public view of the bulkhead, or nil if there is no limit.
+func (b *bulkhead) getInfo() *BulkheadInfo {
+	if b == nil {
+		return nil
+	}
+
+	return &BulkheadInfo{
+		MaxConcurrent: b.maxConcurrent,
+		InFlight:      len(b.slots),
+		Queued:        atomic.LoadInt64(&b.queued),
+		Rejected:      atomic.LoadUint64(&b.rejected),
+	}
+}
@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValidateBulkhead(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *BulkheadConfig
+		err  error
+	}
+
+	tt := []testCase{
+		{
+			name: "the function returns nil if there is no config",
+			conf: nil,
+			err:  nil,
+		},
+		{
+			name: "the function returns error if a limit is negative",
+			conf: &BulkheadConfig{MaxConcurrent: 10, MaxQueue: -1},
+			err:  errBadBulkheadLimit,
+		},
+		{
+			name: "the function returns error if the queue timeout is invalid",
+			conf: &BulkheadConfig{MaxConcurrent: 10, QueueTimeout: "-1s"},
+			err:  errBadBulkheadDuration,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &BulkheadConfig{MaxConcurrent: 10, MaxQueue: 20, QueueTimeout: "500ms"},
+			err:  nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateBulkhead(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestBulkheadAcquire(t *testing.T) {
+	t.Run("the request is rejected right away, if there is no queue", func(t *testing.T) {
+		b := newBulkhead(&BulkheadConfig{MaxConcurrent: 1})
+
+		if err := b.acquire(context.Background()); err != nil {
+			t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+		}
+
+		if err := b.acquire(context.Background()); !errors.Is(err, errBulkheadFull) {
+			t.Errorf("expected error: %v; got error: %v\n", errBulkheadFull, err)
+		}
+
+		if rejected := b.getInfo().Rejected; rejected != 1 {
+			t.Errorf("expected rejected: %d; got rejected: %d\n", 1, rejected)
+		}
+	})
+
+	t.Run("the queued request gets the slot, once it is released", func(t *testing.T) {
+		b := newBulkhead(&BulkheadConfig{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: "1s"})
+
+		if err := b.acquire(context.Background()); err != nil {
+			t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+		}
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- b.acquire(context.Background())
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+
+		// The queue is full at this point.
+		if err := b.acquire(context.Background()); !errors.Is(err, errBulkheadFull) {
+			t.Errorf("expected error: %v; got error: %v\n", errBulkheadFull, err)
+		}
+
+		b.release()
+
+		if err := <-errCh; err != nil {
+			t.Errorf("expected error: %v; got error: %v\n", nil, err)
+		}
+	})
+
+	t.Run("the queued request is rejected after the queue timeout", func(t *testing.T) {
+		b := newBulkhead(&BulkheadConfig{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: "10ms"})
+
+		if err := b.acquire(context.Background()); err != nil {
+			t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+		}
+
+		if err := b.acquire(context.Background()); !errors.Is(err, errBulkheadFull) {
+			t.Errorf("expected error: %v; got error: %v\n", errBulkheadFull, err)
+		}
+	})
+
+	t.Run("the nil bulkhead does not limit", func(t *testing.T) {
+		var b *bulkhead
+
+		if err := b.acquire(context.Background()); err != nil {
+			t.Errorf("expected error: %v; got error: %v\n", nil, err)
+		}
+		b.release()
+	})
+}
+
+func TestHandleBulkheadFull(t *testing.T) {
+	var (
+		block   = make(chan struct{})
+		started = make(chan struct{})
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-block
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	s := newTestService(t, srv, &ServiceConfig{
+		Name:     "mock-name",
+		Prefix:   "/api/mock",
+		Bulkhead: &BulkheadConfig{MaxConcurrent: 1, QueueTimeout: "2s"},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		ctx, _ := newTestContext(httptest.NewRequest(http.MethodGet, "/api/mock", nil))
+		s.Handle(ctx)
+		close(done)
+	}()
+
+	<-started
+
+	ctx, rec := newTestContext(httptest.NewRequest(http.MethodGet, "/api/mock", nil))
+	s.Handle(ctx)
+	ctx.WriteToResponseNow()
+
+	close(block)
+	<-done
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status code: %d; got status code: %d\n", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	if ra := rec.Header().Get(retryAfterHeader); ra != "2" {
+		t.Errorf("expected retry after: %s; got retry after: %s\n", "2", ra)
+	}
+}
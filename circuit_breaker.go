@@ -0,0 +1,348 @@
+package gateway
+
+import (
+	"sync"
+	"time"
+)
+
+type circuitState uint8
+
+const (
+	CircuitClosed circuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+const (
+	defaultBreakerWindow              = 10 * time.Second
+	defaultBreakerMinRequests         = 20
+	defaultBreakerErrorRatePercent    = 50
+	defaultBreakerSlowCallDuration    = 5 * time.Second
+	defaultBreakerSlowCallRatePercent = 100
+	defaultBreakerOpenDuration        = 30 * time.Second
+	defaultBreakerHalfOpenMaxCalls    = 5
+
+	// The sliding window is made of this many buckets.
+	breakerBuckets = 10
+
+	// The shortest window, whose buckets are still at least a millisecond long.
+	minBreakerWindow = breakerBuckets * time.Millisecond
+)
+
+var circuitStateTexts = map[circuitState]string{
+	CircuitClosed:   "closed",
+	CircuitOpen:     "open",
+	CircuitHalfOpen: "halfOpen",
+}
+
+// CircuitBreakerConfig describes the circuit breaker of a service. The breaker
+// opens if too many of the requests in the sliding window failed – by 5xx or
+// connection error – or were slow. While it is open, the requests are rejected
+// right away. After the open duration, a few probe requests are let through:
+// if all of them succeed the breaker closes, otherwise it opens again.
+// Every field is optional, and has a sensible default.
+type CircuitBreakerConfig struct {
+	// Disables the automatic opening of the breaker.
+	// It can still be opened manually by the system API.
+	Disabled bool `json:"disabled"`
+
+	// The length of the sliding window, and the minimum count of requests in
+	// it, which are needed to evaluate the rates.
+	Window      string `json:"window"`
+	MinRequests int    `json:"minRequests"`
+
+	// The percentage of the failed requests, which opens the breaker.
+	ErrorRatePercent int `json:"errorRatePercent"`
+
+	// The requests lasting longer than the duration are slow. The percentage
+	// of the slow requests, which opens the breaker.
+	SlowCallDuration    string `json:"slowCallDuration"`
+	SlowCallRatePercent int    `json:"slowCallRatePercent"`
+
+	// How long the breaker stays open, before it lets the probes through.
+	OpenDuration string `json:"openDuration"`
+
+	// The count of probe requests in half-open state.
+	HalfOpenMaxCalls int `json:"halfOpenMaxCalls"`
+}
+
+type breakerBucket struct {
+	start    time.Time
+	requests int
+	failures int
+	slow     int
+}
+
+type circuitBreaker struct {
+	mu sync.Mutex
+
+	disabled bool
+
+	window              time.Duration
+	minRequests         int
+	errorRatePercent    int
+	slowCallDuration    time.Duration
+	slowCallRatePercent int
+	openDuration        time.Duration
+	halfOpenMaxCalls    int
+
+	state circuitState
+
+	// The state is set manually, so it is not changed automatically.
+	forced bool
+
+	openedAt        time.Time
+	lastStateChange time.Time
+
+	// The count of probes let through, and succeeded in half-open state.
+	probes         int
+	probeSuccesses int
+
+	buckets [breakerBuckets]breakerBucket
+}
+
+// newCircuitBreaker creates the breaker from the given – already validated – config.
+func newCircuitBreaker(conf *CircuitBreakerConfig) *circuitBreaker {
+	cb := &circuitBreaker{
+		window:              defaultBreakerWindow,
+		minRequests:         defaultBreakerMinRequests,
+		errorRatePercent:    defaultBreakerErrorRatePercent,
+		slowCallDuration:    defaultBreakerSlowCallDuration,
+		slowCallRatePercent: defaultBreakerSlowCallRatePercent,
+		openDuration:        defaultBreakerOpenDuration,
+		halfOpenMaxCalls:    defaultBreakerHalfOpenMaxCalls,
+		state:               CircuitClosed,
+	}
+
+	if conf == nil {
+		return cb
+	}
+
+	cb.disabled = conf.Disabled
+
+	if d, err := time.ParseDuration(conf.Window); err == nil && d >= minBreakerWindow {
+		cb.window = d
+	}
+
+	if conf.MinRequests > 0 {
+		cb.minRequests = conf.MinRequests
+	}
+
+	if conf.ErrorRatePercent > 0 {
+		cb.errorRatePercent = conf.ErrorRatePercent
+	}
+
+	if d, err := time.ParseDuration(conf.SlowCallDuration); err == nil {
+		cb.slowCallDuration = d
+	}
+
+	if conf.SlowCallRatePercent > 0 {
+		cb.slowCallRatePercent = conf.SlowCallRatePercent
+	}
+
+	if d, err := time.ParseDuration(conf.OpenDuration); err == nil {
+		cb.openDuration = d
+	}
+
+	if conf.HalfOpenMaxCalls > 0 {
+		cb.halfOpenMaxCalls = conf.HalfOpenMaxCalls
+	}
+
+	return cb
+}
+
+// validateCircuitBreaker validates the given config.
+// It returns the first error that occured.
+func validateCircuitBreaker(conf *CircuitBreakerConfig) error {
+	if conf == nil {
+		return nil
+	}
+
+	for _, d := range []string{conf.Window, conf.SlowCallDuration, conf.OpenDuration} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return errBadBreakerDuration
+		}
+	}
+
+	if d, err := time.ParseDuration(conf.Window); err == nil && d < minBreakerWindow {
+		return errBadBreakerWindow
+	}
+
+	for _, p := range []int{conf.ErrorRatePercent, conf.SlowCallRatePercent} {
+		if p < 0 || p > 100 {
+			return errBadBreakerRate
+		}
+	}
+
+	if conf.MinRequests < 0 || conf.HalfOpenMaxCalls < 0 {
+		return errBadBreakerThreshold
+	}
+
+	return nil
+}
+
+// allow tells if the request can be sent to the service. Every allowed
+// request must be followed by a call of record with its result.
+func (cb *circuitBreaker) allow(now time.Time) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen {
+		if cb.forced || now.Sub(cb.openedAt) < cb.openDuration {
+			return false
+		}
+		cb.setStateLocked(CircuitHalfOpen, now)
+	}
+
+	if cb.state == CircuitHalfOpen {
+		if cb.probes >= cb.halfOpenMaxCalls {
+			return false
+		}
+		cb.probes++
+	}
+
+	return true
+}
+
+// record records the result of an allowed request.
+func (cb *circuitBreaker) record(failed bool, latency time.Duration, now time.Time) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	isSlow := latency >= cb.slowCallDuration
+
+	switch cb.state {
+	case CircuitHalfOpen:
+		if failed || isSlow {
+			cb.setStateLocked(CircuitOpen, now)
+			return
+		}
+
+		cb.probeSuccesses++
+		if cb.probeSuccesses >= cb.halfOpenMaxCalls {
+			cb.setStateLocked(CircuitClosed, now)
+		}
+	case CircuitClosed:
+		b := cb.getBucketLocked(now)
+
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if isSlow {
+			b.slow++
+		}
+
+		if cb.disabled || cb.forced {
+			return
+		}
+
+		if cb.shouldOpenLocked(now) {
+			cb.setStateLocked(CircuitOpen, now)
+		}
+	}
+}
+
+// getBucketLocked returns the bucket of the given time, resetting it, if it is stale.
+func (cb *circuitBreaker) getBucketLocked(now time.Time) *breakerBucket {
+	var (
+		size  = cb.window / breakerBuckets
+		start = now.Truncate(size)
+		b     = &cb.buckets[(start.UnixNano()/int64(size))%breakerBuckets]
+	)
+
+	if !b.start.Equal(start) {
+		*b = breakerBucket{start: start}
+	}
+
+	return b
+}
+
+// shouldOpenLocked evaluates the rates of the sliding window.
+func (cb *circuitBreaker) shouldOpenLocked(now time.Time) bool {
+	var requests, failures, slow int
+
+	for _, b := range cb.buckets {
+		if now.Sub(b.start) >= cb.window {
+			continue
+		}
+		requests += b.requests
+		failures += b.failures
+		slow += b.slow
+	}
+
+	if requests == 0 || requests < cb.minRequests {
+		return false
+	}
+
+	return failures*100 >= cb.errorRatePercent*requests ||
+		slow*100 >= cb.slowCallRatePercent*requests
+}
+
+func (cb *circuitBreaker) setStateLocked(state circuitState, now time.Time) {
+	cb.state = state
+	cb.lastStateChange = now
+	cb.probes = 0
+	cb.probeSuccesses = 0
+
+	switch state {
+	case CircuitOpen:
+		cb.openedAt = now
+	case CircuitClosed:
+		cb.buckets = [breakerBuckets]breakerBucket{}
+	}
+}
+
+// setState sets the state manually. The manually opened breaker stays open
+// until it is changed manually again, while the other states are left
+// to the automatic transitions.
+func (cb *circuitBreaker) setState(state circuitState, now time.Time) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.forced = state == CircuitOpen
+	cb.setStateLocked(state, now)
+}
+
+// getRetryAfter returns the time left until the probes are let through.
+func (cb *circuitBreaker) getRetryAfter(now time.Time) time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != CircuitOpen || cb.forced {
+		return cb.openDuration
+	}
+
+	return cb.openedAt.Add(cb.openDuration).Sub(now)
+}
+
+// getInfo returns the public view of the breaker.
+func (cb *circuitBreaker) getInfo() *CircuitBreakerInfo {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	info := &CircuitBreakerInfo{
+		State:  circuitStateTexts[cb.state],
+		Forced: cb.forced,
+	}
+
+	if !cb.lastStateChange.IsZero() {
+		since := cb.lastStateChange
+		info.Since = &since
+	}
+
+	return info
+}
+
+// getCircuitState returns the state by its text.
+func getCircuitState(text string) (circuitState, bool) {
+	for state, t := range circuitStateTexts {
+		if t == text {
+			return state, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,313 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestValidateCircuitBreaker(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *CircuitBreakerConfig
+		err  error
+	}
+
+	tt := []testCase{
+		{
+			name: "the function returns nil if there is no config",
+			conf: nil,
+			err:  nil,
+		},
+		{
+			name: "the function returns error if the window is invalid",
+			conf: &CircuitBreakerConfig{Window: "ten seconds"},
+			err:  errBadBreakerDuration,
+		},
+		{
+			name: "the function returns error if the window is too short for its buckets",
+			conf: &CircuitBreakerConfig{Window: "5ns"},
+			err:  errBadBreakerWindow,
+		},
+		{
+			name: "the function returns error if a rate is above 100",
+			conf: &CircuitBreakerConfig{SlowCallRatePercent: 120},
+			err:  errBadBreakerRate,
+		},
+		{
+			name: "the function returns error if a threshold is negative",
+			conf: &CircuitBreakerConfig{HalfOpenMaxCalls: -1},
+			err:  errBadBreakerThreshold,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &CircuitBreakerConfig{Window: "30s", MinRequests: 5, ErrorRatePercent: 25, SlowCallDuration: "1s", OpenDuration: "10s"},
+			err:  nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateCircuitBreaker(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerRecord(t *testing.T) {
+	type call struct {
+		failed  bool
+		latency time.Duration
+	}
+
+	type testCase struct {
+		name     string
+		conf     *CircuitBreakerConfig
+		calls    []call
+		expState circuitState
+	}
+
+	var (
+		fail = call{failed: true}
+		slow = call{latency: 2 * time.Second}
+		ok   = call{}
+	)
+
+	conf := &CircuitBreakerConfig{MinRequests: 4, ErrorRatePercent: 50, SlowCallDuration: "1s", SlowCallRatePercent: 75}
+
+	tt := []testCase{
+		{
+			name:     "the breaker stays closed below the minimum count of requests",
+			conf:     conf,
+			calls:    []call{fail, fail, fail},
+			expState: CircuitClosed,
+		},
+		{
+			name:     "the breaker opens by the error rate",
+			conf:     conf,
+			calls:    []call{ok, fail, ok, fail},
+			expState: CircuitOpen,
+		},
+		{
+			name:     "the breaker opens by the slow call rate",
+			conf:     conf,
+			calls:    []call{slow, slow, ok, slow},
+			expState: CircuitOpen,
+		},
+		{
+			name:     "the breaker stays closed below the rates",
+			conf:     conf,
+			calls:    []call{ok, fail, slow, ok, ok},
+			expState: CircuitClosed,
+		},
+		{
+			name:     "the disabled breaker never opens",
+			conf:     &CircuitBreakerConfig{Disabled: true, MinRequests: 1},
+			calls:    []call{fail, fail},
+			expState: CircuitClosed,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				cb  = newCircuitBreaker(tc.conf)
+				now = time.Now()
+			)
+
+			for _, c := range tc.calls {
+				if cb.allow(now) {
+					cb.record(c.failed, c.latency, now)
+				}
+			}
+
+			if cb.state != tc.expState {
+				t.Errorf("expected state: %s; got state: %s\n", circuitStateTexts[tc.expState], circuitStateTexts[cb.state])
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	var (
+		cb  = newCircuitBreaker(&CircuitBreakerConfig{MinRequests: 1, OpenDuration: "10s", HalfOpenMaxCalls: 2})
+		now = time.Now()
+	)
+
+	cb.allow(now)
+	cb.record(true, 0, now)
+
+	if cb.allow(now.Add(5 * time.Second)) {
+		t.Fatalf("expected the open breaker to reject the request\n")
+	}
+
+	// After the open duration only the probes are let through.
+	now = now.Add(10 * time.Second)
+
+	if !cb.allow(now) || !cb.allow(now) {
+		t.Fatalf("expected the probes to be let through\n")
+	}
+
+	if cb.allow(now) {
+		t.Fatalf("expected the request above the probes to be rejected\n")
+	}
+
+	cb.record(false, 0, now)
+	cb.record(false, 0, now)
+
+	if cb.state != CircuitClosed {
+		t.Fatalf("expected state: %s; got state: %s\n", "closed", circuitStateTexts[cb.state])
+	}
+
+	// A failed probe opens the breaker again.
+	cb.allow(now)
+	cb.record(true, 0, now)
+
+	now = now.Add(10 * time.Second)
+
+	cb.allow(now)
+	cb.record(true, 0, now)
+
+	if cb.state != CircuitOpen {
+		t.Errorf("expected state: %s; got state: %s\n", "open", circuitStateTexts[cb.state])
+	}
+}
+
+func TestCircuitBreakerForced(t *testing.T) {
+	var (
+		cb  = newCircuitBreaker(&CircuitBreakerConfig{OpenDuration: "1s"})
+		now = time.Now()
+	)
+
+	cb.setState(CircuitOpen, now)
+
+	if cb.allow(now.Add(time.Hour)) {
+		t.Fatalf("expected the manually opened breaker to stay open\n")
+	}
+
+	cb.setState(CircuitClosed, now)
+
+	if !cb.allow(now) {
+		t.Errorf("expected the manually closed breaker to let the request through\n")
+	}
+}
+
+func TestHandleCircuitOpen(t *testing.T) {
+	var requests int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	s := newTestService(t, srv, &ServiceConfig{
+		Name:           "mock-name",
+		Prefix:         "/api/mock",
+		CircuitBreaker: &CircuitBreakerConfig{OpenDuration: "30s"},
+	})
+
+	s.breaker.setState(CircuitOpen, time.Now())
+
+	ctx, rec := newTestContext(httptest.NewRequest(http.MethodGet, "/api/mock/foo", nil))
+
+	s.Handle(ctx)
+	ctx.WriteToResponseNow()
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status code: %d; got status code: %d\n", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	if got := rec.Header().Get(retryAfterHeader); got != "30" {
+		t.Errorf("expected retry after: %s; got retry after: %s\n", "30", got)
+	}
+
+	if got := atomic.LoadInt32(&requests); got != 0 {
+		t.Errorf("expected requests: %d; got requests: %d\n", 0, got)
+	}
+}
+
+// abortedBody is the body of an upload, which the client aborts after the first part.
+type abortedBody struct {
+	isRead bool
+}
+
+func (ab *abortedBody) Read(p []byte) (int, error) {
+	if ab.isRead {
+		return 0, errors.New("mock-aborted")
+	}
+
+	ab.isRead = true
+
+	return copy(p, "mock-part"), nil
+}
+
+func TestHandleClientCancel(t *testing.T) {
+	type testCase struct {
+		name       string
+		getRequest func() *http.Request
+		expState   string
+	}
+
+	tt := []testCase{
+		{
+			name: "the request canceled by the client keeps the breaker closed",
+			getRequest: func() *http.Request {
+				reqCtx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				return httptest.NewRequest(http.MethodGet, "/api/mock/foo", nil).WithContext(reqCtx)
+			},
+			expState: circuitStateTexts[CircuitClosed],
+		},
+		{
+			name: "the upload aborted by the client keeps the breaker closed",
+			getRequest: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/api/mock/foo", &abortedBody{})
+			},
+			expState: circuitStateTexts[CircuitClosed],
+		},
+		{
+			name: "the failed request opens the breaker",
+			getRequest: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/api/mock/foo", nil)
+			},
+			expState: circuitStateTexts[CircuitOpen],
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// The instance reads the whole body, then drops the connection.
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+
+				if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+					conn.Close()
+				}
+			}))
+			defer srv.Close()
+
+			s := newTestService(t, srv, &ServiceConfig{
+				Name:           "mock-name",
+				Prefix:         "/api/mock",
+				CircuitBreaker: &CircuitBreakerConfig{MinRequests: 1, ErrorRatePercent: 50},
+			})
+			defer s.close()
+
+			ctx, _ := newTestContext(tc.getRequest())
+
+			s.Handle(ctx)
+
+			if state := s.breaker.getInfo().State; state != tc.expState {
+				t.Errorf("expected state: %s; got state: %s\n", tc.expState, state)
+			}
+		})
+	}
+}
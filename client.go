@@ -1,9 +1,9 @@
 package gateway
 
 import (
+	"context"
 	"io"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -22,7 +22,7 @@ type client struct {
 
 type httpClient interface {
 	doRequest(string, string, io.Reader, ...http.Header) (*http.Response, error)
-	pipe(method string, url string, header http.Header, body io.Reader) (*http.Response, error)
+	pipe(ctx context.Context, method string, url string, header http.Header, body io.Reader, contentLength int64) (*http.Response, error)
 	Do(*http.Request) (*http.Response, error)
 }
 
@@ -34,6 +34,14 @@ func withHostName(hname string) httpClientOptionFunc {
 	}
 }
 
+func withTransport(rt http.RoundTripper) httpClientOptionFunc {
+	return func(hc *client) {
+		if rt != nil {
+			hc.Client.Transport = rt
+		}
+	}
+}
+
 func withTimeOut(tOut time.Duration) httpClientOptionFunc {
 	return func(hc *client) {
 		hc.Client.Timeout = tOut
@@ -59,10 +67,15 @@ func newHttpClient(opts ...httpClientOptionFunc) httpClient {
 }
 
 type reqConfig struct {
+	ctx    context.Context
 	method string
 	url    string
 	header http.Header
 	body   io.Reader
+
+	// The length of the body, if it is known, but can not be
+	// told from the reader. Otherwise the body is sent chunked.
+	contentLength int64
 }
 
 func (cl *client) doRequest(method string, url string, body io.Reader, header ...http.Header) (*http.Response, error) {
@@ -74,6 +87,7 @@ func (cl *client) doRequest(method string, url string, body io.Reader, header ..
 	}()
 
 	return cl.do(reqConfig{
+		ctx:    context.Background(),
 		method: method,
 		url:    cl.hostName + url,
 		body:   body,
@@ -81,30 +95,55 @@ func (cl *client) doRequest(method string, url string, body io.Reader, header ..
 	})
 }
 
-func (cl *client) pipe(method string, url string, header http.Header, body io.Reader) (*http.Response, error) {
-	return cl.do(reqConfig{
-		method: method,
-		url:    cl.hostName + url,
-		header: header,
-		body:   body,
+// pipe sends the proxied request. Its timeout is applied by the given ctx instead
+// of the timeout of the client, so the streamed responses are not cut by it.
+func (cl *client) pipe(ctx context.Context, method string, url string, header http.Header, body io.Reader, contentLength int64) (*http.Response, error) {
+	req, err := newRequest(reqConfig{
+		ctx:           ctx,
+		method:        method,
+		url:           cl.hostName + url,
+		header:        header,
+		body:          body,
+		contentLength: contentLength,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	untimed := *cl.Client
+	untimed.Timeout = 0
+
+	return untimed.Do(req)
 }
 
 func (cl *client) do(conf reqConfig) (*http.Response, error) {
-	req, err := http.NewRequest(conf.method, conf.url, conf.body)
+	req, err := newRequest(conf)
 	if err != nil {
 		return nil, err
 	}
 
-	// If there is customHeader, then we add the missing ones.
+	return cl.Do(req)
+}
+
+func newRequest(conf reqConfig) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(conf.ctx, conf.method, conf.url, conf.body)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Body != nil && req.ContentLength == 0 && conf.contentLength > 0 {
+		req.ContentLength = conf.contentLength
+	}
+
+	// If there is customHeader, then we add the missing ones,
+	// keeping each value of the multi-valued headers.
 	if conf.header != nil {
 		for k, v := range conf.header {
-			req.Header.Add(k, strings.Join(v, "; "))
+			for _, value := range v {
+				req.Header.Add(k, value)
+			}
 		}
 	}
 
-	// Always close each request after it is done.
-	req.Close = true
-
-	return cl.Do(req)
+	return req, nil
 }
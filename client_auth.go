@@ -0,0 +1,315 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultSubjectHeader     = "X-Client-Cert-Subject"
+	defaultSANHeader         = "X-Client-Cert-SAN"
+	defaultFingerprintHeader = "X-Client-Cert-Fingerprint"
+)
+
+// ClientAuthConfig is the verification of the client certificates on the
+// listener of the Gateway – mutual TLS.
+type ClientAuthConfig struct {
+	// The path of the PEM encoded CA bundle, which the client certificates
+	// are verified by. It is reloaded, once it is changed.
+	CAFile string `json:"caFile"`
+
+	// The clients without a certificate are rejected in the handshake. By default
+	// the certificate is optional, but if one is presented, it must be valid.
+	Required bool `json:"required"`
+
+	// The headers, which the identity of the client is sent to the services in.
+	Headers *IdentityHeadersConfig `json:"headers"`
+}
+
+// IdentityHeadersConfig is the names of the headers, which carry the verified
+// identity of the client to the services. The headers sent by the clients are
+// removed, unless they came from a trusted proxy.
+type IdentityHeadersConfig struct {
+	// The distinguished name of the subject – eg. CN=partner,O=Acme.
+	// By default it is X-Client-Cert-Subject.
+	Subject string `json:"subject"`
+
+	// The comma separated alternative names – DNS names, emails, URIs and IPs.
+	// By default it is X-Client-Cert-SAN.
+	SAN string `json:"san"`
+
+	// The hex encoded SHA-256 fingerprint of the certificate.
+	// By default it is X-Client-Cert-Fingerprint.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// ClientCertPolicyConfig is the client certificates, which may access a service.
+// A certificate is allowed, if any of its names is listed.
+type ClientCertPolicyConfig struct {
+	// The common names – eg. partner – or the distinguished
+	// names – eg. CN=partner,O=Acme – of the subjects.
+	Subjects []string `json:"subjects"`
+
+	// The alternative names – DNS names, emails, URIs or IPs.
+	SANs []string `json:"sans"`
+}
+
+// clientAuth is the parsed, ready to use form of ClientAuthConfig.
+type clientAuth struct {
+	required bool
+	roots    *certPool
+	headers  IdentityHeadersConfig
+}
+
+// clientIdentity is the identity of a verified client certificate.
+type clientIdentity struct {
+	subject     string
+	commonName  string
+	sans        []string
+	fingerprint string
+}
+
+// clientCertPolicy is the parsed, ready to use form of ClientCertPolicyConfig.
+type clientCertPolicy struct {
+	subjects []string
+	sans     []string
+}
+
+// newClientAuth creates the client authentication from the given – already
+// validated – config. It returns nil, if there is no config.
+func newClientAuth(conf *ClientAuthConfig) *clientAuth {
+	if conf == nil {
+		return nil
+	}
+
+	ca := &clientAuth{
+		required: conf.Required,
+		roots:    newCertPool(conf.CAFile),
+		headers: IdentityHeadersConfig{
+			Subject:     defaultSubjectHeader,
+			SAN:         defaultSANHeader,
+			Fingerprint: defaultFingerprintHeader,
+		},
+	}
+
+	if h := conf.Headers; h != nil {
+		ca.headers.Subject = getStringOr(h.Subject, ca.headers.Subject)
+		ca.headers.SAN = getStringOr(h.SAN, ca.headers.SAN)
+		ca.headers.Fingerprint = getStringOr(h.Fingerprint, ca.headers.Fingerprint)
+	}
+
+	return ca
+}
+
+// validateClientAuth validates the given config, and reads its CA bundle.
+// It returns the first error that occured.
+func validateClientAuth(conf *ClientAuthConfig) error {
+	if conf == nil {
+		return nil
+	}
+
+	if conf.CAFile == "" {
+		return errNoClientCA
+	}
+
+	if _, err := readCertPool(conf.CAFile); err != nil {
+		return err
+	}
+
+	if h := conf.Headers; h != nil {
+		for _, name := range []string{h.Subject, h.SAN, h.Fingerprint} {
+			if name != "" && !isToken(name) {
+				return errBadIdentityHeader
+			}
+		}
+	}
+
+	return nil
+}
+
+// isToken tells if the given value is a valid token – eg. a header name. See RFC 7230.
+func isToken(v string) bool {
+	if v == "" {
+		return false
+	}
+
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getStringOr returns v, if it is not empty, otherwise the given default.
+func getStringOr(v string, def string) string {
+	if v != "" {
+		return v
+	}
+	return def
+}
+
+// configure sets the verification of the client certificates on the given config.
+// The CA bundle is read on each handshake, if it changed, so it can be rotated
+// without a restart.
+func (ca *clientAuth) configure(conf *tls.Config) {
+	conf.ClientAuth = tls.VerifyClientCertIfGiven
+	if ca.required {
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	conf.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := conf.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = ca.roots.get()
+
+		return c, nil
+	}
+}
+
+// getNames returns the names of the identity headers.
+func (ca *clientAuth) getNames() []string {
+	return []string{ca.headers.Subject, ca.headers.SAN, ca.headers.Fingerprint}
+}
+
+// setClientIdentity stores the identity of the verified client certificate of the
+// request, and sets it in the identity headers. The identity headers sent by the
+// client are removed, unless it is a trusted proxy, and there is no certificate.
+func (lt *listenerTLS) setClientIdentity(r *http.Request, info *clientInfo) {
+	if lt == nil || lt.clientAuth == nil {
+		return
+	}
+
+	ca := lt.clientAuth
+
+	info.identity = getClientIdentity(r.TLS)
+
+	if info.identity == nil && info.isPeerTrusted {
+		return
+	}
+
+	for _, name := range ca.getNames() {
+		r.Header.Del(name)
+	}
+
+	if id := info.identity; id != nil {
+		r.Header.Set(ca.headers.Subject, id.subject)
+		r.Header.Set(ca.headers.Fingerprint, id.fingerprint)
+
+		if len(id.sans) > 0 {
+			r.Header.Set(ca.headers.SAN, strings.Join(id.sans, ", "))
+		}
+	}
+}
+
+// getClientIdentity returns the identity of the verified client
+// certificate of the connection, or nil if there is none.
+func getClientIdentity(state *tls.ConnectionState) *clientIdentity {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return newClientIdentity(state.VerifiedChains[0][0])
+}
+
+// newClientIdentity returns the identity of the given certificate.
+func newClientIdentity(cert *x509.Certificate) *clientIdentity {
+	sum := sha256.Sum256(cert.Raw)
+
+	id := &clientIdentity{
+		subject:     cert.Subject.String(),
+		commonName:  cert.Subject.CommonName,
+		fingerprint: hex.EncodeToString(sum[:]),
+	}
+
+	id.sans = append(id.sans, cert.DNSNames...)
+	id.sans = append(id.sans, cert.EmailAddresses...)
+
+	for _, u := range cert.URIs {
+		id.sans = append(id.sans, u.String())
+	}
+
+	for _, ip := range cert.IPAddresses {
+		id.sans = append(id.sans, ip.String())
+	}
+
+	return id
+}
+
+// newClientCertPolicy creates the policy from the given – already
+// validated – config. It returns nil, if there is no config.
+func newClientCertPolicy(conf *ClientCertPolicyConfig) *clientCertPolicy {
+	if conf == nil {
+		return nil
+	}
+
+	return &clientCertPolicy{
+		subjects: conf.Subjects,
+		sans:     conf.SANs,
+	}
+}
+
+// validateClientCertPolicy validates the given config.
+func validateClientCertPolicy(conf *ClientCertPolicyConfig) error {
+	if conf == nil {
+		return nil
+	}
+
+	if len(conf.Subjects) == 0 && len(conf.SANs) == 0 {
+		return errEmptyClientCertPolicy
+	}
+
+	return nil
+}
+
+// allows tells if the given identity may access the service.
+func (p *clientCertPolicy) allows(id *clientIdentity) bool {
+	if id == nil {
+		return false
+	}
+
+	for _, subject := range p.subjects {
+		if subject == id.commonName || subject == id.subject {
+			return true
+		}
+	}
+
+	for _, san := range id.sans {
+		if includes(p.sans, san) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorizeClientCert checks the client certificate of the request against the
+// policy of the service. If it is not allowed, it sends HTTP 401 – or 403, if
+// there is a verified certificate – and returns false.
+func (s *service) authorizeClientCert(ctx Context) bool {
+	if s.clientCert == nil {
+		return true
+	}
+
+	id := getRequestClientInfo(ctx.GetRequest()).identity
+
+	if id == nil {
+		ctx.SendRaw([]byte(http.StatusText(http.StatusUnauthorized)), http.StatusUnauthorized, http.Header{})
+
+		return false
+	}
+
+	if !s.clientCert.allows(id) {
+		ctx.Warning("[Handle]: client certificate %s is not allowed to access service %s", id.subject, s.Name)
+		ctx.SendRaw([]byte(http.StatusText(http.StatusForbidden)), http.StatusForbidden, http.Header{})
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,256 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// newTestClientCert issues a client certificate for the given name.
+func newTestClientCert(t *testing.T, ca *testCA, name string) tls.Certificate {
+	certPEM, keyPEM := ca.issue(t, name, x509.ExtKeyUsageClientAuth)
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	return cert
+}
+
+func TestValidateClientAuth(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *ClientAuthConfig
+		err  error
+	}
+
+	var (
+		dir    = t.TempDir()
+		caFile = writeTestFile(t, dir, "ca.pem", newTestCA(t).pem)
+	)
+
+	tt := []testCase{
+		{
+			name: "the function returns error if the CA file is not given",
+			conf: &ClientAuthConfig{Required: true},
+			err:  errNoClientCA,
+		},
+		{
+			name: "the function returns error if the CA file does not exist",
+			conf: &ClientAuthConfig{CAFile: filepath.Join(dir, "missing.pem")},
+			err:  errBadTLSFile,
+		},
+		{
+			name: "the function returns error if the header name is invalid",
+			conf: &ClientAuthConfig{CAFile: caFile, Headers: &IdentityHeadersConfig{Subject: "X-Client Subject"}},
+			err:  errBadIdentityHeader,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &ClientAuthConfig{CAFile: caFile, Headers: &IdentityHeadersConfig{SAN: "X-Partner-SAN"}},
+			err:  nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateClientAuth(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestSetClientIdentity(t *testing.T) {
+	type testCase struct {
+		name          string
+		remoteAddr    string
+		hasCert       bool
+		expSubject    string
+		expHasForgery bool
+	}
+
+	ca := newTestCA(t)
+
+	tp, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	certPEM, _ := ca.issue(t, "partner", x509.ExtKeyUsageClientAuth)
+	block, _ := pem.Decode(certPEM)
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	lt := &listenerTLS{clientAuth: newClientAuth(&ClientAuthConfig{CAFile: "ca.pem"})}
+
+	tt := []testCase{
+		{
+			name:       "the headers of an untrusted peer are removed",
+			remoteAddr: "1.2.3.4:1234",
+			expSubject: "",
+		},
+		{
+			name:          "the headers of a trusted peer are kept",
+			remoteAddr:    "10.0.0.1:1234",
+			expSubject:    "CN=forged",
+			expHasForgery: true,
+		},
+		{
+			name:       "the headers are replaced by the verified certificate",
+			remoteAddr: "10.0.0.1:1234",
+			hasCert:    true,
+			expSubject: "CN=partner",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/mock", nil)
+			r.RemoteAddr = tc.remoteAddr
+			r.Header.Set(defaultSubjectHeader, "CN=forged")
+			r.Header.Set(defaultFingerprintHeader, "forged")
+
+			if tc.hasCert {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert, ca.cert}}}
+			}
+
+			info := tp.getClientInfo(r)
+			lt.setClientIdentity(r, info)
+
+			if subject := r.Header.Get(defaultSubjectHeader); subject != tc.expSubject {
+				t.Errorf("expected subject: %s; got subject: %s\n", tc.expSubject, subject)
+			}
+
+			if hasForgery := r.Header.Get(defaultFingerprintHeader) == "forged"; hasForgery != tc.expHasForgery {
+				t.Errorf("expected forgery: %t; got forgery: %t\n", tc.expHasForgery, hasForgery)
+			}
+
+			if hasIdentity := info.identity != nil; hasIdentity != tc.hasCert {
+				t.Errorf("expected identity: %t; got identity: %t\n", tc.hasCert, hasIdentity)
+			}
+		})
+	}
+}
+
+func TestClientCertPolicy(t *testing.T) {
+	type testCase struct {
+		name       string
+		cert       *tls.Certificate
+		expStatus  int
+		expSubject string
+		expSAN     string
+	}
+
+	var (
+		dir    = t.TempDir()
+		ca     = newTestCA(t)
+		caFile = writeTestFile(t, dir, "ca.pem", ca.pem)
+
+		partner = newTestClientCert(t, ca, "partner.example.com")
+		other   = newTestClientCert(t, ca, "other.example.com")
+		foreign = newTestClientCert(t, newTestCA(t), "partner.example.com")
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Subject", r.Header.Get(defaultSubjectHeader))
+		w.Header().Set("X-SAN", r.Header.Get(defaultSANHeader))
+	}))
+	defer srv.Close()
+
+	gw := newTestGateway(t, srv, &ServiceConfig{
+		Name:       "mock-name",
+		Prefix:     "/api/mock",
+		ClientCert: &ClientCertPolicyConfig{SANs: []string{"partner.example.com"}},
+	})
+
+	gw.listenerTLS = newListenerTLS(&ListenerTLSConfig{
+		Certificates: []*CertificateConfig{writeTestCertificate(t, ca, dir, "example.com")},
+		ClientAuth:   &ClientAuthConfig{CAFile: caFile},
+	})
+
+	gwSrv := httptest.NewUnstartedServer(gw)
+
+	// The failed handshakes are expected by the tests.
+	gwSrv.Config.ErrorLog = log.New(io.Discard, "", 0)
+
+	gwSrv.TLS = gw.listenerTLS.getConfig()
+	gwSrv.StartTLS()
+	defer gwSrv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tt := []testCase{
+		{
+			name:       "the allowed certificate is proxied with its identity",
+			cert:       &partner,
+			expStatus:  http.StatusOK,
+			expSubject: "CN=partner.example.com",
+			expSAN:     "partner.example.com, 127.0.0.1",
+		},
+		{
+			name:      "the request without certificate is unauthorized",
+			cert:      nil,
+			expStatus: http.StatusUnauthorized,
+		},
+		{
+			name:      "the not listed certificate is forbidden",
+			cert:      &other,
+			expStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			tlsConf := &tls.Config{RootCAs: roots, ServerName: "example.com"}
+			if tc.cert != nil {
+				tlsConf.Certificates = []tls.Certificate{*tc.cert}
+			}
+
+			cl := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf}}
+
+			res, err := cl.Get(gwSrv.URL + "/api/mock/foo")
+			if err != nil {
+				t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.expStatus {
+				t.Errorf("expected status code: %d; got status code: %d\n", tc.expStatus, res.StatusCode)
+			}
+
+			if subject := res.Header.Get("X-Subject"); subject != tc.expSubject {
+				t.Errorf("expected subject: %s; got subject: %s\n", tc.expSubject, subject)
+			}
+
+			if san := res.Header.Get("X-SAN"); san != tc.expSAN {
+				t.Errorf("expected san: %s; got san: %s\n", tc.expSAN, san)
+			}
+		})
+	}
+
+	t.Run("the certificate of an unknown CA is rejected in the handshake", func(t *testing.T) {
+		cl := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "example.com",
+			Certificates: []tls.Certificate{foreign},
+		}}}
+
+		if res, err := cl.Get(gwSrv.URL + "/api/mock/foo"); err == nil {
+			res.Body.Close()
+			t.Errorf("expected error; got status code: %d\n", res.StatusCode)
+		}
+	})
+}
@@ -30,7 +30,43 @@ type GatewayConfig struct {
 	LoggerConfig        *LoggerConfig    `json:"loggerConfig"`
 	GrpcProxy           *GrpcProxyConfig `json:"grpcProxy"`
 
-	Services []*ServiceConfig `json:"services"`
+	// The maximum count of healthchecks running at the same time.
+	HealthCheckConcurrency int `json:"healthCheckConcurrency"`
+
+	// The allowed difference between the timestamps of the signed
+	// system requests and the clock of the Gateway, eg. 5m.
+	SignatureMaxSkew string `json:"signatureMaxSkew"`
+
+	// If it is given, the config file is watched for changes
+	// with this interval, and reloaded automatically.
+	ConfigWatchInterval string `json:"configWatchInterval"`
+
+	Services      []*ServiceConfig      `json:"services"`
+	TrafficSplits []*TrafficSplitConfig `json:"trafficSplits"`
+
+	// The validators of the bearer tokens, each bound to a prefix – eg. of a service.
+	JWT []*JWTConfig `json:"jwt"`
+
+	// The consumers, who are authenticated by their API keys, and the
+	// JSON file of further consumers, which is reloaded once it changes.
+	Consumers     []*ConsumerConfig `json:"consumers"`
+	ConsumersFile string            `json:"consumersFile"`
+
+	// The authenticators of the API keys, each bound to a prefix – eg. of a service.
+	APIKeys []*APIKeyConfig `json:"apiKeys"`
+
+	// The rate limiters, each bound to a prefix – eg. of a service.
+	RateLimits []*RateLimitConfig `json:"rateLimits"`
+
+	// The IPs and CIDRs of the proxies in front of the Gateway,
+	// whose forwarding headers – eg. X-Forwarded-For – are trusted.
+	TrustedProxies []string `json:"trustedProxies"`
+
+	// If it is true, the paths with encoded slashes – eg. %2F – are rejected.
+	RejectEncodedSlashes bool `json:"rejectEncodedSlashes"`
+
+	// The TLS settings of the listener. If it is given, HTTPS is served.
+	TLS *ListenerTLSConfig `json:"tls"`
 }
 
 type duration byte
@@ -72,19 +108,24 @@ func getHealthCheckInterval(t string) time.Duration {
 // which can be passed into the New factory.
 // In case of unexpected behaviour, it returns error.
 func ReadConfig(path string) ([]GatewayOptionFunc, error) {
-	b, err := os.ReadFile(path)
+	conf, err := readConfigFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	conf, err := parseConfig(b)
+	funcs := getGatewayOptionFuncs(conf)
+
+	return funcs, nil
+}
+
+// readConfigFile reads and parses the config from the given path.
+func readConfigFile(path string) (*GatewayConfig, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	funcs := getGatewayOptionFuncs(conf)
-
-	return funcs, nil
+	return parseConfig(b)
 }
 
 func getGatewayOptionFuncs(conf *GatewayConfig) []GatewayOptionFunc {
@@ -102,6 +143,45 @@ func getGatewayOptionFuncs(conf *GatewayConfig) []GatewayOptionFunc {
 		funcs = append(funcs, WithService(conf))
 	}
 
+	// The splits must come after the services, since they refer to them.
+	for _, conf := range conf.TrafficSplits {
+		funcs = append(funcs, WithTrafficSplit(conf))
+	}
+
+	// The tokens are validated first, so the rate limiters
+	// can rely on the claims of the verified tokens.
+	for _, conf := range conf.JWT {
+		funcs = append(funcs, WithJWT(conf))
+	}
+
+	if len(conf.Consumers) > 0 {
+		funcs = append(funcs, WithConsumers(conf.Consumers...))
+	}
+
+	if conf.ConsumersFile != "" {
+		funcs = append(funcs, WithConsumersFile(conf.ConsumersFile))
+	}
+
+	for _, conf := range conf.APIKeys {
+		funcs = append(funcs, WithAPIKey(conf))
+	}
+
+	for _, conf := range conf.RateLimits {
+		funcs = append(funcs, WithRateLimit(conf))
+	}
+
+	if conf.TLS != nil {
+		funcs = append(funcs, WithTLS(conf.TLS))
+	}
+
+	if len(conf.TrustedProxies) > 0 {
+		funcs = append(funcs, WithTrustedProxies(conf.TrustedProxies...))
+	}
+
+	if conf.RejectEncodedSlashes {
+		funcs = append(funcs, WithEncodedSlashesRejected())
+	}
+
 	if conf.MiddlewaresEnabled != nil {
 		funcs = append(funcs, WithMiddlewaresEnabled(*conf.MiddlewaresEnabled))
 	}
@@ -118,21 +198,60 @@ func getGatewayOptionFuncs(conf *GatewayConfig) []GatewayOptionFunc {
 		funcs = append(funcs, WithHealthCheckFrequency(configInterval))
 	}
 
+	if conf.SignatureMaxSkew != "" {
+		funcs = append(funcs, func(g *Gateway) {
+			maxSkew, err := parseSignatureMaxSkew(conf.SignatureMaxSkew)
+			if err != nil {
+				g.logger.Warning(err.Error())
+				return
+			}
+
+			WithSignatureMaxSkew(maxSkew)(g)
+		})
+	}
+
+	if conf.HealthCheckConcurrency > 0 {
+		funcs = append(funcs, WithHealthCheckConcurrency(conf.HealthCheckConcurrency))
+	}
+
 	if conf.LoggerConfig != nil {
-		var value logTypeValue = 0
-		for _, e := range conf.LoggerConfig.DisabledLoggers {
-			val, ok := logLevelValues[e]
-			if !ok {
-				continue
+		funcs = append(funcs, WithDisabledLoggers(getDisabledLoggers(conf.LoggerConfig)))
+	}
+
+	if conf.ConfigWatchInterval != "" {
+		funcs = append(funcs, func(g *Gateway) {
+			interval, err := parseConfigWatchInterval(conf.ConfigWatchInterval)
+			if err != nil {
+				g.logger.Warning(err.Error())
+				return
 			}
-			value += val
-		}
-		funcs = append(funcs, WithDisabledLoggers(value))
+
+			WithConfigWatch(interval)(g)
+		})
 	}
 
 	return funcs
 }
 
+// getDisabledLoggers returns the sum of values of the disabled loggers.
+func getDisabledLoggers(conf *LoggerConfig) logTypeValue {
+	var value logTypeValue = 0
+
+	if conf == nil {
+		return value
+	}
+
+	for _, e := range conf.DisabledLoggers {
+		val, ok := logLevelValues[e]
+		if !ok {
+			continue
+		}
+		value |= val
+	}
+
+	return value
+}
+
 func parseConfig(b []byte) (*GatewayConfig, error) {
 	conf := &GatewayConfig{}
 	if err := json.Unmarshal(b, conf); err != nil {
@@ -0,0 +1,491 @@
+package gateway
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazskvancz/gorouter"
+)
+
+const (
+	defaultAPIKeyHeader   = "X-API-Key"
+	defaultConsumerHeader = "X-Consumer-Id"
+
+	// The ID of the authenticated consumer is bound to the Context by this key.
+	ConsumerIDKey ContextKey = "consumerId"
+
+	// The consumers file is checked for changes at most this often.
+	consumersFileCheckInterval = time.Second
+)
+
+// ConsumerConfig is a consumer of the services – eg. a partner –, who is
+// authenticated by its API keys.
+type ConsumerConfig struct {
+	// The unique ID of the consumer, which is sent to the services.
+	ID string `json:"id"`
+
+	// The hex encoded SHA-256 hashes of the API keys of the consumer – eg.
+	// by `echo -n "$KEY" | sha256sum`. The keys themselves are never stored.
+	KeyHashes []string `json:"keyHashes"`
+
+	// The prefixes of the urls – eg. of the services –, which the
+	// consumer may access. The "/" prefix allows every url.
+	Prefixes []string `json:"prefixes"`
+
+	// Arbitrary data of the consumer – eg. the name of the partner.
+	Metadata map[string]string `json:"metadata"`
+}
+
+// APIKeyConfig describes the authentication of the requests under the given
+// prefix by the API keys of the consumers. The requests without a known key are
+// rejected with HTTP 401, and the ones of not allowed prefixes with HTTP 403.
+type APIKeyConfig struct {
+	// The prefix of the urls, which are protected – eg. the prefix of a service.
+	// If it is empty, every request is protected.
+	Prefix string `json:"prefix"`
+
+	// The header, which carries the key. By default it is X-API-Key.
+	Header string `json:"header"`
+
+	// The query parameter – eg. api_key –, which carries the key, if the
+	// header is missing. By default the key is not read from the query.
+	QueryParam string `json:"queryParam"`
+
+	// The header, which the ID of the consumer is sent to the services in.
+	// It is always removed from the incoming requests. By default it is X-Consumer-Id.
+	ConsumerHeader string `json:"consumerHeader"`
+}
+
+// ConsumerInfo is a registered consumer, without its key hashes.
+type ConsumerInfo struct {
+	ID       string            `json:"id"`
+	Prefixes []string          `json:"prefixes"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Keys     int               `json:"keys"`
+
+	// The consumer was loaded from the consumers file.
+	FromFile bool `json:"fromFile"`
+}
+
+// consumer is a registered consumer.
+type consumer struct {
+	*ConsumerConfig
+
+	prefixes []string
+	fromFile bool
+}
+
+// consumerRegistry stores the consumers, and finds them by their keys. The
+// consumers are replaced as a whole on each change, which are rare, so the
+// lookups only need a read lock.
+type consumerRegistry struct {
+	mu        sync.RWMutex
+	consumers map[string]*consumer
+	keys      map[string]*consumer
+
+	fileMu        sync.Mutex
+	file          *watchedFile
+	lastFileCheck time.Time
+}
+
+// apiKeyAuthenticator is the parsed, ready to use form of APIKeyConfig.
+type apiKeyAuthenticator struct {
+	prefix         string
+	header         string
+	queryParam     string
+	consumerHeader string
+
+	consumers *consumerRegistry
+}
+
+func newConsumerRegistry() *consumerRegistry {
+	return &consumerRegistry{
+		consumers: make(map[string]*consumer),
+		keys:      make(map[string]*consumer),
+	}
+}
+
+// newConsumer creates the consumer from the given – already validated – config.
+func newConsumer(conf *ConsumerConfig, fromFile bool) *consumer {
+	c := &consumer{
+		ConsumerConfig: conf,
+		prefixes:       make([]string, len(conf.Prefixes)),
+		fromFile:       fromFile,
+	}
+
+	for i, p := range conf.Prefixes {
+		c.prefixes[i] = strings.TrimSuffix(p, "/")
+	}
+
+	return c
+}
+
+// validateConsumer validates the given config.
+// It returns the first error that occured.
+func validateConsumer(conf *ConsumerConfig) error {
+	if conf == nil {
+		return errConfigIsNil
+	}
+
+	if conf.ID == "" {
+		return errEmptyConsumerID
+	}
+
+	if len(conf.KeyHashes) == 0 {
+		return errBadConsumerKeyHash
+	}
+
+	for _, h := range conf.KeyHashes {
+		if b, err := hex.DecodeString(h); err != nil || len(b) != 32 {
+			return errBadConsumerKeyHash
+		}
+	}
+
+	if len(conf.Prefixes) == 0 {
+		return errBadConsumerPrefix
+	}
+
+	for _, p := range conf.Prefixes {
+		if !strings.HasPrefix(p, "/") {
+			return errBadConsumerPrefix
+		}
+	}
+
+	return nil
+}
+
+// validateConsumers validates the given consumers, and checks that
+// their IDs and keys are unique. It returns the first error that occured.
+func validateConsumers(confs []*ConsumerConfig) error {
+	consumers := make(map[string]*consumer, len(confs))
+
+	for _, conf := range confs {
+		if err := validateConsumer(conf); err != nil {
+			return err
+		}
+
+		if _, exists := consumers[conf.ID]; exists {
+			return errConsumerExists
+		}
+
+		consumers[conf.ID] = newConsumer(conf, false)
+	}
+
+	_, err := indexConsumerKeys(consumers)
+
+	return err
+}
+
+// readConsumersFile reads the JSON array of consumers of the given file.
+func readConsumersFile(path string) ([]*ConsumerConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errBadConsumersFile
+	}
+
+	var confs []*ConsumerConfig
+
+	if err := json.Unmarshal(b, &confs); err != nil {
+		return nil, errBadConsumersFile
+	}
+
+	if err := validateConsumers(confs); err != nil {
+		return nil, err
+	}
+
+	return confs, nil
+}
+
+// indexConsumerKeys returns the consumers by the hashes of their keys.
+// It returns error, if a key belongs to more than one consumer.
+func indexConsumerKeys(consumers map[string]*consumer) (map[string]*consumer, error) {
+	keys := make(map[string]*consumer)
+
+	for _, c := range consumers {
+		for _, h := range c.KeyHashes {
+			h = strings.ToLower(h)
+
+			if other, exists := keys[h]; exists && other != c {
+				return nil, errConsumerKeyExists
+			}
+
+			keys[h] = c
+		}
+	}
+
+	return keys, nil
+}
+
+// add registers the given consumer, or replaces the one with the same ID.
+func (cr *consumerRegistry) add(conf *ConsumerConfig) error {
+	if err := validateConsumer(conf); err != nil {
+		return err
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	consumers := cr.copyLocked(nil)
+	consumers[conf.ID] = newConsumer(conf, false)
+
+	return cr.setLocked(consumers)
+}
+
+// remove removes the consumer with the given ID.
+func (cr *consumerRegistry) remove(id string) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if _, exists := cr.consumers[id]; !exists {
+		return errConsumerNotExists
+	}
+
+	consumers := cr.copyLocked(nil)
+	delete(consumers, id)
+
+	return cr.setLocked(consumers)
+}
+
+// copyLocked returns a copy of the consumers, which the given filter keeps.
+func (cr *consumerRegistry) copyLocked(keep func(*consumer) bool) map[string]*consumer {
+	consumers := make(map[string]*consumer, len(cr.consumers))
+
+	for id, c := range cr.consumers {
+		if keep == nil || keep(c) {
+			consumers[id] = c
+		}
+	}
+
+	return consumers
+}
+
+// setLocked replaces the consumers, and rebuilds the index of their keys.
+// If a key belongs to more than one consumer, the old ones are kept.
+func (cr *consumerRegistry) setLocked(consumers map[string]*consumer) error {
+	keys, err := indexConsumerKeys(consumers)
+	if err != nil {
+		return err
+	}
+
+	cr.consumers, cr.keys = consumers, keys
+
+	return nil
+}
+
+// setFile loads the consumers of the given file, which is reloaded
+// once it changes. The consumers of the file replace the ones with
+// the same IDs, and the ones of the previous version of the file.
+func (cr *consumerRegistry) setFile(path string) error {
+	cr.fileMu.Lock()
+	defer cr.fileMu.Unlock()
+
+	cr.file = &watchedFile{path: path}
+	cr.file.isChanged()
+
+	return cr.loadFileLocked()
+}
+
+// reloadFile loads the consumers file again, if it changed. If the new
+// version is invalid, the consumers of the last good one are kept.
+func (cr *consumerRegistry) reloadFile(now time.Time) {
+	cr.fileMu.Lock()
+	defer cr.fileMu.Unlock()
+
+	if cr.file == nil || now.Sub(cr.lastFileCheck) < consumersFileCheckInterval {
+		return
+	}
+
+	cr.lastFileCheck = now
+
+	if cr.file.isChanged() {
+		cr.loadFileLocked()
+	}
+}
+
+// loadFileLocked replaces the consumers of the file by its current content.
+func (cr *consumerRegistry) loadFileLocked() error {
+	confs, err := readConsumersFile(cr.file.path)
+	if err != nil {
+		return err
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	consumers := cr.copyLocked(func(c *consumer) bool { return !c.fromFile })
+
+	for _, conf := range confs {
+		consumers[conf.ID] = newConsumer(conf, true)
+	}
+
+	return cr.setLocked(consumers)
+}
+
+// authenticate returns the consumer of the given API key, or nil if it is unknown.
+func (cr *consumerRegistry) authenticate(key string, now time.Time) *consumer {
+	cr.reloadFile(now)
+
+	hash := string(createHash([]byte(key)))
+
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	return cr.keys[hash]
+}
+
+// getInfo returns the registered consumers ordered by their IDs.
+func (cr *consumerRegistry) getInfo() []*ConsumerInfo {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	info := make([]*ConsumerInfo, 0, len(cr.consumers))
+
+	for _, c := range cr.consumers {
+		info = append(info, &ConsumerInfo{
+			ID:       c.ID,
+			Prefixes: c.Prefixes,
+			Metadata: c.Metadata,
+			Keys:     len(c.KeyHashes),
+			FromFile: c.fromFile,
+		})
+	}
+
+	sort.Slice(info, func(i, j int) bool {
+		return info[i].ID < info[j].ID
+	})
+
+	return info
+}
+
+// isAllowed tells if the consumer may access the given url. The url must be
+// decoded and cleaned, so neither the encoded characters, nor the dot segments
+// can lead out of the prefixes of the consumer.
+func (c *consumer) isAllowed(url string) bool {
+	for _, p := range c.prefixes {
+		if isUnderPrefix(url, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAPIKeyMiddleware creates a middleware, which authenticates the requests by
+// the API keys of the consumers of the Gateway, by the given config. It can be
+// registered by RegisterMiddleware. It returns error, if the config is invalid.
+func (g *Gateway) NewAPIKeyMiddleware(conf *APIKeyConfig) (Middleware, error) {
+	if err := validateAPIKey(conf); err != nil {
+		return nil, err
+	}
+
+	a := newAPIKeyAuthenticator(conf, g.consumers)
+
+	// The services trust the consumer header, so the keys are
+	// checked, even if the middlewares are disabled.
+	return gorouter.NewMiddleware(
+		a.handle,
+		gorouter.MiddlewareWithMatchers(a.matches),
+		gorouter.MiddlewareWithAlwaysAllowed(true),
+	), nil
+}
+
+// newAPIKeyAuthenticator creates the authenticator from the given – already validated – config.
+func newAPIKeyAuthenticator(conf *APIKeyConfig, consumers *consumerRegistry) *apiKeyAuthenticator {
+	return &apiKeyAuthenticator{
+		prefix:         strings.TrimSuffix(conf.Prefix, "/"),
+		header:         getStringOr(conf.Header, defaultAPIKeyHeader),
+		queryParam:     conf.QueryParam,
+		consumerHeader: getStringOr(conf.ConsumerHeader, defaultConsumerHeader),
+		consumers:      consumers,
+	}
+}
+
+// validateAPIKey validates the given config.
+// It returns the first error that occured.
+func validateAPIKey(conf *APIKeyConfig) error {
+	if conf == nil {
+		return errAPIKeyConfigIsNil
+	}
+
+	if conf.Prefix != "" && !strings.HasPrefix(conf.Prefix, "/") {
+		return errBadAPIKeyPrefix
+	}
+
+	for _, name := range []string{conf.Header, conf.ConsumerHeader} {
+		if name != "" && !isToken(name) {
+			return errBadAPIKeyHeader
+		}
+	}
+
+	return nil
+}
+
+// matches tells if the request is under the prefix of the authenticator. The
+// system routes are skipped, since they are authenticated by their signatures.
+func (a *apiKeyAuthenticator) matches(ctx Context) bool {
+	return !isSystemRequest(ctx) && isRequestUnderPrefix(ctx, a.prefix)
+}
+
+// handle is the middleware function of the authenticator. The ID of the
+// consumer is bound to the Context, and it is sent to the service in a header.
+func (a *apiKeyAuthenticator) handle(ctx Context, next HandlerFunc) {
+	r := ctx.GetRequest()
+
+	// Only the consumer authenticated here can be trusted by the services.
+	r.Header.Del(a.consumerHeader)
+
+	c := a.consumers.authenticate(a.takeKey(r), time.Now())
+	if c == nil {
+		ctx.SendRaw([]byte(http.StatusText(http.StatusUnauthorized)), http.StatusUnauthorized, http.Header{})
+
+		return
+	}
+
+	if !c.isAllowed(path.Clean(r.URL.Path)) {
+		ctx.SendRaw([]byte(http.StatusText(http.StatusForbidden)), http.StatusForbidden, http.Header{})
+
+		return
+	}
+
+	r.Header.Set(a.consumerHeader, c.ID)
+	ctx.BindValue(ConsumerIDKey, c.ID)
+
+	next(ctx)
+}
+
+// takeKey returns the API key of the request from the header, or the query.
+// The key is removed from the request, so it is not sent to the service.
+func (a *apiKeyAuthenticator) takeKey(r *http.Request) string {
+	key := r.Header.Get(a.header)
+	r.Header.Del(a.header)
+
+	if a.queryParam == "" {
+		return key
+	}
+
+	query := r.URL.Query()
+	if !query.Has(a.queryParam) {
+		return key
+	}
+
+	if key == "" {
+		key = query.Get(a.queryParam)
+	}
+
+	query.Del(a.queryParam)
+	r.URL.RawQuery = query.Encode()
+
+	// The service is called by the original url, so the key is removed from it too.
+	r.RequestURI = strings.SplitN(r.RequestURI, "?", 2)[0]
+	if r.URL.RawQuery != "" {
+		r.RequestURI += "?" + r.URL.RawQuery
+	}
+
+	return key
+}
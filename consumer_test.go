@@ -0,0 +1,402 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestConsumer returns the config of a consumer with the given key.
+func newTestConsumer(id string, key string, prefixes ...string) *ConsumerConfig {
+	return &ConsumerConfig{
+		ID:        id,
+		KeyHashes: []string{string(createHash([]byte(key)))},
+		Prefixes:  prefixes,
+	}
+}
+
+// writeTestConsumersFile writes the given consumers into the file.
+func writeTestConsumersFile(t *testing.T, path string, consumers ...*ConsumerConfig) {
+	b, err := json.Marshal(consumers)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+}
+
+func TestValidateConsumers(t *testing.T) {
+	type testCase struct {
+		name      string
+		consumers []*ConsumerConfig
+		expError  error
+	}
+
+	tt := []testCase{
+		{
+			name:      "the function returns error, if the id is missing",
+			consumers: []*ConsumerConfig{newTestConsumer("", "key", "/")},
+			expError:  errEmptyConsumerID,
+		},
+		{
+			name: "the function returns error, if the key is not hashed",
+			consumers: []*ConsumerConfig{
+				{ID: "foo", KeyHashes: []string{"key"}, Prefixes: []string{"/"}},
+			},
+			expError: errBadConsumerKeyHash,
+		},
+		{
+			name:      "the function returns error, if there is no prefix",
+			consumers: []*ConsumerConfig{newTestConsumer("foo", "key")},
+			expError:  errBadConsumerPrefix,
+		},
+		{
+			name:      "the function returns error, if the prefix is not started with a '/'",
+			consumers: []*ConsumerConfig{newTestConsumer("foo", "key", "api")},
+			expError:  errBadConsumerPrefix,
+		},
+		{
+			name: "the function returns error, if the id is duplicated",
+			consumers: []*ConsumerConfig{
+				newTestConsumer("foo", "key-1", "/"),
+				newTestConsumer("foo", "key-2", "/"),
+			},
+			expError: errConsumerExists,
+		},
+		{
+			name: "the function returns error, if the key belongs to more consumers",
+			consumers: []*ConsumerConfig{
+				newTestConsumer("foo", "key", "/"),
+				newTestConsumer("bar", "key", "/"),
+			},
+			expError: errConsumerKeyExists,
+		},
+		{
+			name: "the function returns no error, if the consumers are valid",
+			consumers: []*ConsumerConfig{
+				newTestConsumer("foo", "key-1", "/api/foo"),
+				newTestConsumer("bar", "key-2", "/"),
+			},
+			expError: nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateConsumers(tc.consumers); !errors.Is(err, tc.expError) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.expError, err)
+			}
+		})
+	}
+}
+
+func TestConsumerRegistry(t *testing.T) {
+	var (
+		cr  = newConsumerRegistry()
+		now = time.Now()
+	)
+
+	if err := cr.add(newTestConsumer("foo", "key-1", "/")); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	// The other consumer can not take the key.
+	if err := cr.add(newTestConsumer("bar", "key-1", "/")); !errors.Is(err, errConsumerKeyExists) {
+		t.Fatalf("expected error: %v; got error: %v\n", errConsumerKeyExists, err)
+	}
+
+	// The keys of the consumer are rotated by registering it again.
+	if err := cr.add(newTestConsumer("foo", "key-2", "/")); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	if c := cr.authenticate("key-1", now); c != nil {
+		t.Errorf("expected consumer: %v; got consumer: %s\n", nil, c.ID)
+	}
+
+	if c := cr.authenticate("key-2", now); c == nil || c.ID != "foo" {
+		t.Errorf("expected consumer: %s; got consumer: %v\n", "foo", c)
+	}
+
+	if err := cr.remove("foo"); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	if err := cr.remove("foo"); !errors.Is(err, errConsumerNotExists) {
+		t.Errorf("expected error: %v; got error: %v\n", errConsumerNotExists, err)
+	}
+
+	if c := cr.authenticate("key-2", now); c != nil {
+		t.Errorf("expected consumer: %v; got consumer: %s\n", nil, c.ID)
+	}
+}
+
+func TestConsumersFileReload(t *testing.T) {
+	var (
+		path = filepath.Join(t.TempDir(), "consumers.json")
+		cr   = newConsumerRegistry()
+		now  = time.Now()
+	)
+
+	if err := cr.add(newTestConsumer("static", "static-key", "/")); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	writeTestConsumersFile(t, path, newTestConsumer("foo", "foo-key", "/"))
+
+	if err := cr.setFile(path); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	type step struct {
+		name      string
+		consumers []*ConsumerConfig
+		after     time.Duration
+		expKeys   map[string]bool
+	}
+
+	steps := []step{
+		{
+			name:    "the consumers of the file are loaded",
+			expKeys: map[string]bool{"static-key": true, "foo-key": true},
+		},
+		{
+			name:      "the changed file is not checked too often",
+			consumers: []*ConsumerConfig{newTestConsumer("bar", "bar-key", "/")},
+			after:     0,
+			expKeys:   map[string]bool{"foo-key": true, "bar-key": false},
+		},
+		{
+			name:    "the changed file replaces the consumers of the file",
+			after:   2 * time.Second,
+			expKeys: map[string]bool{"static-key": true, "foo-key": false, "bar-key": true},
+		},
+		{
+			name: "the invalid file is ignored",
+			consumers: []*ConsumerConfig{
+				newTestConsumer("bar", "bar-key", "/"),
+				newTestConsumer("baz", "bar-key", "/"),
+			},
+			after:   4 * time.Second,
+			expKeys: map[string]bool{"static-key": true, "bar-key": true},
+		},
+	}
+
+	for i, s := range steps {
+		if s.consumers != nil {
+			writeTestConsumersFile(t, path, s.consumers...)
+
+			// The modification time is moved, so the change is seen regardless of its resolution.
+			modTime := now.Add(time.Duration(i) * time.Minute)
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+			}
+		}
+
+		for key, isKnown := range s.expKeys {
+			if c := cr.authenticate(key, now.Add(s.after)); (c != nil) != isKnown {
+				t.Errorf("%s: expected the key %s to be known: %t\n", s.name, key, isKnown)
+			}
+		}
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	type testCase struct {
+		name           string
+		url            string
+		headers        map[string]string
+		expStatus      int
+		expURL         string
+		expConsumerID  string
+		expCalledTimes int
+	}
+
+	gw := New(WithConsumers(
+		newTestConsumer("foo", "foo-key", "/api/foo"),
+		newTestConsumer("admin", "admin-key", "/"),
+	))
+
+	mw, err := gw.NewAPIKeyMiddleware(&APIKeyConfig{Prefix: "/api", QueryParam: "api_key"})
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	tt := []testCase{
+		{
+			name:           "the request without key is unauthorized",
+			url:            "/api/foo/bar",
+			expStatus:      http.StatusUnauthorized,
+			expCalledTimes: 0,
+		},
+		{
+			name:           "the request with an unknown key is unauthorized",
+			url:            "/api/foo/bar",
+			headers:        map[string]string{defaultAPIKeyHeader: "other-key"},
+			expStatus:      http.StatusUnauthorized,
+			expCalledTimes: 0,
+		},
+		{
+			name:           "the request of a not allowed prefix is forbidden",
+			url:            "/api/bar",
+			headers:        map[string]string{defaultAPIKeyHeader: "foo-key"},
+			expStatus:      http.StatusForbidden,
+			expCalledTimes: 0,
+		},
+		{
+			name:           "the request with the header is forwarded with the consumer",
+			url:            "/api/foo/bar",
+			headers:        map[string]string{defaultAPIKeyHeader: "foo-key", defaultConsumerHeader: "admin"},
+			expStatus:      http.StatusOK,
+			expURL:         "/api/foo/bar",
+			expConsumerID:  "foo",
+			expCalledTimes: 1,
+		},
+		{
+			name:           "the request out of the prefix by a longer segment is forbidden",
+			url:            "/api/foobar",
+			headers:        map[string]string{defaultAPIKeyHeader: "foo-key"},
+			expStatus:      http.StatusForbidden,
+			expCalledTimes: 0,
+		},
+		{
+			name:           "the request out of the prefix by dot segments is forbidden",
+			url:            "/api/foo/../bar",
+			headers:        map[string]string{defaultAPIKeyHeader: "foo-key"},
+			expStatus:      http.StatusForbidden,
+			expCalledTimes: 0,
+		},
+		{
+			name:           "the request under the prefix by encoded characters is forwarded",
+			url:            "/api/fo%6F/bar",
+			headers:        map[string]string{defaultAPIKeyHeader: "foo-key"},
+			expStatus:      http.StatusOK,
+			expURL:         "/api/fo%6F/bar",
+			expConsumerID:  "foo",
+			expCalledTimes: 1,
+		},
+		{
+			name:           "the key is removed from the query",
+			url:            "/api/bar?api_key=admin-key&page=2",
+			expStatus:      http.StatusOK,
+			expURL:         "/api/bar?page=2",
+			expConsumerID:  "admin",
+			expCalledTimes: 1,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				calledTimes int
+				url         string
+				consumerID  string
+			)
+
+			r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			ctx, rec := newTestContext(r)
+
+			if !mw.DoesMatch(ctx) {
+				t.Fatalf("expected the middleware to match\n")
+			}
+
+			mw.Execute(ctx, func(ctx Context) {
+				calledTimes++
+				url = ctx.GetUrl()
+				consumerID = ctx.GetRequestHeader(defaultConsumerHeader)
+
+				if id, _ := ctx.GetBindedValue(ConsumerIDKey).(string); id != consumerID {
+					t.Errorf("expected bound consumer id: %s; got bound consumer id: %s\n", consumerID, id)
+				}
+
+				if key := ctx.GetRequestHeader(defaultAPIKeyHeader); key != "" {
+					t.Errorf("expected the key to be removed; got key: %s\n", key)
+				}
+
+				ctx.SendOk()
+			})
+			ctx.WriteToResponseNow()
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("expected status code: %d; got status code: %d\n", tc.expStatus, rec.Code)
+			}
+
+			if calledTimes != tc.expCalledTimes {
+				t.Errorf("expected called times: %d; got called times: %d\n", tc.expCalledTimes, calledTimes)
+			}
+
+			if url != tc.expURL {
+				t.Errorf("expected url: %s; got url: %s\n", tc.expURL, url)
+			}
+
+			if consumerID != tc.expConsumerID {
+				t.Errorf("expected consumer id: %s; got consumer id: %s\n", tc.expConsumerID, consumerID)
+			}
+		})
+	}
+}
+
+func TestAPIKeyMiddlewareMatch(t *testing.T) {
+	type testCase struct {
+		name     string
+		prefix   string
+		url      string
+		expMatch bool
+	}
+
+	tt := []testCase{
+		{name: "the url under the prefix matches", prefix: "/api/partner", url: "/api/partner/x", expMatch: true},
+		{name: "the url routed to the prefix by characters matches", prefix: "/api/partner", url: "/api/partnerX", expMatch: true},
+		{name: "the url with the encoded slash matches", prefix: "/api/partner", url: "/api/partner%2Fx", expMatch: true},
+		{name: "the url with encoded characters matches", prefix: "/api/partner", url: "/api/p%61rtner/x", expMatch: true},
+		{name: "the url of an other prefix does not match", prefix: "/api/partner", url: "/api/other", expMatch: false},
+		{name: "the system routes are skipped by the empty prefix", prefix: "", url: routeSystemInfo, expMatch: false},
+	}
+
+	gw := New()
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mw, err := gw.NewAPIKeyMiddleware(&APIKeyConfig{Prefix: tc.prefix})
+			if err != nil {
+				t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+			}
+
+			ctx, _ := newTestContext(httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+			if isMatch := mw.DoesMatch(ctx); isMatch != tc.expMatch {
+				t.Errorf("expected match: %t; got match: %t\n", tc.expMatch, isMatch)
+			}
+		})
+	}
+}
+
+func TestAPIKeySystemRoutes(t *testing.T) {
+	// The keys of every request are required, except the signed system calls.
+	gw := New(WithSecretKey("secret"), WithAPIKey(&APIKeyConfig{}))
+
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, newTestSignedRequest(t, routeSystemInfo, "{}", "secret"))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status code: %d; got status code: %d\n", http.StatusOK, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/foo", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code: %d; got status code: %d\n", http.StatusUnauthorized, rec.Code)
+	}
+}
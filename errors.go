@@ -16,6 +16,109 @@ var (
 	errEmptyPort              = errors.New("[service]: port cant be empty")
 	errEmptyPrefix            = errors.New("[service]: prefix cant be empty")
 	errUnsupportedServiceType = errors.New("[service]: gRPC server name empty")
+	errUnknownLoadBalancer    = errors.New("[service]: unknown load balancer")
+	errBadReplacePrefix       = errors.New("[service]: replace prefix must be started with a '/'")
+	errBadRewriteRule         = errors.New("[service]: bad rewrite rule")
+	errBadHost                = errors.New("[service]: bad host pattern")
+	errBadMaxBodySize         = errors.New("[service]: max body size must not be negative")
+	errBadStreamIdleTimeout   = errors.New("[service]: stream idle timeout must be a positive duration, eg. 5m")
+
+	errBadHealthCheckPath      = errors.New("[healthCheck]: path must be started with a '/'")
+	errBadHealthCheckStatus    = errors.New("[healthCheck]: expected status must be a code or a range of codes, eg. 200-299")
+	errBadHealthCheckDuration  = errors.New("[healthCheck]: interval and timeout must be positive durations, eg. 30s")
+	errBadHealthCheckThreshold = errors.New("[healthCheck]: thresholds must not be negative")
+	errHealthCheckFailed       = errors.New("[healthCheck]: unexpected response")
+
+	errBadOutlierThreshold = errors.New("[outlier]: thresholds must not be negative")
+	errBadOutlierErrorRate = errors.New("[outlier]: error rate must be between 0 and 100")
+	errBadOutlierDuration  = errors.New("[outlier]: window and ejection times must be positive durations, eg. 30s")
+
+	errBadRetryDuration = errors.New("[retry]: timeout and backoffs must be positive durations, eg. 100ms")
+	errBadRetryStatus   = errors.New("[retry]: retryable status must be a valid status code")
+	errBadRetryOn       = errors.New("[retry]: unknown error class, only connect-failure, reset and timeout are supported")
+	errBadRetryBudget   = errors.New("[retry]: budget must be between 0 and 100")
+
+	errBadBreakerDuration  = errors.New("[circuitBreaker]: window and durations must be positive, eg. 30s")
+	errBadBreakerWindow    = errors.New("[circuitBreaker]: window must be at least 10ms")
+	errBadBreakerRate      = errors.New("[circuitBreaker]: rates must be between 0 and 100")
+	errBadBreakerThreshold = errors.New("[circuitBreaker]: thresholds must not be negative")
+	errBadCircuitState     = errors.New("[circuitBreaker]: state must be one of closed, open or halfOpen")
+
+	errRateLimitConfigIsNil  = errors.New("[rateLimit]: config is <nil>")
+	errBadRateLimitPrefix    = errors.New("[rateLimit]: prefix must be started with a '/'")
+	errBadRateLimitAlgorithm = errors.New("[rateLimit]: algorithm must be one of tokenBucket or slidingWindow")
+	errBadRateLimitRequests  = errors.New("[rateLimit]: requests must be positive, burst and maxKeys must not be negative")
+	errBadRateLimitDuration  = errors.New("[rateLimit]: period and evictAfter must be positive durations, eg. 1m")
+	errBadRateLimitKey       = errors.New("[rateLimit]: key must be one of ip, header, jwtClaim or service, with the header or claim given")
+
+	errJWTConfigIsNil      = errors.New("[jwt]: config is <nil>")
+	errBadJWTPrefix        = errors.New("[jwt]: prefix must be started with a '/'")
+	errNoJWTKeys           = errors.New("[jwt]: at least one key, a JWKS file or a JWKS URL must be given")
+	errBadJWKSSource       = errors.New("[jwt]: exactly one of JWKS file or JWKS URL must be given, the URL must be http or https")
+	errBadJWTAlgorithm     = errors.New("[jwt]: algorithm must be one of HS256, RS256 or ES256")
+	errBadJWTKey           = errors.New("[jwt]: the key can not be read, or it does not match its algorithm")
+	errBadJWKS             = errors.New("[jwt]: the JWKS can not be read")
+	errBadJWTDuration      = errors.New("[jwt]: JWKS cache TTL and leeway must be valid durations, eg. 5m")
+	errBadJWTForwardHeader = errors.New("[jwt]: forwarded claim header must be a valid header name")
+
+	errJWTMissing           = errors.New("[jwt]: the bearer token is missing")
+	errJWTMalformed         = errors.New("[jwt]: the token is malformed")
+	errJWTAlgorithm         = errors.New("[jwt]: the algorithm of the token is not accepted")
+	errJWTSignature         = errors.New("[jwt]: the signature of the token is invalid")
+	errJWTExpired           = errors.New("[jwt]: the token is expired")
+	errJWTNotValidYet       = errors.New("[jwt]: the token is not valid yet")
+	errJWTIssuer            = errors.New("[jwt]: the issuer of the token is not accepted")
+	errJWTAudience          = errors.New("[jwt]: the audience of the token is not accepted")
+	errJWTMissingClaim      = errors.New("[jwt]: a required claim is missing")
+	errJWTInsufficientScope = errors.New("[jwt]: the required scopes are not granted")
+
+	errEmptyConsumerID    = errors.New("[consumer]: id must be given")
+	errBadConsumerKeyHash = errors.New("[consumer]: at least one key hash must be given, each a hex encoded SHA-256 hash")
+	errBadConsumerPrefix  = errors.New("[consumer]: at least one prefix must be given, each started with a '/'")
+	errConsumerExists     = errors.New("[consumer]: consumer with the given id already exists")
+	errConsumerNotExists  = errors.New("[consumer]: consumer not exists")
+	errConsumerKeyExists  = errors.New("[consumer]: the key already belongs to an other consumer")
+	errBadConsumersFile   = errors.New("[consumer]: the consumers file can not be read")
+
+	errSignatureMissing  = errors.New("[system]: the signature, timestamp or nonce of the request is missing")
+	errSignatureExpired  = errors.New("[system]: the timestamp of the request is out of the allowed skew")
+	errSignatureMismatch = errors.New("[system]: the signature of the request is invalid")
+	errSignatureReplayed = errors.New("[system]: the nonce of the request was already used")
+
+	errBadSignatureMaxSkew = errors.New("[system]: signature max skew must be a positive duration")
+
+	errAPIKeyConfigIsNil = errors.New("[apiKey]: config is <nil>")
+	errBadAPIKeyPrefix   = errors.New("[apiKey]: prefix must be started with a '/'")
+	errBadAPIKeyHeader   = errors.New("[apiKey]: header and consumer header must be valid header names")
+
+	errBadBulkheadLimit    = errors.New("[bulkhead]: limits must not be negative")
+	errBadBulkheadDuration = errors.New("[bulkhead]: queue timeout must be a positive duration, eg. 500ms")
+	errBulkheadFull        = errors.New("[bulkhead]: too many concurrent requests")
+
+	errBadWebSocketLimit    = errors.New("[webSocket]: max connections must not be negative")
+	errBadWebSocketDuration = errors.New("[webSocket]: idle timeout must be a positive duration, eg. 1m")
+
+	errBadTransportLimit    = errors.New("[transport]: connection limits must not be negative")
+	errBadTransportDuration = errors.New("[transport]: idle connection timeout must be a positive duration, eg. 30s")
+
+	errBadTrustedProxy = errors.New("[forwarded]: trusted proxy must be an IP or a CIDR, eg. 10.0.0.0/8")
+
+	errBadTLSVersion     = errors.New("[tls]: min version must be one of 1.0, 1.1, 1.2 or 1.3")
+	errBadTLSKeyPair     = errors.New("[tls]: cert and key files must be given together")
+	errBadTLSFile        = errors.New("[tls]: the certificate files can not be read")
+	errNoPeerCertificate = errors.New("[tls]: the upstream did not present a certificate")
+	errNoServerName      = errors.New("[tls]: the name of the upstream to verify is unknown")
+
+	errNoTLSCertificates     = errors.New("[tls]: at least one certificate must be given")
+	errBadTLSCipherSuite     = errors.New("[tls]: unknown or insecure cipher suite")
+	errBadTLSRedirectAddress = errors.New("[tls]: redirect address must not be negative")
+	errNoClientCA            = errors.New("[tls]: the CA file of the client certificates must be given")
+	errBadIdentityHeader     = errors.New("[tls]: identity header must be a valid header name")
+
+	errEmptyClientCertPolicy = errors.New("[clientCert]: at least one subject or SAN must be given")
+
+	errBadLoadBalancer    = errors.New("[loadBalancer]: name and factory must be given")
+	errLoadBalancerExists = errors.New("[loadBalancer]: load balancer already registered")
 
 	errServiceNotAvailable = errors.New("service is not available")
 
@@ -23,4 +126,12 @@ var (
 	errServiceExists    = errors.New("[registry]: service already registered")
 	errServiceTreeNil   = errors.New("[registry]: service tree is <nil>")
 	ErrServiceNotExists = errors.New("[registry]: service not exists")
+
+	errNoConfigPath           = errors.New("[reload]: the gateway was not created from config file")
+	errBadConfigWatchInterval = errors.New("[reload]: config watch interval must be a positive duration, eg. 10s")
+
+	errEmptySplit      = errors.New("[split]: there must be at least one version or rule")
+	errBadSplitVersion = errors.New("[split]: the weight of a version must not be negative")
+	errBadSplitRule    = errors.New("[split]: exactly one of header or cookie must be given in a rule")
+	errServiceInSplit  = errors.New("[split]: the service is referred by a traffic split")
 )
@@ -0,0 +1,291 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	xForwardedForHeader    = "X-Forwarded-For"
+	xForwardedProtoHeader  = "X-Forwarded-Proto"
+	xForwardedHostHeader   = "X-Forwarded-Host"
+	xForwardedPrefixHeader = "X-Forwarded-Prefix"
+	forwardedHeader        = "Forwarded"
+)
+
+// The headers, which only belong to one connection, so they must
+// not be forwarded by proxies. See RFC 7230, section 6.1.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// The headers set by the proxies, which are only kept from trusted ones.
+var forwardingHeaders = []string{
+	xForwardedForHeader,
+	xForwardedProtoHeader,
+	xForwardedHostHeader,
+	xForwardedPrefixHeader,
+	forwardedHeader,
+}
+
+type clientInfoKey struct{}
+
+// clientInfo is the address of the client of a request.
+type clientInfo struct {
+	// The address of the client, which sent the request – through the trusted proxies.
+	ip string
+
+	// The address of the connection, and whether it is a trusted proxy.
+	peer          string
+	isPeerTrusted bool
+
+	// The verified client certificate of the connection, if there is any.
+	identity *clientIdentity
+}
+
+// trustedProxies are the networks of the proxies in front of the Gateway,
+// whose forwarding headers – eg. X-Forwarded-For – are trusted.
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses the given IPs and CIDRs – eg. 10.0.0.0/8.
+func parseTrustedProxies(proxies []string) (trustedProxies, error) {
+	nets := make(trustedProxies, 0, len(proxies))
+
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, errBadTrustedProxy
+			}
+
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+
+			p += "/" + strconv.Itoa(bits)
+		}
+
+		_, ipNet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, errBadTrustedProxy
+		}
+
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+// contains tells if the given address belongs to a trusted proxy.
+func (tp trustedProxies) contains(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range tp {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getClientInfo returns the address of the client of the request. If the
+// request came from a trusted proxy, the client is the last address of the
+// X-Forwarded-For header, which is not a trusted proxy itself.
+func (tp trustedProxies) getClientInfo(r *http.Request) *clientInfo {
+	peer := getRemoteIP(r)
+
+	info := &clientInfo{
+		ip:            peer,
+		peer:          peer,
+		isPeerTrusted: tp.contains(peer),
+	}
+
+	if !info.isPeerTrusted {
+		return info
+	}
+
+	addrs := getForwardedFor(r.Header)
+
+	for i := len(addrs) - 1; i >= 0; i-- {
+		info.ip = addrs[i]
+
+		if !tp.contains(addrs[i]) {
+			break
+		}
+	}
+
+	return info
+}
+
+// getForwardedFor returns the addresses of the X-Forwarded-For header in order.
+func getForwardedFor(header http.Header) []string {
+	var addrs []string
+
+	for _, v := range header.Values(xForwardedForHeader) {
+		for _, addr := range strings.Split(v, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	return addrs
+}
+
+// getRemoteIP returns the IP address of the connection of the request.
+func getRemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// getRequestClientInfo returns the client of the request, which is set by the Gateway.
+// If the request was not served by the Gateway, the connection is the client.
+func getRequestClientInfo(r *http.Request) *clientInfo {
+	if info, ok := r.Context().Value(clientInfoKey{}).(*clientInfo); ok {
+		return info
+	}
+
+	peer := getRemoteIP(r)
+
+	return &clientInfo{ip: peer, peer: peer}
+}
+
+// getClientIP returns the IP address of the client, which sent the request.
+func getClientIP(r *http.Request) string {
+	return getRequestClientInfo(r).ip
+}
+
+// removeHopByHopHeaders removes the hop-by-hop headers from the given header,
+// including the ones which are listed by the Connection header.
+func removeHopByHopHeaders(header http.Header) {
+	for _, v := range header.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// getForwardHeader returns the header of the request, which is sent to the service.
+// The hop-by-hop headers are removed, and the forwarding headers are added. The
+// ones sent by the client are only kept, if it is a trusted proxy.
+func getForwardHeader(r *http.Request, prefix string) http.Header {
+	var (
+		header = r.Header.Clone()
+		info   = getRequestClientInfo(r)
+		proto  = "http"
+	)
+
+	if header == nil {
+		header = http.Header{}
+	}
+
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	removeHopByHopHeaders(header)
+
+	if !info.isPeerTrusted {
+		for _, name := range forwardingHeaders {
+			header.Del(name)
+		}
+	}
+
+	if prior := strings.Join(header.Values(xForwardedForHeader), ", "); prior != "" {
+		header.Set(xForwardedForHeader, prior+", "+info.peer)
+	} else {
+		header.Set(xForwardedForHeader, info.peer)
+	}
+
+	if header.Get(xForwardedProtoHeader) == "" {
+		header.Set(xForwardedProtoHeader, proto)
+	}
+
+	if header.Get(xForwardedHostHeader) == "" {
+		header.Set(xForwardedHostHeader, r.Host)
+	}
+
+	header.Set(xForwardedPrefixHeader, prefix)
+
+	element := "for=" + quoteForwarded(formatForwardedNode(info.peer)) +
+		";host=" + quoteForwarded(r.Host) +
+		";proto=" + proto
+
+	if prior := strings.Join(header.Values(forwardedHeader), ", "); prior != "" {
+		header.Set(forwardedHeader, prior+", "+element)
+	} else {
+		header.Set(forwardedHeader, element)
+	}
+
+	return header
+}
+
+// formatForwardedNode returns the node of the Forwarded header
+// for the given IP. The IPv6 addresses are enclosed in brackets.
+func formatForwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "[" + ip + "]"
+	}
+	return ip
+}
+
+// quoteForwarded returns the given value of the Forwarded header
+// as a quoted string, if it is not a valid token. See RFC 7239.
+func quoteForwarded(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+// isTokenChar tells if the given char is allowed in a token. See RFC 7230.
+func isTokenChar(c rune) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
+
+// pipeResponse sends the response of the service. The router joins the values of
+// a header, so the multi-valued ones – eg. Set-Cookie – are set on the writer of
+// the request directly, if it can be used.
+func pipeResponse(ctx Context, res *http.Response) {
+	if sw := getStreamWriter(ctx); sw != nil {
+		header := sw.Header()
+
+		for k, v := range res.Header {
+			if len(v) > 1 {
+				header[k] = append(header[k], v...)
+				delete(res.Header, k)
+			}
+		}
+	}
+
+	ctx.Pipe(res)
+}
@@ -0,0 +1,217 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	type testCase struct {
+		name    string
+		proxies []string
+		err     error
+	}
+
+	tt := []testCase{
+		{
+			name:    "the function parses the IPs and CIDRs",
+			proxies: []string{"10.0.0.0/8", "192.168.1.1", "::1"},
+			err:     nil,
+		},
+		{
+			name:    "the function returns error if the IP is invalid",
+			proxies: []string{"10.0.0"},
+			err:     errBadTrustedProxy,
+		},
+		{
+			name:    "the function returns error if the CIDR is invalid",
+			proxies: []string{"10.0.0.0/33"},
+			err:     errBadTrustedProxy,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseTrustedProxies(tc.proxies); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestGetClientInfo(t *testing.T) {
+	type testCase struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expIP        string
+		expIsTrusted bool
+	}
+
+	tp, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	tt := []testCase{
+		{
+			name:         "the header of an untrusted peer is ignored",
+			remoteAddr:   "1.2.3.4:1234",
+			forwardedFor: []string{"5.6.7.8"},
+			expIP:        "1.2.3.4",
+			expIsTrusted: false,
+		},
+		{
+			name:         "the client is the last untrusted address",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"9.9.9.9, 5.6.7.8", "10.0.0.2"},
+			expIP:        "5.6.7.8",
+			expIsTrusted: true,
+		},
+		{
+			name:         "the peer is the client, if there is no header",
+			remoteAddr:   "10.0.0.1:1234",
+			expIP:        "10.0.0.1",
+			expIsTrusted: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/mock", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.forwardedFor {
+				r.Header.Add(xForwardedForHeader, v)
+			}
+
+			info := tp.getClientInfo(r)
+
+			if info.ip != tc.expIP {
+				t.Errorf("expected ip: %s; got ip: %s\n", tc.expIP, info.ip)
+			}
+
+			if info.isPeerTrusted != tc.expIsTrusted {
+				t.Errorf("expected trusted: %t; got trusted: %t\n", tc.expIsTrusted, info.isPeerTrusted)
+			}
+		})
+	}
+}
+
+func TestGetForwardHeader(t *testing.T) {
+	type testCase struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		expHeader  http.Header
+	}
+
+	tp, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	tt := []testCase{
+		{
+			name:       "the hop-by-hop headers are removed, the multi-valued ones are kept",
+			remoteAddr: "1.2.3.4:1234",
+			header: http.Header{
+				"Accept":     {"text/html", "application/json"},
+				"Connection": {"keep-alive, X-Custom"},
+				"Keep-Alive": {"timeout=5"},
+				"X-Custom":   {"foo"},
+			},
+			expHeader: http.Header{
+				"Accept":             {"text/html", "application/json"},
+				"X-Forwarded-For":    {"1.2.3.4"},
+				"X-Forwarded-Proto":  {"http"},
+				"X-Forwarded-Host":   {"example.com"},
+				"X-Forwarded-Prefix": {"/api/mock"},
+				"Forwarded":          {"for=1.2.3.4;host=example.com;proto=http"},
+			},
+		},
+		{
+			name:       "the forwarding headers of an untrusted peer are replaced",
+			remoteAddr: "[::1]:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"5.6.7.8"},
+				"X-Forwarded-Proto": {"https"},
+				"Forwarded":         {"for=5.6.7.8"},
+			},
+			expHeader: http.Header{
+				"X-Forwarded-For":    {"::1"},
+				"X-Forwarded-Proto":  {"http"},
+				"X-Forwarded-Host":   {"example.com"},
+				"X-Forwarded-Prefix": {"/api/mock"},
+				"Forwarded":          {`for="[::1]";host=example.com;proto=http`},
+			},
+		},
+		{
+			name:       "the forwarding headers of a trusted peer are appended",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"5.6.7.8"},
+				"X-Forwarded-Proto": {"https"},
+				"Forwarded":         {"for=5.6.7.8;proto=https"},
+			},
+			expHeader: http.Header{
+				"X-Forwarded-For":    {"5.6.7.8, 10.0.0.1"},
+				"X-Forwarded-Proto":  {"https"},
+				"X-Forwarded-Host":   {"example.com"},
+				"X-Forwarded-Prefix": {"/api/mock"},
+				"Forwarded":          {"for=5.6.7.8;proto=https, for=10.0.0.1;host=example.com;proto=http"},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/api/mock", nil)
+			r.RemoteAddr = tc.remoteAddr
+			r.Header = tc.header
+
+			r = r.WithContext(context.WithValue(r.Context(), clientInfoKey{}, tp.getClientInfo(r)))
+
+			if got := getForwardHeader(r, "/api/mock"); !reflect.DeepEqual(got, tc.expHeader) {
+				t.Errorf("expected header: %v; got header: %v\n", tc.expHeader, got)
+			}
+		})
+	}
+}
+
+func TestHandleResponseHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Content-Type", JsonContentType)
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	gw := newTestGateway(t, srv, &ServiceConfig{
+		Name:   "mock-name",
+		Prefix: "/api/mock",
+	})
+
+	gwSrv := httptest.NewServer(gw)
+	defer gwSrv.Close()
+
+	res, err := http.Get(gwSrv.URL + "/api/mock/foo")
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+	defer res.Body.Close()
+
+	expCookies := []string{"a=1", "b=2"}
+	if cookies := res.Header.Values("Set-Cookie"); !reflect.DeepEqual(cookies, expCookies) {
+		t.Errorf("expected cookies: %v; got cookies: %v\n", expCookies, cookies)
+	}
+
+	if ka := res.Header.Get("Keep-Alive"); ka != "" {
+		t.Errorf("expected keep-alive: %s; got keep-alive: %s\n", "", ka)
+	}
+}
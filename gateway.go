@@ -2,8 +2,9 @@ package gateway
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/balazskvancz/gorouter"
+	"github.com/balazskvancz/rtree"
 )
 
 type (
@@ -35,8 +37,17 @@ const (
 
 	routeSystemPrefix = "/api/system"
 
-	routeSystemInfo         = routeSystemPrefix + "/services/info"
-	routeUpdateServiceState = routeSystemPrefix + "/services/update"
+	routeSystemInfo          = routeSystemPrefix + "/services/info"
+	routeUpdateServiceState  = routeSystemPrefix + "/services/update"
+	routeRegisterService     = routeSystemPrefix + "/services/register"
+	routeUpdateServiceConfig = routeSystemPrefix + "/services/update-config"
+	routeDeregisterService   = routeSystemPrefix + "/services/deregister"
+	routeSetCircuitBreaker   = routeSystemPrefix + "/services/circuit-breaker"
+	routeUpdateTrafficSplit  = routeSystemPrefix + "/splits/update"
+	routeRemoveTrafficSplit  = routeSystemPrefix + "/splits/remove"
+	routeListConsumers       = routeSystemPrefix + "/consumers/list"
+	routeRegisterConsumer    = routeSystemPrefix + "/consumers/register"
+	routeRemoveConsumer      = routeSystemPrefix + "/consumers/remove"
 )
 
 const (
@@ -63,12 +74,26 @@ type GatewayInfo struct {
 	// The secret key which is used to authenticate amongst services.
 	secretKey string
 
+	// The allowed difference between the timestamps of the
+	// signed system requests and the clock of the Gateway.
+	signatureMaxSkew time.Duration
+
 	// The time when the Gateway instance was booted up.
 	startTime time.Time
 
 	healthCheckFrequency time.Duration
 
+	// The maximum count of healthchecks running at the same time.
+	healthCheckConcurrency int
+
 	grpcProxyAddress int
+
+	// The interval of checking the config file for changes.
+	// If it is 0, the config file is not watched.
+	configWatchInterval time.Duration
+
+	// Whether the paths with encoded slashes are rejected.
+	rejectEncodedSlashes bool
 }
 
 type Gateway struct {
@@ -82,6 +107,10 @@ type Gateway struct {
 	// The registy which stores all the registered services.
 	serviceRegisty *registry
 
+	// The urls of the custom routes by their methods. The bodies of these
+	// requests are read, even if they are under the prefix of a service.
+	customRoutes map[string]*rtree.Tree[bool]
+
 	// TODO: make mockTree for development purposes.
 	// mockTree *tree
 
@@ -95,7 +124,25 @@ type Gateway struct {
 
 	grpcProxy *grpcProxy
 
+	// The middlewares created by the options – eg. the rate limiters –,
+	// which are registered once the router is created.
+	middlewares []Middleware
+
+	// The proxies in front of the Gateway, whose forwarding headers are trusted.
+	trustedProxies trustedProxies
+
+	// The TLS settings of the listener. If it is nil, plain HTTP is served.
+	listenerTLS *listenerTLS
+
+	// The consumers, who are authenticated by their API keys.
+	consumers *consumerRegistry
+
 	logger logger
+
+	// The path and the last applied content of the config file,
+	// if the gateway was created from one. Used for reloading.
+	configPath string
+	config     *GatewayConfig
 }
 
 func defaultNotFoundHandler(ctx Context) {
@@ -139,6 +186,14 @@ func WithSecretKey(key string) GatewayOptionFunc {
 	}
 }
 
+// WithSignatureMaxSkew sets how much the timestamps of the signed system
+// requests may differ from the clock of the Gateway. By default it is 5 minutes.
+func WithSignatureMaxSkew(d time.Duration) GatewayOptionFunc {
+	return func(g *Gateway) {
+		g.info.signatureMaxSkew = d
+	}
+}
+
 func WithService(conf *ServiceConfig) GatewayOptionFunc {
 	return func(g *Gateway) {
 		if err := g.RegisterService(conf); err != nil {
@@ -153,12 +208,132 @@ func WithHealthCheckFrequency(t time.Duration) GatewayOptionFunc {
 	}
 }
 
+// WithHealthCheckConcurrency sets the maximum count
+// of healthchecks, which can run at the same time.
+func WithHealthCheckConcurrency(n int) GatewayOptionFunc {
+	return func(g *Gateway) {
+		g.info.healthCheckConcurrency = n
+	}
+}
+
 func WithDisabledLoggers(disabled logTypeValue) GatewayOptionFunc {
 	return func(g *Gateway) {
 		g.logger.disable(disabled)
 	}
 }
 
+// WithTrafficSplit splits the traffic of a service amongst its versions.
+// The referred services must be registered beforehand.
+func WithTrafficSplit(conf *TrafficSplitConfig) GatewayOptionFunc {
+	return func(g *Gateway) {
+		if err := g.SetTrafficSplit(conf); err != nil {
+			g.logger.Warning(err.Error())
+		}
+	}
+}
+
+// WithRateLimit limits the rate of the requests by the given config.
+// See NewRateLimitMiddleware.
+func WithRateLimit(conf *RateLimitConfig) GatewayOptionFunc {
+	return func(g *Gateway) {
+		mw, err := NewRateLimitMiddleware(conf)
+		if err != nil {
+			g.logger.Warning(err.Error())
+			return
+		}
+
+		g.middlewares = append(g.middlewares, mw)
+	}
+}
+
+// WithJWT validates the bearer tokens of the requests by the given config.
+// See NewJWTMiddleware.
+func WithJWT(conf *JWTConfig) GatewayOptionFunc {
+	return func(g *Gateway) {
+		mw, err := NewJWTMiddleware(conf)
+		if err != nil {
+			g.logger.Warning(err.Error())
+			return
+		}
+
+		g.middlewares = append(g.middlewares, mw)
+	}
+}
+
+// WithConsumers registers the given consumers, who are authenticated by their API keys.
+func WithConsumers(consumers ...*ConsumerConfig) GatewayOptionFunc {
+	return func(g *Gateway) {
+		for _, conf := range consumers {
+			if err := g.RegisterConsumer(conf); err != nil {
+				g.logger.Warning(err.Error())
+			}
+		}
+	}
+}
+
+// WithConsumersFile loads the consumers of the given JSON file, which is
+// reloaded once it changes. If the changed file is invalid, the consumers
+// of its last good version are kept.
+func WithConsumersFile(path string) GatewayOptionFunc {
+	return func(g *Gateway) {
+		if err := g.consumers.setFile(path); err != nil {
+			g.logger.Warning(err.Error())
+		}
+	}
+}
+
+// WithAPIKey authenticates the requests by the API keys of the consumers
+// by the given config. See NewAPIKeyMiddleware.
+func WithAPIKey(conf *APIKeyConfig) GatewayOptionFunc {
+	return func(g *Gateway) {
+		mw, err := g.NewAPIKeyMiddleware(conf)
+		if err != nil {
+			g.logger.Warning(err.Error())
+			return
+		}
+
+		g.middlewares = append(g.middlewares, mw)
+	}
+}
+
+// WithTrustedProxies sets the proxies – by IPs or CIDRs, eg. 10.0.0.0/8 –, whose
+// forwarding headers are trusted. The address of the client is taken from the
+// X-Forwarded-For header, only if the request came from one of them.
+func WithTrustedProxies(proxies ...string) GatewayOptionFunc {
+	return func(g *Gateway) {
+		tp, err := parseTrustedProxies(proxies)
+		if err != nil {
+			g.logger.Warning(err.Error())
+			return
+		}
+
+		g.trustedProxies = tp
+	}
+}
+
+// WithEncodedSlashesRejected makes the Gateway reject the requests, whose path
+// contains an encoded slash or backslash – eg. /api/a%2Fb –, with 400. The
+// services and the middlewares are matched by the raw path, while the services
+// may decode the slashes, and see an other path than the one which was matched.
+func WithEncodedSlashesRejected() GatewayOptionFunc {
+	return func(g *Gateway) {
+		g.info.rejectEncodedSlashes = true
+	}
+}
+
+// WithTLS serves HTTPS at the address of the Gateway by the given config.
+// See ListenerTLSConfig.
+func WithTLS(conf *ListenerTLSConfig) GatewayOptionFunc {
+	return func(g *Gateway) {
+		if err := validateListenerTLS(conf); err != nil {
+			g.logger.Warning(err.Error())
+			return
+		}
+
+		g.listenerTLS = newListenerTLS(conf)
+	}
+}
+
 func WithGrpcProxy(addr int) GatewayOptionFunc {
 	return func(g *Gateway) {
 		g.info.grpcProxyAddress = addr
@@ -176,10 +351,13 @@ func NewFromConfig(path ...string) (*Gateway, error) {
 		return defaultConfigPath
 	}()
 
-	opts, err := ReadConfig(finalPath)
+	conf, err := readConfigFile(finalPath)
 	if err != nil {
 		return nil, err
 	}
+
+	opts := append(getGatewayOptionFuncs(conf), withConfigSource(finalPath, conf))
+
 	return New(opts...), nil
 }
 
@@ -201,6 +379,8 @@ func New(opts ...GatewayOptionFunc) *Gateway {
 		ctx: defaultContext,
 
 		serviceRegisty: newRegistry(),
+		customRoutes:   make(map[string]*rtree.Tree[bool]),
+		consumers:      newConsumerRegistry(),
 
 		notFoundHandler: defaultNotFoundHandler,
 		panicHandler:    defaultPanicHandler,
@@ -218,6 +398,7 @@ func New(opts ...GatewayOptionFunc) *Gateway {
 		gorouter.WithNotFoundHandler(gw.serve),
 		gorouter.WithEmptyTreeHandler(gw.serve),
 		gorouter.WithMiddlewaresEnabled(gw.areMiddlewaresEnabled()),
+		gorouter.WithBodyReader(gw.readBody),
 	)
 
 	gw.serviceRegisty.withHealthCheck(gw.info.healthCheckFrequency)
@@ -230,6 +411,8 @@ func New(opts ...GatewayOptionFunc) *Gateway {
 
 	gw.registerSystemRoutes()
 
+	gw.RegisterMiddleware(gw.middlewares...)
+
 	return gw
 }
 
@@ -243,6 +426,10 @@ func (gw *Gateway) Start() {
 
 	addr := fmt.Sprintf(":%d", gw.info.address)
 
+	if gw.listenerTLS != nil {
+		addr += " (TLS)"
+	}
+
 	gw.logger.Info(
 		fmt.Sprintf("The gateway started at %s\tProduction: %t\tMiddlewares enabled: %t",
 			addr,
@@ -258,13 +445,37 @@ func (gw *Gateway) Start() {
 		defer gw.grpcProxy.stop()
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	// Updating the status of each service.
-	go gw.serviceRegisty.updateStatus()
+	go newHealthScheduler(gw.serviceRegisty, gw.info.healthCheckConcurrency).run(ctx)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
 
-	go gw.router.ListenWithContext(ctx)
+	go func() {
+		gw.listen(ctx)
+		close(stopped)
+	}()
+
+	if gw.configPath != "" && gw.info.configWatchInterval > 0 {
+		go gw.watchConfig(ctx)
+	}
+
+	gw.waitForSignals()
+
+	cancel()
 
+	// Waiting for the in-flight requests.
+	<-stopped
+
+	gw.logger.clean()
+
+	gw.logger.Info("the gateway stopped")
+}
+
+// waitForSignals blocks until the signal to quit is received.
+// Meanwhile on every SIGHUP it reloads the config.
+func (gw *Gateway) waitForSignals() {
 	// Creating a channel, that listens for quiting.
 	sigCh := make(chan os.Signal, 1)
 
@@ -273,12 +484,17 @@ func (gw *Gateway) Start() {
 	// so we can make the shutdown graceful.
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 
-	<-sigCh
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
 
-	cancel()
-	gw.logger.clean()
-
-	gw.logger.Info("the gateway stopped")
+	for {
+		select {
+		case <-hupCh:
+			gw.reloadConfigAndLog()
+		case <-sigCh:
+			return
+		}
+	}
 }
 
 // GetService searches for a service by its name.
@@ -304,29 +520,58 @@ func (gw *Gateway) ListenForMocks(_ *[]any) {
 
 // Get registers a custom route with method @GET.
 func (gw *Gateway) Get(url string, handler HandlerFunc) Route {
+	gw.addCustomRoute(http.MethodGet, url)
+
 	return gw.router.Get(url, handler)
 }
 
 // Post registers a custom route with method @POST.
 func (gw *Gateway) Post(url string, handler HandlerFunc) Route {
+	gw.addCustomRoute(http.MethodPost, url)
+
 	return gw.router.Post(url, handler)
 }
 
 // Put registers a custom route with method @PUT.
 func (gw *Gateway) Put(url string, handler HandlerFunc) Route {
+	gw.addCustomRoute(http.MethodPut, url)
+
 	return gw.router.Put(url, handler)
 }
 
 // Delete registers a custom route with method @DELETE.
 func (gw *Gateway) Delete(url string, handler HandlerFunc) Route {
+	gw.addCustomRoute(http.MethodDelete, url)
+
 	return gw.router.Delete(url, handler)
 }
 
 // Head registers a custom route with method @HEAD.
 func (gw *Gateway) Head(url string, handler HandlerFunc) Route {
+	gw.addCustomRoute(http.MethodHead, url)
+
 	return gw.router.Head(url, handler)
 }
 
+// addCustomRoute remembers the url of a custom route with the given method.
+func (gw *Gateway) addCustomRoute(method string, url string) {
+	t, ok := gw.customRoutes[method]
+	if !ok {
+		t = rtree.New[bool]()
+		gw.customRoutes[method] = t
+	}
+
+	// The same errors are logged by the router.
+	_ = t.Insert(url, true)
+}
+
+// isCustomRoute tells if there is a custom route for the given method and url.
+func (gw *Gateway) isCustomRoute(method string, url string) bool {
+	t, ok := gw.customRoutes[method]
+
+	return ok && t.Find(url) != nil
+}
+
 // RegisterMiddleware registers a middleware instance to the gateway.
 func (gw *Gateway) RegisterMiddleware(mw ...Middleware) error {
 	gw.router.RegisterMiddlewares(mw...)
@@ -335,8 +580,15 @@ func (gw *Gateway) RegisterMiddleware(mw ...Middleware) error {
 }
 
 func (gw *Gateway) serve(ctx Context) {
-	s := gw.serviceRegisty.findService(ctx.GetCleanedUrl())
+	s := gw.serviceRegisty.findServiceByHost(getRequestHost(ctx.GetRequest()), ctx.GetCleanedUrl())
 	if s != nil {
+		// If the traffic is split, then an other version
+		// could serve the request, by the matched prefix.
+		if version := gw.serviceRegisty.resolveSplit(s, ctx.GetRequest()); version != s {
+			ctx.BindValue(matchedPrefixKey, s.Prefix)
+			s = version
+		}
+
 		s.Handle(ctx)
 
 		return
@@ -346,6 +598,25 @@ func (gw *Gateway) serve(ctx Context) {
 	ctx.SendNotFound()
 }
 
+// getRequestHost returns the lowercase host of the request without the port.
+// If there is no Host header, the server name of TLS handshake (SNI) is used.
+func getRequestHost(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	host := r.Host
+	if host == "" && r.TLS != nil {
+		host = r.TLS.ServerName
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.ToLower(host)
+}
+
 // isProd returns whether the the GW is running in production env.
 func (g *Gateway) isProd() bool {
 	return g.info.runLevel&lvlProd != 0
@@ -363,7 +634,75 @@ func (g *Gateway) RegisterService(conf *ServiceConfig) error {
 	return g.serviceRegisty.addService(conf)
 }
 
-func (g *Gateway) getGRPCServiceByPrefix(p string) Service {
+// UpdateService replaces the config of an already registered service,
+// identified by the name in the given config. In case of validation
+// error or non existing service, it returns error.
+func (g *Gateway) UpdateService(conf *ServiceConfig) error {
+	return g.serviceRegisty.updateService(conf)
+}
+
+// SetCircuitBreakerState sets the state of the circuit breaker of the service
+// by the given name – closed, open or halfOpen. The manually opened breaker
+// stays open until its state is set again.
+func (g *Gateway) SetCircuitBreakerState(name string, state string) error {
+	cs, ok := getCircuitState(state)
+	if !ok {
+		return errBadCircuitState
+	}
+
+	s := g.serviceRegisty.getServiceByName(name)
+	if s == nil {
+		return ErrServiceNotExists
+	}
+
+	s.breaker.setState(cs, time.Now())
+
+	return nil
+}
+
+// SetTrafficSplit splits the traffic of a service amongst its versions.
+// It replaces the previous split of the same service, if there is any.
+func (g *Gateway) SetTrafficSplit(conf *TrafficSplitConfig) error {
+	return g.serviceRegisty.setTrafficSplit(conf)
+}
+
+// RemoveTrafficSplit removes the split of the service by the given name,
+// so all its traffic is served by the service itself again.
+func (g *Gateway) RemoveTrafficSplit(name string) error {
+	return g.serviceRegisty.removeTrafficSplit(name)
+}
+
+// DeregisterService removes the service by the given name from the registry.
+// Returns error, if there is no such service, or a traffic split refers to it.
+func (g *Gateway) DeregisterService(name string) error {
+	return g.serviceRegisty.removeService(name)
+}
+
+// RegisterConsumer registers the consumer by the given config, or replaces
+// the one with the same ID. In case of validation error, or if one of its
+// keys belongs to an other consumer, it returns error.
+func (g *Gateway) RegisterConsumer(conf *ConsumerConfig) error {
+	return g.consumers.add(conf)
+}
+
+// RemoveConsumer removes the consumer by the given ID.
+// Returns error, if there is no such consumer.
+func (g *Gateway) RemoveConsumer(id string) error {
+	return g.consumers.remove(id)
+}
+
+// checkServiceStatusAsync performs the healthcheck of
+// the service identified by the name in the background.
+func (g *Gateway) checkServiceStatusAsync(name string) {
+	s := g.serviceRegisty.getServiceByName(name)
+	if s == nil {
+		return
+	}
+
+	go g.serviceRegisty.checkServiceStatus(s)
+}
+
+func (g *Gateway) getGRPCServiceByPrefix(p string) *service {
 	if p == "" {
 		return nil
 	}
@@ -377,43 +716,45 @@ func (g *Gateway) getGRPCServiceByPrefix(p string) Service {
 	return serv
 }
 
+// isSystemRequest tells if the request is sent to the system routes. These are
+// authenticated by their signatures, so the middlewares authenticating the
+// requests of the services – eg. by JWTs or API keys – must skip them.
+func isSystemRequest(ctx Context) bool {
+	return strings.HasPrefix(ctx.GetUrl(), routeSystemPrefix)
+}
+
 func (gw *Gateway) registerSystemRoutes() {
-	systemMatcher := func(ctx Context) bool {
-		return strings.HasPrefix(ctx.GetUrl(), routeSystemPrefix)
+	verifier := newSignatureVerifier(gw.info.secretKey, gw.info.signatureMaxSkew)
+
+	// Every system route has its own function to decode the incoming body.
+	decoders := map[string]decodeFunction{
+		routeSystemInfo:          func(b []byte) (any, error) { return nil, nil },
+		routeUpdateServiceState:  decodeInto[updateServiceStateRequest](),
+		routeRegisterService:     decodeInto[ServiceConfig](),
+		routeUpdateServiceConfig: decodeInto[ServiceConfig](),
+		routeDeregisterService:   decodeInto[deregisterServiceRequest](),
+		routeSetCircuitBreaker:   decodeInto[setCircuitBreakerRequest](),
+		routeUpdateTrafficSplit:  decodeInto[TrafficSplitConfig](),
+		routeRemoveTrafficSplit:  decodeInto[removeTrafficSplitRequest](),
+		routeListConsumers:       func(b []byte) (any, error) { return nil, nil },
+		routeRegisterConsumer:    decodeInto[ConsumerConfig](),
+		routeRemoveConsumer:      decodeInto[removeConsumerRequest](),
 	}
 
 	mwFunc := func(ctx Context, next HandlerFunc) {
-		u := ctx.GetCleanedUrl()
-
-		if u == routeSystemInfo {
-			fn := validateIncomingRequest(gw,
-				func(b []byte) (any, error) { return nil, nil },
-			)
-
-			fn(ctx, next)
-
+		df, ok := decoders[ctx.GetCleanedUrl()]
+		if !ok {
 			return
 		}
 
-		if u == routeUpdateServiceState {
-			fn := validateIncomingRequest(gw, func(b []byte) (any, error) {
-				var (
-					in  = &updateServiceStateRequest{}
-					err = json.Unmarshal(b, in)
-				)
-
-				return in, err
-			})
-
-			fn(ctx, next)
+		fn := validateIncomingRequest(verifier, df)
 
-			return
-		}
+		fn(ctx, next)
 	}
 
 	mw := gorouter.NewMiddleware(
 		mwFunc,
-		gorouter.MiddlewareWithMatchers(systemMatcher),
+		gorouter.MiddlewareWithMatchers(isSystemRequest),
 		gorouter.MiddlewareWithAlwaysAllowed(true),
 	)
 
@@ -421,4 +762,13 @@ func (gw *Gateway) registerSystemRoutes() {
 
 	gw.Post(routeSystemInfo, getSystemInfoHandler(gw))
 	gw.Post(routeUpdateServiceState, serviceStateUpdateHandler(gw))
+	gw.Post(routeRegisterService, registerServiceHandler(gw))
+	gw.Post(routeUpdateServiceConfig, updateServiceConfigHandler(gw))
+	gw.Post(routeDeregisterService, deregisterServiceHandler(gw))
+	gw.Post(routeSetCircuitBreaker, setCircuitBreakerHandler(gw))
+	gw.Post(routeUpdateTrafficSplit, updateTrafficSplitHandler(gw))
+	gw.Post(routeRemoveTrafficSplit, removeTrafficSplitHandler(gw))
+	gw.Post(routeListConsumers, listConsumersHandler(gw))
+	gw.Post(routeRegisterConsumer, registerConsumerHandler(gw))
+	gw.Post(routeRemoveConsumer, removeConsumerHandler(gw))
 }
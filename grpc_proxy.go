@@ -9,11 +9,12 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
@@ -25,7 +26,7 @@ var (
 	}
 )
 
-type serviceLookupFn func(string) Service
+type serviceLookupFn func(string) *service
 
 type grpcProxy struct {
 	logger
@@ -85,17 +86,55 @@ func (g *grpcProxy) handler(srv interface{}, serverStream grpc.ServerStream) err
 		return status.Errorf(codes.Internal, "service %s not found", serviceName)
 	}
 
-	conn, err := grpc.Dial(service.GetAddress(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err := service.bulkhead.acquire(serverStream.Context()); err != nil {
+		return status.Errorf(codes.Unavailable, "service %s is saturated: %v", serviceName, err)
+	}
+	defer service.bulkhead.release()
+
+	if !service.breaker.allow(time.Now()) {
+		return status.Errorf(codes.Unavailable, "the circuit breaker of service %s is open", serviceName)
+	}
+
+	start := time.Now()
+
+	// Only the instances which passed the healthcheck are picked.
+	inst := service.nextInstance()
+	if inst == nil {
+		service.breaker.record(true, time.Since(start), time.Now())
+		return status.Errorf(codes.Unavailable, "service %s is not available", serviceName)
+	}
+
+	inst.acquire()
+	defer inst.release()
+
+	conn, err := inst.getGRPCConn()
 	if err != nil {
-		return err
+		service.breaker.record(true, time.Since(start), time.Now())
+		return status.Errorf(codes.Unavailable, "service %s is not available: %v", serviceName, err)
 	}
 
-	ctx := context.TODO() // Change it to the associated service's context with timeout.
+	// The cancellation of the client reaches the service as well.
+	ctx, cancel := context.WithCancel(serverStream.Context())
+	defer cancel()
 
 	clientStream, err := grpc.NewClientStream(ctx, proxyDesc, conn, fullMethodName)
 	if err != nil {
+		g.recordOutcome(service, inst, start, err)
 		return err
 	}
+
+	// The connection is established lazily, so the errors of the service
+	// – eg. Unavailable – only surface by the final status of the stream.
+	upstreamErr, err := proxyStreams(serverStream, clientStream)
+
+	g.recordOutcome(service, inst, start, upstreamErr)
+
+	return err
+}
+
+// proxyStreams pumps the messages between the streams until the service finishes
+// the call. It returns the final status of the service, and the error to return.
+func proxyStreams(serverStream grpc.ServerStream, clientStream grpc.ClientStream) (error, error) {
 	var (
 		s2cErrChan = forwardServerToClient(serverStream, clientStream)
 		c2sErrChan = forwardClientToServer(clientStream, serverStream)
@@ -111,8 +150,8 @@ func (g *grpcProxy) handler(srv interface{}, serverStream grpc.ServerStream) err
 			} else {
 				// however, we may have gotten a receive error (stream disconnected, a read error etc) in which case we need
 				// to cancel the clientStream to the backend, let all of its goroutines be freed up by the CancelFunc and
-				// exit with an error to the stack
-				return status.Errorf(codes.Internal, "failed proxying s2c: %v", s2cErr)
+				// exit with an error to the stack. It is not the fault of the service.
+				return nil, status.Errorf(codes.Internal, "failed proxying s2c: %v", s2cErr)
 			}
 		case c2sErr := <-c2sErrChan:
 			// This happens when the clientStream has nothing else to offer (io.EOF), returned a gRPC error. In those two
@@ -121,12 +160,39 @@ func (g *grpcProxy) handler(srv interface{}, serverStream grpc.ServerStream) err
 			serverStream.SetTrailer(clientStream.Trailer())
 			// c2sErr will contain RPC error from client code. If not io.EOF return the RPC error as server stream error.
 			if c2sErr != io.EOF {
-				return c2sErr
+				return c2sErr, c2sErr
 			}
-			return nil
+			return nil, nil
 		}
 	}
-	return status.Errorf(codes.Internal, "gRPC proxying should never reach this stage.")
+	return nil, status.Errorf(codes.Internal, "gRPC proxying should never reach this stage.")
+}
+
+// recordOutcome records the final status of the call for the
+// circuit breaker and the outlier detection of the service.
+func (g *grpcProxy) recordOutcome(s *service, inst *instance, start time.Time, err error) {
+	statusCode, connErr := getGRPCOutcome(err)
+
+	s.breaker.record(connErr != nil || statusCode >= http.StatusInternalServerError, time.Since(start), time.Now())
+
+	if inst.recordOutcome(s.outlier, statusCode, connErr) {
+		g.logger.Warning(fmt.Sprintf("[grpcProxy] instance %s of service %s is ejected", inst.GetAddress(), s.Name))
+	}
+}
+
+// getGRPCOutcome maps the final status of a call to the outcome of a HTTP request.
+// Unavailable is a connection error, and the other failures of the server count as
+// HTTP 5xx, while the rest – eg. the errors of the application, or the cancellation
+// of the client – count as success.
+func getGRPCOutcome(err error) (int, error) {
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return 0, err
+	case codes.DeadlineExceeded, codes.Internal, codes.DataLoss:
+		return http.StatusInternalServerError, nil
+	}
+
+	return http.StatusOK, nil
 }
 
 func forwardServerToClient(src grpc.ServerStream, dst grpc.ClientStream) chan error {
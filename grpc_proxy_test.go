@@ -0,0 +1,160 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetGRPCOutcome(t *testing.T) {
+	type testCase struct {
+		name          string
+		err           error
+		expStatusCode int
+		expConnErr    bool
+	}
+
+	tt := []testCase{
+		{
+			name:          "the successful call is not a failure",
+			err:           nil,
+			expStatusCode: http.StatusOK,
+		},
+		{
+			name:          "the error of the application is not a failure",
+			err:           status.Error(codes.NotFound, "not found"),
+			expStatusCode: http.StatusOK,
+		},
+		{
+			name:          "the cancellation of the client is not a failure",
+			err:           status.Error(codes.Canceled, "canceled"),
+			expStatusCode: http.StatusOK,
+		},
+		{
+			name:          "the deadline is a failure of the server",
+			err:           status.Error(codes.DeadlineExceeded, "deadline exceeded"),
+			expStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:       "the unavailable service is a connection error",
+			err:        status.Error(codes.Unavailable, "unavailable"),
+			expConnErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			statusCode, connErr := getGRPCOutcome(tc.err)
+
+			if statusCode != tc.expStatusCode {
+				t.Errorf("expected status code: %d; got status code: %d\n", tc.expStatusCode, statusCode)
+			}
+
+			if isConnErr := connErr != nil; isConnErr != tc.expConnErr {
+				t.Errorf("expected connection error: %t; got connection error: %t\n", tc.expConnErr, isConnErr)
+			}
+		})
+	}
+}
+
+func TestGRPCProxyCircuitBreaker(t *testing.T) {
+	upstreamLn, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	upstream := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(upstream, health.NewServer())
+
+	go upstream.Serve(upstreamLn)
+	defer upstream.Stop()
+
+	// The service, which is up, but fails every call – so the
+	// error only surfaces by the final status of the stream.
+	failingLn, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	failing := grpc.NewServer(grpc.UnknownServiceHandler(func(any, grpc.ServerStream) error {
+		return status.Error(codes.Unavailable, "overloaded")
+	}))
+
+	go failing.Serve(failingLn)
+	defer failing.Stop()
+
+	type testCase struct {
+		name     string
+		address  string
+		expCode  codes.Code
+		expState string
+	}
+
+	tt := []testCase{
+		{
+			name:     "the successful call keeps the breaker closed",
+			address:  upstreamLn.Addr().String(),
+			expCode:  codes.OK,
+			expState: circuitStateTexts[CircuitClosed],
+		},
+		{
+			name:     "the call failed by the service opens the breaker",
+			address:  failingLn.Addr().String(),
+			expCode:  codes.Unavailable,
+			expState: circuitStateTexts[CircuitOpen],
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			host, port, _ := net.SplitHostPort(tc.address)
+
+			s := newService(&ServiceConfig{
+				ServiceType:    serviceGRPCType,
+				Name:           "mock-grpc",
+				Prefix:         "/grpc.health.v1.Health",
+				Protocol:       "http",
+				Host:           host,
+				Port:           port,
+				CircuitBreaker: &CircuitBreakerConfig{MinRequests: 1, ErrorRatePercent: 50},
+			})
+			defer s.close()
+
+			s.setState(StateAvailable)
+
+			proxyLn, err := net.Listen("tcp", "localhost:0")
+			if err != nil {
+				t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+			}
+
+			proxy := newGrpcProxy(0, newGatewayLogger(), func(string) *service { return s })
+
+			go proxy.server.Serve(proxyLn)
+			defer proxy.server.Stop()
+
+			conn, err := grpc.Dial(proxyLn.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+			}
+			defer conn.Close()
+
+			_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+
+			if code := status.Code(err); code != tc.expCode {
+				t.Errorf("expected code: %s; got code: %s\n", tc.expCode, code)
+			}
+
+			if state := s.breaker.getInfo().State; state != tc.expState {
+				t.Errorf("expected state: %s; got state: %s\n", tc.expState, state)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultHealthCheckMethod    = http.MethodGet
+	defaultHealthCheckThreshold = 1
+
+	// The maximum size of the response body, which is read for the assertions.
+	maxHealthCheckBodySize = 64 * 1024
+)
+
+// HealthCheckConfig describes the active healthcheck of a service.
+// Every field is optional, the defaults result in a GET request
+// to the StatusPath of the service, which expects HTTP 200.
+type HealthCheckConfig struct {
+	// The method of the request, by default it is GET.
+	Method string `json:"method"`
+
+	// The path of the request. If it is empty, then StatusPath is used.
+	Path string `json:"path"`
+
+	// The headers sent with the request.
+	Headers map[string]string `json:"headers"`
+
+	// The accepted status codes, either single codes – eg. "200" –,
+	// or inclusive ranges – eg. "200-299". By default only 200 is accepted.
+	ExpectedStatuses []string `json:"expectedStatuses"`
+
+	// If it is given, the body of the response must contain it.
+	BodyContains string `json:"bodyContains"`
+
+	// The dot separated path of a field in the JSON body of the response,
+	// eg. "status" or "checks.0.status". If JSONValue is given, the field
+	// must equal to it, otherwise it only must be present and not null.
+	JSONPath  string `json:"jsonPath"`
+	JSONValue string `json:"jsonValue"`
+
+	// The interval of the checks – eg. "30s" –, which overrides the
+	// global healthCheckInterval for this service.
+	Interval string `json:"interval"`
+
+	// The timeout of each check – eg. "2s". By default it is 10 seconds.
+	Timeout string `json:"timeout"`
+
+	// The name of the service, which is checked by the grpc.health.v1 protocol
+	// in case of gRPC services. By default it is empty, which means the whole server.
+	GRPCService string `json:"grpcService"`
+
+	// The count of consecutive successes and failures, which are needed
+	// to change the state of an instance. By default both are 1. The very
+	// first check of an instance changes its state regardless of them.
+	HealthyThreshold   int `json:"healthyThreshold"`
+	UnhealthyThreshold int `json:"unhealthyThreshold"`
+}
+
+type statusRange struct {
+	from int
+	to   int
+}
+
+// healthCheck is the parsed, ready to use form of HealthCheckConfig.
+type healthCheck struct {
+	// gRPC services are checked by the grpc.health.v1 protocol,
+	// where only the timeout, thresholds and service name apply.
+	isGRPC      bool
+	grpcService string
+
+	method       string
+	path         string
+	headers      http.Header
+	statuses     []statusRange
+	bodyContains string
+
+	jsonPath  []string
+	jsonValue string
+
+	interval time.Duration
+	timeout  time.Duration
+
+	healthyThreshold   int
+	unhealthyThreshold int
+}
+
+// newHealthCheck creates the healthcheck from the given – already validated –
+// config. The statusPath is used, if there is no path in the config.
+func newHealthCheck(conf *HealthCheckConfig, statusPath string, isGRPC bool) *healthCheck {
+	hc := &healthCheck{
+		isGRPC:             isGRPC,
+		method:             defaultHealthCheckMethod,
+		path:               statusPath,
+		headers:            http.Header{},
+		statuses:           []statusRange{{from: http.StatusOK, to: http.StatusOK}},
+		timeout:            timeOutDur,
+		healthyThreshold:   defaultHealthCheckThreshold,
+		unhealthyThreshold: defaultHealthCheckThreshold,
+	}
+
+	if conf == nil {
+		return hc
+	}
+
+	if conf.Method != "" {
+		hc.method = strings.ToUpper(conf.Method)
+	}
+
+	if conf.Path != "" {
+		hc.path = conf.Path
+	}
+
+	for k, v := range conf.Headers {
+		hc.headers.Set(k, v)
+	}
+
+	if len(conf.ExpectedStatuses) > 0 {
+		hc.statuses = make([]statusRange, 0, len(conf.ExpectedStatuses))
+
+		for _, s := range conf.ExpectedStatuses {
+			sr, _ := parseStatusRange(s)
+			hc.statuses = append(hc.statuses, sr)
+		}
+	}
+
+	hc.bodyContains = conf.BodyContains
+	hc.grpcService = conf.GRPCService
+
+	if conf.JSONPath != "" {
+		hc.jsonPath = strings.Split(conf.JSONPath, ".")
+		hc.jsonValue = conf.JSONValue
+	}
+
+	if d, err := time.ParseDuration(conf.Interval); err == nil {
+		hc.interval = d
+	}
+
+	if d, err := time.ParseDuration(conf.Timeout); err == nil {
+		hc.timeout = d
+	}
+
+	if conf.HealthyThreshold > 0 {
+		hc.healthyThreshold = conf.HealthyThreshold
+	}
+
+	if conf.UnhealthyThreshold > 0 {
+		hc.unhealthyThreshold = conf.UnhealthyThreshold
+	}
+
+	return hc
+}
+
+// validateHealthCheck validates the given config.
+// It returns the first error that occured.
+func validateHealthCheck(conf *HealthCheckConfig) error {
+	if conf == nil {
+		return nil
+	}
+
+	if conf.Path != "" && !strings.HasPrefix(conf.Path, "/") {
+		return errBadHealthCheckPath
+	}
+
+	for _, s := range conf.ExpectedStatuses {
+		if _, err := parseStatusRange(s); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range []string{conf.Interval, conf.Timeout} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return errBadHealthCheckDuration
+		}
+	}
+
+	if conf.HealthyThreshold < 0 || conf.UnhealthyThreshold < 0 {
+		return errBadHealthCheckThreshold
+	}
+
+	return nil
+}
+
+// parseStatusRange parses a single status code – eg. "200" –
+// or an inclusive range of them – eg. "200-299".
+func parseStatusRange(s string) (statusRange, error) {
+	from, to, isRange := strings.Cut(s, "-")
+	if !isRange {
+		to = from
+	}
+
+	f, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return statusRange{}, errBadHealthCheckStatus
+	}
+
+	t, err := strconv.Atoi(strings.TrimSpace(to))
+	if err != nil {
+		return statusRange{}, errBadHealthCheckStatus
+	}
+
+	if f < 100 || t > 599 || f > t {
+		return statusRange{}, errBadHealthCheckStatus
+	}
+
+	return statusRange{from: f, to: t}, nil
+}
+
+// check performs one healthcheck against the given address, using the given
+// client, whose timeout must not be shorter than the one of the healthcheck.
+// It returns nil, if the check passed. If the instance
+// answered, but the response is not the expected one, the returned
+// error wraps errHealthCheckFailed.
+func (hc *healthCheck) check(cl httpClient, address string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, hc.method, address+hc.path, nil)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range hc.headers {
+		req.Header[k] = v
+	}
+
+	res, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	if !hc.isExpectedStatus(res.StatusCode) {
+		return fmt.Errorf("%w: unexpected status code: %d", errHealthCheckFailed, res.StatusCode)
+	}
+
+	if res.Body == nil || (hc.bodyContains == "" && len(hc.jsonPath) == 0) {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxHealthCheckBodySize))
+	if err != nil {
+		return err
+	}
+
+	return hc.checkBody(body)
+}
+
+// checkGRPC performs one healthcheck by the standard grpc.health.v1 protocol
+// on the given connection. Only the SERVING status passes the check.
+func (hc *healthCheck) checkGRPC(conn grpc.ClientConnInterface) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.timeout)
+	defer cancel()
+
+	req := &grpc_health_v1.HealthCheckRequest{Service: hc.grpcService}
+
+	res, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, req)
+	if err != nil {
+		// The server answered, but it does not know the service.
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("%w: %v", errHealthCheckFailed, err)
+		}
+		return err
+	}
+
+	if st := res.GetStatus(); st != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("%w: status: %s", errHealthCheckFailed, st)
+	}
+
+	return nil
+}
+
+func (hc *healthCheck) isExpectedStatus(code int) bool {
+	for _, sr := range hc.statuses {
+		if code >= sr.from && code <= sr.to {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBody runs the assertions of the body.
+func (hc *healthCheck) checkBody(body []byte) error {
+	if hc.bodyContains != "" && !bytes.Contains(body, []byte(hc.bodyContains)) {
+		return fmt.Errorf("%w: the body does not contain: %s", errHealthCheckFailed, hc.bodyContains)
+	}
+
+	if len(hc.jsonPath) == 0 {
+		return nil
+	}
+
+	var data any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return fmt.Errorf("%w: %v", errHealthCheckFailed, err)
+	}
+
+	path := strings.Join(hc.jsonPath, ".")
+
+	value, ok := getJSONField(data, hc.jsonPath)
+	if !ok || value == nil {
+		return fmt.Errorf("%w: the field is missing: %s", errHealthCheckFailed, path)
+	}
+
+	if hc.jsonValue != "" && fmt.Sprint(value) != hc.jsonValue {
+		return fmt.Errorf("%w: the field %s is %v; expected: %s", errHealthCheckFailed, path, value, hc.jsonValue)
+	}
+
+	return nil
+}
+
+// getJSONField walks the decoded JSON data by the given keys.
+// The elements of arrays are referred by their indexes.
+func getJSONField(data any, keys []string) (any, bool) {
+	for _, key := range keys {
+		switch v := data.(type) {
+		case map[string]any:
+			val, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			data = val
+		case []any:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return nil, false
+			}
+			data = v[idx]
+		default:
+			return nil, false
+		}
+	}
+
+	return data, true
+}
@@ -0,0 +1,312 @@
+package gateway
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestValidateHealthCheck(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *HealthCheckConfig
+		err  error
+	}
+
+	tt := []testCase{
+		{
+			name: "the function returns nil if there is no config",
+			conf: nil,
+			err:  nil,
+		},
+		{
+			name: "the function returns error if the path is not started with slash",
+			conf: &HealthCheckConfig{Path: "health"},
+			err:  errBadHealthCheckPath,
+		},
+		{
+			name: "the function returns error if the expected status is not a number",
+			conf: &HealthCheckConfig{ExpectedStatuses: []string{"2xx"}},
+			err:  errBadHealthCheckStatus,
+		},
+		{
+			name: "the function returns error if the range of statuses is reversed",
+			conf: &HealthCheckConfig{ExpectedStatuses: []string{"299-200"}},
+			err:  errBadHealthCheckStatus,
+		},
+		{
+			name: "the function returns error if the interval is invalid",
+			conf: &HealthCheckConfig{Interval: "30"},
+			err:  errBadHealthCheckDuration,
+		},
+		{
+			name: "the function returns error if the timeout is not positive",
+			conf: &HealthCheckConfig{Timeout: "0s"},
+			err:  errBadHealthCheckDuration,
+		},
+		{
+			name: "the function returns error if a threshold is negative",
+			conf: &HealthCheckConfig{UnhealthyThreshold: -1},
+			err:  errBadHealthCheckThreshold,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &HealthCheckConfig{
+				Method:             "HEAD",
+				Path:               "/healthz",
+				ExpectedStatuses:   []string{"200-299", "304"},
+				Interval:           "15s",
+				Timeout:            "500ms",
+				HealthyThreshold:   2,
+				UnhealthyThreshold: 3,
+			},
+			err: nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateHealthCheck(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	type testCase struct {
+		name       string
+		conf       *HealthCheckConfig
+		statusCode int
+		body       string
+		err        error
+	}
+
+	tt := []testCase{
+		{
+			name:       "the check fails if the status is not expected",
+			conf:       nil,
+			statusCode: http.StatusNoContent,
+			err:        errHealthCheckFailed,
+		},
+		{
+			name:       "the check passes if the status is in the expected range",
+			conf:       &HealthCheckConfig{ExpectedStatuses: []string{"200-299"}},
+			statusCode: http.StatusNoContent,
+			err:        nil,
+		},
+		{
+			name:       "the check fails if the body does not contain the substring",
+			conf:       &HealthCheckConfig{BodyContains: "OK"},
+			statusCode: http.StatusOK,
+			body:       "DEGRADED",
+			err:        errHealthCheckFailed,
+		},
+		{
+			name:       "the check fails if the field of the json body differs",
+			conf:       &HealthCheckConfig{JSONPath: "checks.1.status", JSONValue: "up"},
+			statusCode: http.StatusOK,
+			body:       `{"checks":[{"status":"up"},{"status":"down"}]}`,
+			err:        errHealthCheckFailed,
+		},
+		{
+			name:       "the check fails if the field of the json body is missing",
+			conf:       &HealthCheckConfig{JSONPath: "status"},
+			statusCode: http.StatusOK,
+			body:       `{"state":"up"}`,
+			err:        errHealthCheckFailed,
+		},
+		{
+			name:       "the check passes if the field of the json body equals",
+			conf:       &HealthCheckConfig{JSONPath: "db.connected", JSONValue: "true"},
+			statusCode: http.StatusOK,
+			body:       `{"db":{"connected":true}}`,
+			err:        nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				hc = newHealthCheck(tc.conf, defaultStatusPath, false)
+				cl = &mockHttpClient{
+					mockDo: func(r *http.Request) (*http.Response, error) {
+						return &http.Response{
+							StatusCode: tc.statusCode,
+							Body:       io.NopCloser(strings.NewReader(tc.body)),
+						}, nil
+					},
+				}
+			)
+
+			if err := hc.check(cl, "http://localhost:3000"); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	// The timeout of the healthcheck is longer than the one of the service,
+	// so the slow response must not fail the check.
+	s := newTestService(t, srv, &ServiceConfig{
+		Name:        "mock-name",
+		Prefix:      "/api/mock",
+		TimeOutSec:  1,
+		HealthCheck: &HealthCheckConfig{Timeout: "3s"},
+	})
+
+	if err := s.checkStatus(); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	if state := s.getState(); state != StateAvailable {
+		t.Errorf("expected state: %d; got state: %d\n", StateAvailable, state)
+	}
+}
+
+func TestRecordCheck(t *testing.T) {
+	type testCase struct {
+		name     string
+		results  []bool
+		expState serviceState
+	}
+
+	hc := newHealthCheck(&HealthCheckConfig{HealthyThreshold: 2, UnhealthyThreshold: 3}, defaultStatusPath, false)
+
+	tt := []testCase{
+		{
+			name:     "the first result changes the state immediately",
+			results:  []bool{false},
+			expState: StateRefused,
+		},
+		{
+			name:     "a single failure does not change the state of an available instance",
+			results:  []bool{true, false, false},
+			expState: StateAvailable,
+		},
+		{
+			name:     "the consecutive failures reaching the threshold change the state",
+			results:  []bool{true, false, false, false},
+			expState: StateRefused,
+		},
+		{
+			name:     "a success resets the count of failures",
+			results:  []bool{true, false, false, true, false, false},
+			expState: StateAvailable,
+		},
+		{
+			name:     "the consecutive successes reaching the threshold change the state",
+			results:  []bool{false, true, true},
+			expState: StateAvailable,
+		},
+		{
+			name:     "a single success does not change the state of a refused instance",
+			results:  []bool{false, true},
+			expState: StateRefused,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			inst := newInstance(&InstanceConfig{Host: "localhost", Port: "3000"}, "http", timeOutDur, nil, nil)
+
+			for _, passed := range tc.results {
+				inst.recordCheck(passed, hc)
+			}
+
+			if state := inst.getState(); state != tc.expState {
+				t.Errorf("expected state: %d; got state: %d\n", tc.expState, state)
+			}
+		})
+	}
+}
+
+func TestCheckGRPCStatus(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	var (
+		srv          = grpc.NewServer()
+		healthServer = health.NewServer()
+	)
+
+	grpc_health_v1.RegisterHealthServer(srv, healthServer)
+
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	type testCase struct {
+		name     string
+		status   grpc_health_v1.HealthCheckResponse_ServingStatus
+		service  string
+		expState serviceState
+	}
+
+	tt := []testCase{
+		{
+			name:     "the serving server is available",
+			status:   grpc_health_v1.HealthCheckResponse_SERVING,
+			expState: StateAvailable,
+		},
+		{
+			name:     "the not serving server is refused",
+			status:   grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+			expState: StateRefused,
+		},
+		{
+			name:     "the serving service given by its name is available",
+			status:   grpc_health_v1.HealthCheckResponse_SERVING,
+			service:  "example.TestService",
+			expState: StateAvailable,
+		},
+		{
+			name:     "the unknown service is refused",
+			service:  "example.UnknownService",
+			expState: StateRefused,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.service != "example.UnknownService" {
+				healthServer.SetServingStatus(tc.service, tc.status)
+			}
+
+			s := newService(&ServiceConfig{
+				ServiceType: serviceGRPCType,
+				Protocol:    "http",
+				Host:        host,
+				Port:        port,
+				HealthCheck: &HealthCheckConfig{GRPCService: tc.service, Timeout: "2s"},
+			})
+			defer s.close()
+
+			if err := s.checkStatus(); err != nil {
+				t.Errorf("expected error: %v; got error: %v\n", nil, err)
+			}
+
+			if state := s.getState(); state != tc.expState {
+				t.Errorf("expected state: %d; got state: %d\n", tc.expState, state)
+			}
+		})
+	}
+}
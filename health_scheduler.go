@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthCheckConcurrency = 8
+
+	// The checks of a service are spread by ±10% of its interval,
+	// so the services are not checked in lockstep.
+	healthCheckJitter = 0.1
+
+	// The first check of a service is delayed by a random
+	// duration up to this, so the boot does not fire all at once.
+	maxInitialHealthCheckDelay = time.Second
+)
+
+// checkResult stores the outcome of the last healthcheck.
+type checkResult struct {
+	mu      sync.RWMutex
+	at      time.Time
+	latency time.Duration
+	err     error
+}
+
+func (cr *checkResult) set(at time.Time, latency time.Duration, err error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.at = at
+	cr.latency = latency
+	cr.err = err
+}
+
+// inherit copies the result of the given – old – check.
+func (cr *checkResult) inherit(old *checkResult) {
+	old.mu.RLock()
+	defer old.mu.RUnlock()
+
+	cr.set(old.at, old.latency, old.err)
+}
+
+// getInfo returns the public view of the result,
+// or nil if there was no check yet.
+func (cr *checkResult) getInfo() *HealthCheckInfo {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	if cr.at.IsZero() {
+		return nil
+	}
+
+	info := &HealthCheckInfo{
+		At:      cr.at,
+		Latency: cr.latency.String(),
+	}
+
+	if cr.err != nil {
+		info.Error = cr.err.Error()
+	}
+
+	return info
+}
+
+// healthScheduler checks each service on its own schedule. The count
+// of the checks running at the same time is bounded, so a lot of hung
+// backends can not exhaust the resources of the Gateway.
+type healthScheduler struct {
+	registry *registry
+
+	// The semaphore which bounds the concurrent checks.
+	sem chan struct{}
+
+	// The cancel functions of the running checkers by their services.
+	// Only touched by the goroutine of run.
+	running map[*service]context.CancelFunc
+}
+
+func newHealthScheduler(r *registry, concurrency int) *healthScheduler {
+	if concurrency <= 0 {
+		concurrency = defaultHealthCheckConcurrency
+	}
+
+	return &healthScheduler{
+		registry: r,
+		sem:      make(chan struct{}, concurrency),
+		running:  make(map[*service]context.CancelFunc),
+	}
+}
+
+// run starts a checker for every registered service, and keeps them in
+// sync with the registry – on every change the checkers of the removed
+// services are stopped, and the new ones are started. It blocks until
+// the ctx is done, then stops all the checkers.
+func (hs *healthScheduler) run(ctx context.Context) {
+	hs.sync(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			for s, cancel := range hs.running {
+				cancel()
+				delete(hs.running, s)
+			}
+			return
+		case <-hs.registry.changes:
+			hs.sync(ctx)
+		}
+	}
+}
+
+// sync starts and stops the checkers based on the currently registered services.
+func (hs *healthScheduler) sync(ctx context.Context) {
+	current := make(map[*service]struct{})
+
+	for _, s := range hs.registry.getAllServices() {
+		current[s] = struct{}{}
+
+		if _, ok := hs.running[s]; ok {
+			continue
+		}
+
+		checkerCtx, cancel := context.WithCancel(ctx)
+		hs.running[s] = cancel
+
+		go hs.checkLoop(checkerCtx, s)
+	}
+
+	for s, cancel := range hs.running {
+		if _, ok := current[s]; !ok {
+			cancel()
+			delete(hs.running, s)
+		}
+	}
+}
+
+// checkLoop checks the given service periodically, until the ctx is done.
+func (hs *healthScheduler) checkLoop(ctx context.Context, s *service) {
+	delay := randomDuration(maxInitialHealthCheckDelay)
+
+	for {
+		t := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case hs.sem <- struct{}{}:
+		}
+
+		hs.registry.checkServiceStatus(s)
+
+		<-hs.sem
+
+		// The interval is read on every round, since
+		// the global one could be changed by a reload.
+		delay = withJitter(s.getHealthCheckInterval(hs.registry.getHealthCheckFrequency()))
+
+		// The ejected instances are probed as soon as their ejection is over.
+		if d := s.getNextCheckDelay(delay); d < delay {
+			delay = d
+		}
+	}
+}
+
+// withJitter returns the given duration randomly changed by at most ±healthCheckJitter.
+func withJitter(d time.Duration) time.Duration {
+	spread := time.Duration(float64(d) * healthCheckJitter)
+
+	return d - spread + randomDuration(2*spread)
+}
+
+// randomDuration returns a random duration in [0,d).
+func randomDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(randomIntn(int(d)))
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithJitter(t *testing.T) {
+	const d = time.Minute
+
+	var (
+		spread = time.Duration(float64(d) * healthCheckJitter)
+		min    = d - spread
+		max    = d + spread
+	)
+
+	for i := 0; i < 100; i++ {
+		if got := withJitter(d); got < min || got > max {
+			t.Fatalf("expected duration between %v and %v; got duration: %v\n", min, max, got)
+		}
+	}
+}
+
+func TestHealthSchedulerSync(t *testing.T) {
+	r := newRegistry()
+
+	for _, conf := range []*ServiceConfig{
+		{Name: "mock-name-1", Prefix: "/api/foo", Host: "localhost", Port: "3001", Protocol: "http"},
+		{Name: "mock-name-2", Prefix: "/api/bar", Host: "localhost", Port: "3002", Protocol: "http"},
+	} {
+		if err := r.addService(conf); err != nil {
+			t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hs := newHealthScheduler(r, 0)
+
+	if cap(hs.sem) != defaultHealthCheckConcurrency {
+		t.Errorf("expected concurrency: %d; got concurrency: %d\n", defaultHealthCheckConcurrency, cap(hs.sem))
+	}
+
+	hs.sync(ctx)
+
+	if l := len(hs.running); l != 2 {
+		t.Fatalf("expected running checkers: %d; got running checkers: %d\n", 2, l)
+	}
+
+	removed := r.getServiceByName("mock-name-1")
+
+	if err := r.removeService("mock-name-1"); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	hs.sync(ctx)
+
+	if l := len(hs.running); l != 1 {
+		t.Fatalf("expected running checkers: %d; got running checkers: %d\n", 1, l)
+	}
+
+	if _, ok := hs.running[removed]; ok {
+		t.Errorf("expected the checker of the removed service to be stopped\n")
+	}
+}
+
+func TestHealthSchedulerRun(t *testing.T) {
+	r := newRegistry()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var (
+		hs   = newHealthScheduler(r, 1)
+		done = make(chan struct{})
+	)
+
+	go func() {
+		hs.run(ctx)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the scheduler to stop after the ctx is done\n")
+	}
+
+	if l := len(hs.running); l != 0 {
+		t.Errorf("expected running checkers: %d; got running checkers: %d\n", 0, l)
+	}
+}
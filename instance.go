@@ -0,0 +1,293 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// InstanceConfig describes one upstream instance of a service.
+type InstanceConfig struct {
+	// The basic host:port format, where the instance listens at.
+	Host string `json:"host"`
+	Port string `json:"port"`
+
+	// The relative weight of the instance, which is used
+	// by the weighted load balancing strategies.
+	// If it is not given, then the default weight is used.
+	Weight int `json:"weight"`
+}
+
+// Instance is the read-only view of an upstream instance,
+// which is handed to the LoadBalancer implementations.
+type Instance interface {
+	GetAddress() string
+	GetWeight() int
+	GetInFlight() int64
+}
+
+const (
+	defaultInstanceWeight = 1
+)
+
+type instance struct {
+	host     string
+	port     string
+	protocol string
+	weight   int
+
+	mu    sync.RWMutex
+	state serviceState
+
+	// The count of consecutive successful and failed healthchecks.
+	successes int
+	failures  int
+
+	lastCheck checkResult
+
+	// The passive healthcheck based on the proxied requests.
+	outlier outlierState
+
+	// The TLS config of the connections, if the protocol is https.
+	// Its certificates are verified against the host of the instance.
+	tlsConfig *tls.Config
+
+	// The connection of gRPC instances, which is created on first use,
+	// then shared by the healthchecks and the proxied calls.
+	grpcConn *grpc.ClientConn
+
+	// The count of requests that are currently being served by this instance.
+	inFlight int64
+
+	clientPool sync.Pool
+
+	// The client of the healthchecks. It has no timeout of its own, since
+	// the timeout of the healthcheck may be longer than the one of the service.
+	healthClient httpClient
+}
+
+var _ Instance = (*instance)(nil)
+
+// newInstance creates the instance, whose clients send the requests by the
+// given transport – shared by the service. The TLS config is used by gRPC.
+func newInstance(conf *InstanceConfig, protocol string, timeOut time.Duration, transport http.RoundTripper, tlsConfig *tls.Config) *instance {
+	weight := func() int {
+		if conf.Weight > 0 {
+			return conf.Weight
+		}
+		return defaultInstanceWeight
+	}()
+
+	inst := &instance{
+		host:      conf.Host,
+		port:      conf.Port,
+		protocol:  protocol,
+		weight:    weight,
+		state:     StateUnknown,
+		tlsConfig: tlsConfig,
+	}
+
+	inst.clientPool = sync.Pool{
+		New: func() any {
+			return newHttpClient(withHostName(inst.getAddressWithProtocol()), withTimeOut(timeOut), withTransport(transport))
+		},
+	}
+
+	inst.healthClient = newHttpClient(withHostName(inst.getAddressWithProtocol()), withTimeOut(0), withTransport(transport))
+
+	return inst
+}
+
+// GetAddress returns the address of the instance in the form HOST:PORT.
+func (i *instance) GetAddress() string {
+	return fmt.Sprintf("%s:%s", i.host, i.port)
+}
+
+// GetWeight returns the weight of the instance.
+func (i *instance) GetWeight() int {
+	return i.weight
+}
+
+// GetInFlight returns the count of the requests currently served by the instance.
+func (i *instance) GetInFlight() int64 {
+	return atomic.LoadInt64(&i.inFlight)
+}
+
+func (i *instance) getAddressWithProtocol() string {
+	return fmt.Sprintf("%s://%s", i.protocol, i.GetAddress())
+}
+
+func (i *instance) acquire() {
+	atomic.AddInt64(&i.inFlight, 1)
+}
+
+func (i *instance) release() {
+	atomic.AddInt64(&i.inFlight, -1)
+}
+
+func (i *instance) getState() serviceState {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.state
+}
+
+func (i *instance) setState(state serviceState) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.state = state
+}
+
+// inheritState copies the health of the given – old – instance,
+// which is replaced by this one, so it does not need to be checked again.
+func (i *instance) inheritState(old *instance) {
+	old.mu.RLock()
+	state, successes, failures := old.state, old.successes, old.failures
+	old.mu.RUnlock()
+
+	i.mu.Lock()
+	i.state, i.successes, i.failures = state, successes, failures
+	i.mu.Unlock()
+
+	i.lastCheck.inherit(&old.lastCheck)
+	i.outlier.inherit(&old.outlier)
+}
+
+// isAvailable tells if the instance can be picked by the load balancer,
+// which means it passed the healthcheck and it is not ejected.
+func (i *instance) isAvailable() bool {
+	return i.getState() == StateAvailable && !i.outlier.isEjected()
+}
+
+// recordOutcome records the result of a proxied request for the outlier detection.
+// It returns true, if the instance got ejected by this result.
+func (i *instance) recordOutcome(od *outlierDetection, statusCode int, err error) bool {
+	var (
+		isConnErr = err != nil
+		failed    = isConnErr || statusCode >= http.StatusInternalServerError
+	)
+
+	return i.outlier.record(od, failed, isConnErr, time.Now())
+}
+
+// getGRPCConn returns the connection of the instance, creating it on first use.
+// The dial does not block, the connection is established in the background.
+func (i *instance) getGRPCConn() (*grpc.ClientConn, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.grpcConn != nil {
+		return i.grpcConn, nil
+	}
+
+	conn, err := grpc.Dial(i.GetAddress(), grpc.WithTransportCredentials(i.getGRPCCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	i.grpcConn = conn
+
+	return conn, nil
+}
+
+// getGRPCCredentials returns the credentials of the gRPC connection.
+// It is plaintext, unless the protocol of the instance is https.
+func (i *instance) getGRPCCredentials() credentials.TransportCredentials {
+	if i.protocol != "https" {
+		return insecure.NewCredentials()
+	}
+
+	if i.tlsConfig == nil {
+		return credentials.NewTLS(&tls.Config{})
+	}
+
+	return credentials.NewTLS(i.tlsConfig)
+}
+
+// close releases the resources held by the instance.
+func (i *instance) close() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.grpcConn != nil {
+		i.grpcConn.Close()
+		i.grpcConn = nil
+	}
+}
+
+func (i *instance) getClient() httpClient {
+	return i.clientPool.Get().(httpClient)
+}
+
+func (i *instance) putClient(cl httpClient) {
+	i.clientPool.Put(cl)
+}
+
+// checkStatus performs the healthcheck against the instance, then sets
+// its state based on the result. An unexpected response only changes
+// the state, the error is returned if the instance could not be reached.
+func (i *instance) checkStatus(hc *healthCheck) error {
+	start := time.Now()
+
+	err := func() error {
+		if hc.isGRPC {
+			conn, err := i.getGRPCConn()
+			if err != nil {
+				return err
+			}
+			return hc.checkGRPC(conn)
+		}
+
+		return hc.check(i.healthClient, i.getAddressWithProtocol())
+	}()
+
+	i.lastCheck.set(start, time.Since(start), err)
+	i.recordCheck(err == nil, hc)
+
+	// The successful check is the probe, which re-admits the ejected instance.
+	if err == nil {
+		i.outlier.readmit(time.Now())
+	}
+
+	if errors.Is(err, errHealthCheckFailed) {
+		return nil
+	}
+
+	return err
+}
+
+// recordCheck counts the consecutive results, and changes the state once
+// the threshold of the healthcheck is reached. The first result of an
+// instance – whose state is not known yet – changes the state immediately.
+func (i *instance) recordCheck(passed bool, hc *healthCheck) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	isFirst := i.state == StateRegistered || i.state == StateUnknown
+
+	if passed {
+		i.successes++
+		i.failures = 0
+
+		if isFirst || i.successes >= hc.healthyThreshold {
+			i.state = StateAvailable
+		}
+		return
+	}
+
+	i.failures++
+	i.successes = 0
+
+	if isFirst || i.failures >= hc.unhealthyThreshold {
+		i.state = StateRefused
+	}
+}
@@ -0,0 +1,311 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSCacheTTL = 5 * time.Minute
+
+	// An unknown key ID – or a failed fetch – refreshes the keys of a URL at
+	// most this often, so the tokens with random key IDs can not flood the server.
+	jwksMinRefreshInterval = 10 * time.Second
+
+	jwksFetchTimeout = 5 * time.Second
+	maxJWKSSize      = 1 << 20
+)
+
+// jwtKey is a key, which the signatures of the tokens are verified by.
+type jwtKey struct {
+	// The key ID, which is matched against the kid of the tokens. If it
+	// is empty, the key is tried for every token of its algorithm.
+	id        string
+	algorithm string
+
+	// Either []byte for HS256, *rsa.PublicKey for RS256 or *ecdsa.PublicKey for ES256.
+	key any
+}
+
+// jwk is a JSON Web Key. See RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	// The secret of the symmetric keys.
+	K string `json:"k"`
+
+	// The modulus and exponent of the RSA keys.
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// The curve and the coordinates of the EC keys.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksSource is a JWKS document – either a file or a URL –, whose keys are cached.
+// The file is read again, once it is changed, while the URL is fetched again, once
+// the cache expires, or a token refers to an unknown key.
+type jwksSource struct {
+	file     *watchedFile
+	url      string
+	client   *http.Client
+	cacheTTL time.Duration
+
+	mu        sync.Mutex
+	keys      []*jwtKey
+	fetchedAt time.Time
+}
+
+// newJWKSSource creates the source of the JWKS of the given – already
+// validated – config. It returns nil, if there is no JWKS.
+func newJWKSSource(conf *JWTConfig) *jwksSource {
+	if conf.JWKSFile == "" && conf.JWKSURL == "" {
+		return nil
+	}
+
+	if conf.JWKSFile != "" {
+		return &jwksSource{file: &watchedFile{path: conf.JWKSFile}}
+	}
+
+	src := &jwksSource{
+		url:      conf.JWKSURL,
+		client:   &http.Client{Timeout: jwksFetchTimeout},
+		cacheTTL: defaultJWKSCacheTTL,
+	}
+
+	if d, err := time.ParseDuration(conf.JWKSCacheTTL); err == nil {
+		src.cacheTTL = d
+	}
+
+	return src
+}
+
+// getKeys returns the current keys. If the keys can not be read, the last good
+// ones are kept. The URL is fetched again, once the cache expires, or – at most
+// once in every jwksMinRefreshInterval – if the given key ID is unknown, eg.
+// the keys were just rotated.
+func (src *jwksSource) getKeys(kid string, now time.Time) []*jwtKey {
+	if src == nil {
+		return nil
+	}
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if src.file != nil {
+		if src.file.isChanged() || src.keys == nil {
+			if keys, err := readJWKSFile(src.file.path); err == nil {
+				src.keys = keys
+			}
+		}
+
+		return src.keys
+	}
+
+	var (
+		age       = now.Sub(src.fetchedAt)
+		isExpired = age >= src.cacheTTL
+		isRetried = age >= jwksMinRefreshInterval
+		isUnknown = src.keys == nil || (kid != "" && !hasJWTKey(src.keys, kid))
+	)
+
+	if isExpired || (isUnknown && isRetried) {
+		src.fetchedAt = now
+
+		if keys, err := src.fetch(); err == nil {
+			src.keys = keys
+		}
+	}
+
+	return src.keys
+}
+
+// fetch downloads the JWKS from the URL.
+func (src *jwksSource) fetch() ([]*jwtKey, error) {
+	res, err := src.client.Get(src.url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errBadJWKS
+	}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxJWKSSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseJWKS(b)
+}
+
+// hasJWTKey tells if there is a key with the given ID.
+func hasJWTKey(keys []*jwtKey, kid string) bool {
+	for _, k := range keys {
+		if k.id == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// readJWKSFile reads the keys of the given JWKS file.
+func readJWKSFile(path string) ([]*jwtKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errBadJWKS
+	}
+	return parseJWKS(b)
+}
+
+// parseJWKS parses the keys of the given JWKS document. The keys of unsupported
+// types, and the ones which are not used for signatures, are skipped.
+func parseJWKS(b []byte) ([]*jwtKey, error) {
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, errBadJWKS
+	}
+
+	keys := make([]*jwtKey, 0, len(set.Keys))
+
+	for _, k := range set.Keys {
+		if k == nil || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		if key, err := k.parse(); err == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// parse returns the key of the JWK. The algorithm of the key
+// – if it is given – must match the type of the key.
+func (k *jwk) parse() (*jwtKey, error) {
+	key := &jwtKey{id: k.Kid}
+
+	switch k.Kty {
+	case "oct":
+		secret, err := decodeJWTSegment(k.K)
+		if err != nil || len(secret) == 0 {
+			return nil, errBadJWKS
+		}
+
+		key.algorithm, key.key = JWTAlgorithmHS256, secret
+	case "RSA":
+		n, errN := decodeJWTSegment(k.N)
+		e, errE := decodeJWTSegment(k.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, errBadJWKS
+		}
+
+		key.algorithm = JWTAlgorithmRS256
+		key.key = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	case "EC":
+		x, errX := decodeJWTSegment(k.X)
+		y, errY := decodeJWTSegment(k.Y)
+		if k.Crv != "P-256" || errX != nil || errY != nil {
+			return nil, errBadJWKS
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errBadJWKS
+		}
+
+		key.algorithm, key.key = JWTAlgorithmES256, pub
+	default:
+		return nil, errBadJWKS
+	}
+
+	if k.Alg != "" && k.Alg != key.algorithm {
+		return nil, errBadJWKS
+	}
+
+	return key, nil
+}
+
+// loadJWTKey returns the static key of the given config. The public
+// keys are read from PEM files – either a public key or a certificate.
+func loadJWTKey(conf *JWTKeyConfig) (*jwtKey, error) {
+	key := &jwtKey{id: conf.ID, algorithm: conf.Algorithm}
+
+	if conf.Algorithm == JWTAlgorithmHS256 {
+		if conf.Secret == "" {
+			return nil, errBadJWTKey
+		}
+
+		key.key = []byte(conf.Secret)
+
+		return key, nil
+	}
+
+	b, err := os.ReadFile(conf.PublicKeyFile)
+	if err != nil {
+		return nil, errBadJWTKey
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errBadJWTKey
+	}
+
+	var pub any
+
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errBadJWTKey
+		}
+
+		pub = cert.PublicKey
+	} else if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return nil, errBadJWTKey
+	}
+
+	switch pk := pub.(type) {
+	case *rsa.PublicKey:
+		if conf.Algorithm != JWTAlgorithmRS256 {
+			return nil, errBadJWTKey
+		}
+	case *ecdsa.PublicKey:
+		if conf.Algorithm != JWTAlgorithmES256 || pk.Curve != elliptic.P256() {
+			return nil, errBadJWTKey
+		}
+	default:
+		return nil, errBadJWTKey
+	}
+
+	key.key = pub
+
+	return key, nil
+}
@@ -0,0 +1,203 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestRSAKey generates a RSA key, which is used to sign the tokens of the tests.
+func newTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+	return key
+}
+
+// newTestECKey generates a P-256 key, which is used to sign the tokens of the tests.
+func newTestECKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+	return key
+}
+
+// newTestJWK returns the JWK of the given public key or secret.
+func newTestJWK(kid string, key any) map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+
+	switch k := key.(type) {
+	case []byte:
+		return map[string]string{"kty": "oct", "kid": kid, "k": enc(k)}
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"n":   enc(k.N.Bytes()),
+			"e":   enc(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		return map[string]string{
+			"kty": "EC",
+			"kid": kid,
+			"crv": "P-256",
+			"x":   enc(k.X.FillBytes(make([]byte, 32))),
+			"y":   enc(k.Y.FillBytes(make([]byte, 32))),
+		}
+	}
+
+	return nil
+}
+
+// newTestJWKS returns the JWKS document of the given keys.
+func newTestJWKS(t *testing.T, keys ...map[string]string) []byte {
+	b, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+	return b
+}
+
+func TestParseJWKS(t *testing.T) {
+	type testCase struct {
+		name    string
+		key     map[string]string
+		expAlg  string
+		isValid bool
+	}
+
+	var (
+		rsaKey = newTestRSAKey(t)
+		ecKey  = newTestECKey(t)
+	)
+
+	withField := func(jwk map[string]string, k string, v string) map[string]string {
+		jwk[k] = v
+		return jwk
+	}
+
+	tt := []testCase{
+		{
+			name:    "the symmetric key is parsed",
+			key:     newTestJWK("oct", []byte("secret")),
+			expAlg:  JWTAlgorithmHS256,
+			isValid: true,
+		},
+		{
+			name:    "the RSA key is parsed",
+			key:     newTestJWK("rsa", &rsaKey.PublicKey),
+			expAlg:  JWTAlgorithmRS256,
+			isValid: true,
+		},
+		{
+			name:    "the EC key is parsed",
+			key:     newTestJWK("ec", &ecKey.PublicKey),
+			expAlg:  JWTAlgorithmES256,
+			isValid: true,
+		},
+		{
+			name:    "the key of encryption is skipped",
+			key:     withField(newTestJWK("rsa", &rsaKey.PublicKey), "use", "enc"),
+			isValid: false,
+		},
+		{
+			name:    "the key, whose algorithm does not match its type, is skipped",
+			key:     withField(newTestJWK("rsa", &rsaKey.PublicKey), "alg", JWTAlgorithmHS256),
+			isValid: false,
+		},
+		{
+			name:    "the key of an unsupported curve is skipped",
+			key:     withField(newTestJWK("ec", &ecKey.PublicKey), "crv", "P-384"),
+			isValid: false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := parseJWKS(newTestJWKS(t, tc.key))
+			if err != nil {
+				t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+			}
+
+			if isValid := len(keys) == 1; isValid != tc.isValid {
+				t.Fatalf("expected valid: %t; got valid: %t\n", tc.isValid, isValid)
+			}
+
+			if tc.isValid && keys[0].algorithm != tc.expAlg {
+				t.Errorf("expected algorithm: %s; got algorithm: %s\n", tc.expAlg, keys[0].algorithm)
+			}
+		})
+	}
+}
+
+func TestJWKSSourceRefresh(t *testing.T) {
+	var (
+		fetches  int32
+		isBroken int32
+		jwks     = newTestJWKS(t, newTestJWK("key-1", []byte("secret")))
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+
+		if atomic.LoadInt32(&isBroken) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(jwks)
+	}))
+	defer srv.Close()
+
+	var (
+		src = newJWKSSource(&JWTConfig{JWKSURL: srv.URL, JWKSCacheTTL: "1m"})
+		now = time.Now()
+	)
+
+	type step struct {
+		name       string
+		kid        string
+		after      time.Duration
+		isBroken   bool
+		expFetches int32
+	}
+
+	steps := []step{
+		{name: "the keys are fetched first", kid: "key-1", after: 0, expFetches: 1},
+		{name: "the cached keys are used", kid: "key-1", after: time.Second, expFetches: 1},
+		{name: "the unknown key is not fetched too often", kid: "key-2", after: 2 * time.Second, expFetches: 1},
+		{name: "the unknown key is fetched", kid: "key-2", after: 11 * time.Second, expFetches: 2},
+		{name: "the expired keys are fetched", kid: "key-1", after: 72 * time.Second, isBroken: true, expFetches: 3},
+	}
+
+	for _, s := range steps {
+		var broken int32
+		if s.isBroken {
+			broken = 1
+		}
+
+		atomic.StoreInt32(&isBroken, broken)
+
+		keys := src.getKeys(s.kid, now.Add(s.after))
+
+		if f := atomic.LoadInt32(&fetches); f != s.expFetches {
+			t.Errorf("%s: expected fetches: %d; got fetches: %d\n", s.name, s.expFetches, f)
+		}
+
+		// The last good keys are kept, even if the fetch failed.
+		if !hasJWTKey(keys, "key-1") {
+			t.Errorf("%s: expected the key: %s\n", s.name, "key-1")
+		}
+	}
+}
@@ -0,0 +1,532 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/balazskvancz/gorouter"
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	// The supported signature algorithms of the tokens.
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmES256 = "ES256"
+
+	// The verified claims of the token are bound to the Context by this key.
+	JWTClaimsKey ContextKey = "jwtClaims"
+
+	wwwAuthenticateHeader = "WWW-Authenticate"
+
+	defaultJWTLeeway = 30 * time.Second
+)
+
+var jwtAlgorithms = []string{JWTAlgorithmHS256, JWTAlgorithmRS256, JWTAlgorithmES256}
+
+// JWTConfig describes the validation of the bearer tokens of the requests under
+// the given prefix. The requests without a valid token are rejected with HTTP 401,
+// and the ones without the required scopes with HTTP 403.
+type JWTConfig struct {
+	// The prefix of the urls, which are protected – eg. the prefix of a service.
+	// If it is empty, every request is protected.
+	Prefix string `json:"prefix"`
+
+	// The static keys, which the signatures are verified by. See the type def.
+	Keys []*JWTKeyConfig `json:"keys"`
+
+	// The JWKS document, which the signatures are verified by – either a file, which
+	// is reloaded once it changes, or a URL, whose keys are cached for JWKSCacheTTL
+	// – eg. "10m". By default the cache lasts 5 minutes.
+	JWKSFile     string `json:"jwksFile"`
+	JWKSURL      string `json:"jwksUrl"`
+	JWKSCacheTTL string `json:"jwksCacheTtl"`
+
+	// The accepted algorithms: HS256, RS256 or ES256. By default all of them.
+	Algorithms []string `json:"algorithms"`
+
+	// If it is given, the iss claim must be equal to it.
+	Issuer string `json:"issuer"`
+
+	// If it is given, the aud claim must contain one of them.
+	Audiences []string `json:"audiences"`
+
+	// The tolerated clock skew, when exp and nbf are checked – eg. "1m".
+	// By default it is 30 seconds.
+	Leeway string `json:"leeway"`
+
+	// The claims, which must be present in every token – eg. sub.
+	RequiredClaims []string `json:"requiredClaims"`
+
+	// The scopes, which must all be granted by the scope – or scp – claim.
+	Scopes []string `json:"scopes"`
+
+	// The claims, which are sent to the service in headers – eg. {"sub": "X-User-Id"}.
+	// These headers are always removed from the incoming requests.
+	ForwardClaims map[string]string `json:"forwardClaims"`
+}
+
+// JWTKeyConfig is a static key, which the signatures are verified by.
+type JWTKeyConfig struct {
+	// The key ID. If it is given, only the tokens with the same kid are verified by it.
+	ID string `json:"kid"`
+
+	// The algorithm of the key: HS256, RS256 or ES256.
+	Algorithm string `json:"alg"`
+
+	// The shared secret of HS256.
+	Secret string `json:"secret"`
+
+	// The path of the PEM encoded public key – or certificate – of RS256 and ES256.
+	PublicKeyFile string `json:"publicKeyFile"`
+}
+
+// jwtHeader is the JOSE header of a token.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtValidator is the parsed, ready to use form of JWTConfig.
+type jwtValidator struct {
+	prefix     string
+	keys       []*jwtKey
+	jwks       *jwksSource
+	algorithms []string
+
+	issuer         string
+	audiences      []string
+	leeway         time.Duration
+	requiredClaims []string
+	scopes         []string
+	forwardClaims  map[string]string
+}
+
+// NewJWTMiddleware creates a middleware, which validates the bearer tokens
+// by the given config. It can be registered to the Gateway by RegisterMiddleware.
+// It returns error, if the config is invalid.
+func NewJWTMiddleware(conf *JWTConfig) (Middleware, error) {
+	if err := validateJWT(conf); err != nil {
+		return nil, err
+	}
+
+	v := newJWTValidator(conf)
+
+	// The services trust the forwarded claims, so the tokens are
+	// verified, even if the middlewares are disabled.
+	return gorouter.NewMiddleware(
+		v.handle,
+		gorouter.MiddlewareWithMatchers(v.matches),
+		gorouter.MiddlewareWithAlwaysAllowed(true),
+	), nil
+}
+
+// newJWTValidator creates the validator from the given – already validated – config.
+func newJWTValidator(conf *JWTConfig) *jwtValidator {
+	v := &jwtValidator{
+		prefix:         strings.TrimSuffix(conf.Prefix, "/"),
+		jwks:           newJWKSSource(conf),
+		algorithms:     jwtAlgorithms,
+		issuer:         conf.Issuer,
+		audiences:      conf.Audiences,
+		leeway:         defaultJWTLeeway,
+		requiredClaims: conf.RequiredClaims,
+		scopes:         conf.Scopes,
+		forwardClaims:  conf.ForwardClaims,
+	}
+
+	for _, kc := range conf.Keys {
+		if key, err := loadJWTKey(kc); err == nil {
+			v.keys = append(v.keys, key)
+		}
+	}
+
+	if len(conf.Algorithms) > 0 {
+		v.algorithms = conf.Algorithms
+	}
+
+	if d, err := time.ParseDuration(conf.Leeway); err == nil {
+		v.leeway = d
+	}
+
+	return v
+}
+
+// validateJWT validates the given config, and reads its static keys.
+// It returns the first error that occured.
+func validateJWT(conf *JWTConfig) error {
+	if conf == nil {
+		return errJWTConfigIsNil
+	}
+
+	if conf.Prefix != "" && !strings.HasPrefix(conf.Prefix, "/") {
+		return errBadJWTPrefix
+	}
+
+	if len(conf.Keys) == 0 && conf.JWKSFile == "" && conf.JWKSURL == "" {
+		return errNoJWTKeys
+	}
+
+	if conf.JWKSFile != "" && conf.JWKSURL != "" {
+		return errBadJWKSSource
+	}
+
+	for _, alg := range conf.Algorithms {
+		if !includes(jwtAlgorithms, alg) {
+			return errBadJWTAlgorithm
+		}
+	}
+
+	for _, kc := range conf.Keys {
+		if kc == nil || !includes(jwtAlgorithms, kc.Algorithm) {
+			return errBadJWTAlgorithm
+		}
+
+		if _, err := loadJWTKey(kc); err != nil {
+			return err
+		}
+	}
+
+	if conf.JWKSFile != "" {
+		if _, err := readJWKSFile(conf.JWKSFile); err != nil {
+			return err
+		}
+	}
+
+	if conf.JWKSURL != "" {
+		if u, err := url.Parse(conf.JWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errBadJWKSSource
+		}
+	}
+
+	for _, d := range []string{conf.JWKSCacheTTL, conf.Leeway} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v < 0 {
+			return errBadJWTDuration
+		}
+	}
+
+	for _, header := range conf.ForwardClaims {
+		if !isToken(header) {
+			return errBadJWTForwardHeader
+		}
+	}
+
+	return nil
+}
+
+// matches tells if the request is under the prefix of the validator. The
+// system routes are skipped, since they are authenticated by their signatures.
+func (v *jwtValidator) matches(ctx Context) bool {
+	return !isSystemRequest(ctx) && isRequestUnderPrefix(ctx, v.prefix)
+}
+
+// handle is the middleware function of the validator. The verified claims
+// are bound to the Context, and the forwarded ones are set in the headers.
+func (v *jwtValidator) handle(ctx Context, next HandlerFunc) {
+	header := ctx.GetRequestHeaders()
+
+	// Only the claims verified here can be trusted by the services.
+	for _, name := range v.forwardClaims {
+		header.Del(name)
+	}
+
+	claims, err := v.verify(header.Get(authorizationHeader), time.Now())
+	if err != nil {
+		v.sendError(ctx, err)
+
+		return
+	}
+
+	for claim, name := range v.forwardClaims {
+		if value, ok := formatJWTClaim(claims[claim]); ok {
+			header.Set(name, value)
+		}
+	}
+
+	ctx.BindValue(JWTClaimsKey, claims)
+
+	next(ctx)
+}
+
+// sendError sends HTTP 401 – or 403, if the scopes are not granted – with
+// the WWW-Authenticate challenge of the error. See RFC 6750.
+func (v *jwtValidator) sendError(ctx Context, err error) {
+	var (
+		status    = http.StatusUnauthorized
+		challenge = "Bearer"
+	)
+
+	if errors.Is(err, errJWTInsufficientScope) {
+		status = http.StatusForbidden
+		challenge += `, error="insufficient_scope", scope="` + strings.Join(v.scopes, " ") + `"`
+	} else if !errors.Is(err, errJWTMissing) {
+		challenge += `, error="invalid_token", error_description="` + strings.TrimPrefix(err.Error(), "[jwt]: ") + `"`
+	}
+
+	header := http.Header{}
+	header.Set(wwwAuthenticateHeader, challenge)
+
+	ctx.SendRaw([]byte(http.StatusText(status)), status, header)
+}
+
+// verify verifies the signature of the bearer token of the given Authorization
+// header, then validates its claims. It returns the claims of the valid token.
+func (v *jwtValidator) verify(authorization string, now time.Time) (map[string]any, error) {
+	token, ok := getBearerToken(authorization)
+	if !ok {
+		return nil, errJWTMissing
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errJWTMalformed
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errJWTMalformed
+	}
+
+	if !includes(v.algorithms, header.Alg) {
+		return nil, errJWTAlgorithm
+	}
+
+	signature, err := decodeJWTSegment(parts[2])
+	if err != nil {
+		return nil, errJWTMalformed
+	}
+
+	if !v.verifySignature(header, parts[0]+"."+parts[1], signature, now) {
+		return nil, errJWTSignature
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, errJWTMalformed
+	}
+
+	if err := v.validateClaims(claims, now); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// verifySignature tells if the signature was made by any of the keys. The
+// static keys are tried first, so they do not trigger the refresh of the JWKS.
+func (v *jwtValidator) verifySignature(header jwtHeader, signed string, signature []byte, now time.Time) bool {
+	return verifyWithKeys(v.keys, header, signed, signature) ||
+		verifyWithKeys(v.jwks.getKeys(header.Kid, now), header, signed, signature)
+}
+
+// verifyWithKeys tells if the signature was made by any of the given keys,
+// whose algorithm and ID match the header of the token.
+func verifyWithKeys(keys []*jwtKey, header jwtHeader, signed string, signature []byte) bool {
+	for _, key := range keys {
+		if key.algorithm != header.Alg || (header.Kid != "" && key.id != "" && key.id != header.Kid) {
+			continue
+		}
+
+		if key.verify(signed, signature) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verify tells if the signature of the given content was made by the key.
+func (k *jwtKey) verify(signed string, signature []byte) bool {
+	switch key := k.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+
+		return hmac.Equal(signature, mac.Sum(nil))
+	case *rsa.PublicKey:
+		sum := sha256.Sum256([]byte(signed))
+
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature) == nil
+	case *ecdsa.PublicKey:
+		// The signature is the concatenation of r and s. See RFC 7518.
+		if len(signature) != 64 {
+			return false
+		}
+
+		var (
+			sum = sha256.Sum256([]byte(signed))
+			r   = new(big.Int).SetBytes(signature[:32])
+			s   = new(big.Int).SetBytes(signature[32:])
+		)
+
+		return ecdsa.Verify(key, sum[:], r, s)
+	}
+
+	return false
+}
+
+// validateClaims validates the time, the issuer and the audience of the token,
+// then checks the required claims and scopes.
+func (v *jwtValidator) validateClaims(claims map[string]any, now time.Time) error {
+	if exp, ok, err := getNumericDate(claims, "exp"); err != nil {
+		return err
+	} else if ok && !now.Before(exp.Add(v.leeway)) {
+		return errJWTExpired
+	}
+
+	if nbf, ok, err := getNumericDate(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Add(v.leeway).Before(nbf) {
+		return errJWTNotValidYet
+	}
+
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return errJWTIssuer
+	}
+
+	if len(v.audiences) > 0 && !v.hasAudience(claims["aud"]) {
+		return errJWTAudience
+	}
+
+	for _, name := range v.requiredClaims {
+		if claims[name] == nil {
+			return fmt.Errorf("%w: %s", errJWTMissingClaim, name)
+		}
+	}
+
+	granted := getJWTScopes(claims)
+
+	for _, scope := range v.scopes {
+		if !includes(granted, scope) {
+			return errJWTInsufficientScope
+		}
+	}
+
+	return nil
+}
+
+// hasAudience tells if the given aud claim – a string or an
+// array of strings – contains any of the accepted audiences.
+func (v *jwtValidator) hasAudience(aud any) bool {
+	switch value := aud.(type) {
+	case string:
+		return includes(v.audiences, value)
+	case []any:
+		for _, a := range value {
+			if s, ok := a.(string); ok && includes(v.audiences, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getNumericDate returns the time of the given claim – in seconds since the
+// epoch. It returns false, if the claim is not present, and error if it is
+// not a number.
+func getNumericDate(claims map[string]any, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok || value == nil {
+		return time.Time{}, false, nil
+	}
+
+	n, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, errJWTMalformed
+	}
+
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, errJWTMalformed
+	}
+
+	sec, frac := math.Modf(f)
+
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), true, nil
+}
+
+// getJWTScopes returns the granted scopes of the token: either the space separated
+// scope claim, or the scp claim, which is either a string or an array of strings.
+func getJWTScopes(claims map[string]any) []string {
+	var scopes []string
+
+	for _, name := range []string{"scope", "scp"} {
+		switch value := claims[name].(type) {
+		case string:
+			scopes = append(scopes, strings.Fields(value)...)
+		case []any:
+			for _, s := range value {
+				if scope, ok := s.(string); ok {
+					scopes = append(scopes, scope)
+				}
+			}
+		}
+	}
+
+	return scopes
+}
+
+// formatJWTClaim returns the value of the claim in the form of a header. The
+// strings are sent as they are, while the others are JSON encoded. It returns
+// false, if the claim is not present, or it is not a valid header value.
+func formatJWTClaim(value any) (string, bool) {
+	if value == nil {
+		return "", false
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return "", false
+		}
+		s = string(b)
+	}
+
+	return s, httpguts.ValidHeaderFieldValue(s)
+}
+
+// getBearerToken returns the token of the given Authorization header.
+func getBearerToken(authorization string) (string, bool) {
+	if len(authorization) <= len(bearerPrefix) || !strings.EqualFold(authorization[:len(bearerPrefix)], bearerPrefix) {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[len(bearerPrefix):]), true
+}
+
+// decodeJWTSegment decodes the given base64url encoded segment. The padding
+// is not allowed by the spec, but it is tolerated.
+func decodeJWTSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
+
+// decodeJWTPart decodes the given base64url encoded JSON into v.
+// The numbers are decoded as json.Number.
+func decodeJWTPart(segment string, v any) error {
+	b, err := decodeJWTSegment(segment)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	return dec.Decode(v)
+}
@@ -0,0 +1,467 @@
+package gateway
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signTestJWT returns a token with the given claims, which is signed by the key.
+func signTestJWT(t *testing.T, alg string, kid string, key any, claims map[string]any) string {
+	enc := base64.RawURLEncoding.EncodeToString
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	var (
+		signed = enc(header) + "." + enc(payload)
+		sum    = sha256.Sum256([]byte(signed))
+		sig    []byte
+	)
+
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	case *ecdsa.PrivateKey:
+		r, s, signErr := ecdsa.Sign(rand.Reader, k, sum[:])
+		sig, err = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), signErr
+	}
+
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	return signed + "." + enc(sig)
+}
+
+// writeTestPublicKey writes the PEM encoded public key into the dir, and returns its path.
+func writeTestPublicKey(t *testing.T, dir string, name string, key any) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	return writeTestFile(t, dir, name, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestValidateJWT(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *JWTConfig
+		err  error
+	}
+
+	var (
+		dir     = t.TempDir()
+		rsaFile = writeTestPublicKey(t, dir, "rsa.pem", &newTestRSAKey(t).PublicKey)
+		secret  = &JWTKeyConfig{Algorithm: JWTAlgorithmHS256, Secret: "secret"}
+	)
+
+	tt := []testCase{
+		{
+			name: "the function returns error if the config is nil",
+			conf: nil,
+			err:  errJWTConfigIsNil,
+		},
+		{
+			name: "the function returns error if the prefix is invalid",
+			conf: &JWTConfig{Prefix: "api", Keys: []*JWTKeyConfig{secret}},
+			err:  errBadJWTPrefix,
+		},
+		{
+			name: "the function returns error if there are no keys",
+			conf: &JWTConfig{Prefix: "/api"},
+			err:  errNoJWTKeys,
+		},
+		{
+			name: "the function returns error if both JWKS sources are given",
+			conf: &JWTConfig{JWKSFile: "jwks.json", JWKSURL: "http://localhost/jwks.json"},
+			err:  errBadJWKSSource,
+		},
+		{
+			name: "the function returns error if the JWKS URL is invalid",
+			conf: &JWTConfig{JWKSURL: "file:///jwks.json"},
+			err:  errBadJWKSSource,
+		},
+		{
+			name: "the function returns error if the JWKS file does not exist",
+			conf: &JWTConfig{JWKSFile: filepath.Join(dir, "missing.json")},
+			err:  errBadJWKS,
+		},
+		{
+			name: "the function returns error if the algorithm is unsupported",
+			conf: &JWTConfig{Keys: []*JWTKeyConfig{{Algorithm: "none"}}},
+			err:  errBadJWTAlgorithm,
+		},
+		{
+			name: "the function returns error if the key does not match its algorithm",
+			conf: &JWTConfig{Keys: []*JWTKeyConfig{{Algorithm: JWTAlgorithmES256, PublicKeyFile: rsaFile}}},
+			err:  errBadJWTKey,
+		},
+		{
+			name: "the function returns error if the leeway is invalid",
+			conf: &JWTConfig{Keys: []*JWTKeyConfig{secret}, Leeway: "soon"},
+			err:  errBadJWTDuration,
+		},
+		{
+			name: "the function returns error if the forwarded header is invalid",
+			conf: &JWTConfig{Keys: []*JWTKeyConfig{secret}, ForwardClaims: map[string]string{"sub": "X User"}},
+			err:  errBadJWTForwardHeader,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &JWTConfig{
+				Prefix:        "/api",
+				Keys:          []*JWTKeyConfig{secret, {Algorithm: JWTAlgorithmRS256, PublicKeyFile: rsaFile}},
+				JWKSURL:       "http://localhost:8080/.well-known/jwks.json",
+				Leeway:        "1m",
+				ForwardClaims: map[string]string{"sub": "X-User-Id"},
+			},
+			err: nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateJWT(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestJWTVerify(t *testing.T) {
+	type testCase struct {
+		name   string
+		token  string
+		claims map[string]any
+		err    error
+	}
+
+	var (
+		dir    = t.TempDir()
+		now    = time.Now()
+		secret = []byte("secret")
+		rsaKey = newTestRSAKey(t)
+		ecKey  = newTestECKey(t)
+	)
+
+	v := newJWTValidator(&JWTConfig{
+		Keys: []*JWTKeyConfig{
+			{Algorithm: JWTAlgorithmHS256, Secret: string(secret)},
+			{ID: "rsa-1", Algorithm: JWTAlgorithmRS256, PublicKeyFile: writeTestPublicKey(t, dir, "rsa.pem", &rsaKey.PublicKey)},
+		},
+		JWKSFile:       writeTestFile(t, dir, "jwks.json", newTestJWKS(t, newTestJWK("ec-1", &ecKey.PublicKey))),
+		Issuer:         "https://auth.example.com",
+		Audiences:      []string{"orders"},
+		Leeway:         "10s",
+		RequiredClaims: []string{"sub"},
+		Scopes:         []string{"orders:read"},
+	})
+
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"sub":   "user-1",
+			"iss":   "https://auth.example.com",
+			"aud":   []string{"billing", "orders"},
+			"exp":   now.Add(time.Minute).Unix(),
+			"scope": "orders:read orders:write",
+		}
+
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+
+		return c
+	}
+
+	tt := []testCase{
+		{
+			name:  "the token signed by the secret is valid",
+			token: signTestJWT(t, JWTAlgorithmHS256, "", secret, claims(nil)),
+			err:   nil,
+		},
+		{
+			name:  "the token signed by the RSA key is valid",
+			token: signTestJWT(t, JWTAlgorithmRS256, "rsa-1", rsaKey, claims(nil)),
+			err:   nil,
+		},
+		{
+			name:  "the token signed by the key of the JWKS is valid",
+			token: signTestJWT(t, JWTAlgorithmES256, "ec-1", ecKey, claims(nil)),
+			err:   nil,
+		},
+		{
+			name:  "the token is missing",
+			token: "",
+			err:   errJWTMissing,
+		},
+		{
+			name:  "the token is malformed",
+			token: "not-a-token",
+			err:   errJWTMalformed,
+		},
+		{
+			name:  "the unsigned token is rejected",
+			token: strings.TrimSuffix(signTestJWT(t, "none", "", nil, claims(nil)), "."),
+			err:   errJWTMalformed,
+		},
+		{
+			name:  "the token of an unknown algorithm is rejected",
+			token: signTestJWT(t, "none", "", nil, claims(nil)),
+			err:   errJWTAlgorithm,
+		},
+		{
+			name:  "the token signed by an other key is rejected",
+			token: signTestJWT(t, JWTAlgorithmHS256, "", []byte("other"), claims(nil)),
+			err:   errJWTSignature,
+		},
+		{
+			name:  "the token of an other key ID is rejected",
+			token: signTestJWT(t, JWTAlgorithmRS256, "rsa-2", rsaKey, claims(nil)),
+			err:   errJWTSignature,
+		},
+		{
+			name:  "the expired token is rejected",
+			token: signTestJWT(t, JWTAlgorithmHS256, "", secret, claims(map[string]any{"exp": now.Add(-time.Minute).Unix()})),
+			err:   errJWTExpired,
+		},
+		{
+			name:  "the just expired token is tolerated by the leeway",
+			token: signTestJWT(t, JWTAlgorithmHS256, "", secret, claims(map[string]any{"exp": now.Add(-5 * time.Second).Unix()})),
+			err:   nil,
+		},
+		{
+			name:  "the token is not valid yet",
+			token: signTestJWT(t, JWTAlgorithmHS256, "", secret, claims(map[string]any{"nbf": now.Add(time.Minute).Unix()})),
+			err:   errJWTNotValidYet,
+		},
+		{
+			name:  "the token of an other issuer is rejected",
+			token: signTestJWT(t, JWTAlgorithmHS256, "", secret, claims(map[string]any{"iss": "https://evil.example.com"})),
+			err:   errJWTIssuer,
+		},
+		{
+			name:  "the token of an other audience is rejected",
+			token: signTestJWT(t, JWTAlgorithmHS256, "", secret, claims(map[string]any{"aud": "billing"})),
+			err:   errJWTAudience,
+		},
+		{
+			name:  "the token without a required claim is rejected",
+			token: signTestJWT(t, JWTAlgorithmHS256, "", secret, claims(map[string]any{"sub": nil})),
+			err:   errJWTMissingClaim,
+		},
+		{
+			name:  "the token without the required scope is rejected",
+			token: signTestJWT(t, JWTAlgorithmHS256, "", secret, claims(map[string]any{"scope": "orders:write"})),
+			err:   errJWTInsufficientScope,
+		},
+		{
+			name:  "the scopes are read from the scp claim too",
+			token: signTestJWT(t, JWTAlgorithmHS256, "", secret, claims(map[string]any{"scope": nil, "scp": []string{"orders:read"}})),
+			err:   nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			authorization := ""
+			if tc.token != "" {
+				authorization = "Bearer " + tc.token
+			}
+
+			if _, err := v.verify(authorization, now); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	type testCase struct {
+		name           string
+		token          string
+		expStatus      int
+		expChallenge   string
+		expUserID      string
+		expCalledTimes int
+	}
+
+	secret := []byte("secret")
+
+	mw, err := NewJWTMiddleware(&JWTConfig{
+		Prefix:        "/api/foo",
+		Keys:          []*JWTKeyConfig{{Algorithm: JWTAlgorithmHS256, Secret: string(secret)}},
+		Scopes:        []string{"foo"},
+		ForwardClaims: map[string]string{"sub": "X-User-Id"},
+	})
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	tt := []testCase{
+		{
+			name:           "the request without token is unauthorized",
+			token:          "",
+			expStatus:      http.StatusUnauthorized,
+			expChallenge:   "Bearer",
+			expCalledTimes: 0,
+		},
+		{
+			name:           "the request with an invalid token is unauthorized",
+			token:          signTestJWT(t, JWTAlgorithmHS256, "", []byte("other"), map[string]any{"sub": "user-1"}),
+			expStatus:      http.StatusUnauthorized,
+			expChallenge:   `Bearer, error="invalid_token", error_description="the signature of the token is invalid"`,
+			expCalledTimes: 0,
+		},
+		{
+			name:           "the request without the scope is forbidden",
+			token:          signTestJWT(t, JWTAlgorithmHS256, "", secret, map[string]any{"sub": "user-1"}),
+			expStatus:      http.StatusForbidden,
+			expChallenge:   `Bearer, error="insufficient_scope", scope="foo"`,
+			expCalledTimes: 0,
+		},
+		{
+			name:           "the valid request is forwarded with the claims",
+			token:          signTestJWT(t, JWTAlgorithmHS256, "", secret, map[string]any{"sub": "user-1", "scope": "foo"}),
+			expStatus:      http.StatusOK,
+			expUserID:      "user-1",
+			expCalledTimes: 1,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				calledTimes int
+				userID      string
+			)
+
+			r := httptest.NewRequest(http.MethodGet, "/api/foo/bar", nil)
+			r.Header.Set("X-User-Id", "forged")
+			if tc.token != "" {
+				r.Header.Set(authorizationHeader, "Bearer "+tc.token)
+			}
+
+			ctx, rec := newTestContext(r)
+
+			if !mw.DoesMatch(ctx) {
+				t.Fatalf("expected the middleware to match\n")
+			}
+
+			mw.Execute(ctx, func(ctx Context) {
+				calledTimes++
+				userID = ctx.GetRequestHeader("X-User-Id")
+
+				if _, ok := ctx.GetBindedValue(JWTClaimsKey).(map[string]any); !ok {
+					t.Errorf("expected the claims to be bound\n")
+				}
+
+				ctx.SendOk()
+			})
+			ctx.WriteToResponseNow()
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("expected status code: %d; got status code: %d\n", tc.expStatus, rec.Code)
+			}
+
+			if challenge := rec.Header().Get(wwwAuthenticateHeader); challenge != tc.expChallenge {
+				t.Errorf("expected challenge: %s; got challenge: %s\n", tc.expChallenge, challenge)
+			}
+
+			if calledTimes != tc.expCalledTimes {
+				t.Errorf("expected called times: %d; got called times: %d\n", tc.expCalledTimes, calledTimes)
+			}
+
+			if userID != tc.expUserID {
+				t.Errorf("expected user id: %s; got user id: %s\n", tc.expUserID, userID)
+			}
+		})
+	}
+}
+
+func TestJWTMiddlewareMatch(t *testing.T) {
+	type testCase struct {
+		name     string
+		prefix   string
+		url      string
+		expMatch bool
+	}
+
+	tt := []testCase{
+		{name: "the url under the prefix matches", prefix: "/api/test", url: "/api/test/admin", expMatch: true},
+		{name: "the url routed to the prefix by characters matches", prefix: "/api/test", url: "/api/testing", expMatch: true},
+		{name: "the url with the encoded slash matches", prefix: "/api/test", url: "/api/test%2Fadmin", expMatch: true},
+		{name: "the url with encoded characters matches", prefix: "/api/test", url: "/api/t%65st/admin", expMatch: true},
+		{name: "the url of an other prefix does not match", prefix: "/api/test", url: "/api/other", expMatch: false},
+		{name: "the empty prefix matches every url", prefix: "", url: "/api/other", expMatch: true},
+		{name: "the system routes are skipped by the empty prefix", prefix: "", url: routeSystemInfo, expMatch: false},
+		{name: "the system routes are skipped by their prefix", prefix: "/api", url: routeRegisterService, expMatch: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mw, err := NewJWTMiddleware(&JWTConfig{
+				Prefix: tc.prefix,
+				Keys:   []*JWTKeyConfig{{Algorithm: JWTAlgorithmHS256, Secret: "secret"}},
+			})
+			if err != nil {
+				t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+			}
+
+			ctx, _ := newTestContext(httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+			if isMatch := mw.DoesMatch(ctx); isMatch != tc.expMatch {
+				t.Errorf("expected match: %t; got match: %t\n", tc.expMatch, isMatch)
+			}
+		})
+	}
+}
+
+func TestJWTSystemRoutes(t *testing.T) {
+	// The tokens of every request are validated, except the signed system calls.
+	gw := New(
+		WithSecretKey("secret"),
+		WithJWT(&JWTConfig{Keys: []*JWTKeyConfig{{Algorithm: JWTAlgorithmHS256, Secret: "secret"}}}),
+	)
+
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, newTestSignedRequest(t, routeSystemInfo, "{}", "secret"))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status code: %d; got status code: %d\n", http.StatusOK, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/foo", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code: %d; got status code: %d\n", http.StatusUnauthorized, rec.Code)
+	}
+}
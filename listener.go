@@ -0,0 +1,333 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// The in-flight requests are waited for this long on shutdown,
+	// then the remaining connections – eg. streams – are closed.
+	shutdownTimeout = 10 * time.Second
+)
+
+// ListenerTLSConfig is the TLS settings of the listener of the Gateway.
+// If it is given, the Gateway serves HTTPS at its address.
+type ListenerTLSConfig struct {
+	// The certificates of the Gateway. The one whose names match the server name
+	// of the client (SNI) is presented, otherwise the first one. The files are
+	// reloaded, once they are changed.
+	Certificates []*CertificateConfig `json:"certificates"`
+
+	// The minimum TLS version – one of 1.0, 1.1, 1.2 or 1.3. By default it is 1.2.
+	MinVersion string `json:"minVersion"`
+
+	// The names of the allowed cipher suites of TLS 1.2 and below – eg.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. By default the secure ones of Go
+	// are used. The suites of TLS 1.3 can not be configured.
+	CipherSuites []string `json:"cipherSuites"`
+
+	// HTTP/2 is offered to the clients by ALPN. By default only HTTP/1.1 is served.
+	HTTP2 bool `json:"http2"`
+
+	// If it is given, the plain HTTP requests at this address are redirected to HTTPS.
+	RedirectAddress int `json:"redirectAddress"`
+
+	// The verification of the client certificates. See the type def.
+	ClientAuth *ClientAuthConfig `json:"clientAuth"`
+}
+
+// CertificateConfig is the paths of a PEM encoded certificate and its key.
+type CertificateConfig struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+}
+
+// listenerTLS is the parsed, ready to use form of ListenerTLSConfig.
+type listenerTLS struct {
+	minVersion      uint16
+	cipherSuites    []uint16
+	http2           bool
+	redirectAddress int
+	clientAuth      *clientAuth
+
+	mu           sync.Mutex
+	certificates []*listenerCertificate
+}
+
+// listenerCertificate is a certificate, which is reloaded, once its files are changed.
+type listenerCertificate struct {
+	certFile *watchedFile
+	keyFile  *watchedFile
+	cert     *tls.Certificate
+}
+
+// newListenerTLS creates the TLS settings of the listener from the given – already
+// validated – config. It returns nil, if there is no config, so plain HTTP is served.
+func newListenerTLS(conf *ListenerTLSConfig) *listenerTLS {
+	if conf == nil {
+		return nil
+	}
+
+	lt := &listenerTLS{
+		minVersion:      defaultTLSMinVersion,
+		cipherSuites:    getCipherSuites(conf.CipherSuites),
+		http2:           conf.HTTP2,
+		redirectAddress: conf.RedirectAddress,
+		clientAuth:      newClientAuth(conf.ClientAuth),
+		certificates:    make([]*listenerCertificate, len(conf.Certificates)),
+	}
+
+	if v, ok := tlsVersions[conf.MinVersion]; ok {
+		lt.minVersion = v
+	}
+
+	for i, cc := range conf.Certificates {
+		lt.certificates[i] = &listenerCertificate{
+			certFile: &watchedFile{path: cc.CertFile},
+			keyFile:  &watchedFile{path: cc.KeyFile},
+		}
+	}
+
+	return lt
+}
+
+// validateListenerTLS validates the given config, and reads its certificates.
+// It returns the first error that occured.
+func validateListenerTLS(conf *ListenerTLSConfig) error {
+	if conf == nil {
+		return nil
+	}
+
+	if len(conf.Certificates) == 0 {
+		return errNoTLSCertificates
+	}
+
+	if _, ok := tlsVersions[conf.MinVersion]; conf.MinVersion != "" && !ok {
+		return errBadTLSVersion
+	}
+
+	for _, name := range conf.CipherSuites {
+		if _, ok := getCipherSuiteIDs()[name]; !ok {
+			return errBadTLSCipherSuite
+		}
+	}
+
+	for _, cc := range conf.Certificates {
+		if cc == nil || cc.CertFile == "" || cc.KeyFile == "" {
+			return errBadTLSKeyPair
+		}
+
+		if _, err := loadCertificate(cc.CertFile, cc.KeyFile); err != nil {
+			return err
+		}
+	}
+
+	if conf.RedirectAddress < 0 {
+		return errBadTLSRedirectAddress
+	}
+
+	return validateClientAuth(conf.ClientAuth)
+}
+
+// getCipherSuiteIDs returns the IDs of the secure cipher suites of Go by their names.
+func getCipherSuiteIDs() map[string]uint16 {
+	suites := tls.CipherSuites()
+	ids := make(map[string]uint16, len(suites))
+
+	for _, cs := range suites {
+		ids[cs.Name] = cs.ID
+	}
+
+	return ids
+}
+
+// getCipherSuites returns the IDs of the given cipher suites, or nil if there
+// is none, so the defaults of Go are used.
+func getCipherSuites(names []string) []uint16 {
+	if len(names) == 0 {
+		return nil
+	}
+
+	var (
+		ids    = getCipherSuiteIDs()
+		suites = make([]uint16, 0, len(names))
+	)
+
+	for _, name := range names {
+		if id, ok := ids[name]; ok {
+			suites = append(suites, id)
+		}
+	}
+
+	return suites
+}
+
+// loadCertificate reads the certificate with its key, and parses its leaf,
+// so its names can be matched against the server name of the clients.
+func loadCertificate(certFile string, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errBadTLSFile
+	}
+
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, errBadTLSFile
+	}
+
+	return &cert, nil
+}
+
+// getConfig returns the TLS config of the server.
+func (lt *listenerTLS) getConfig() *tls.Config {
+	nextProtos := []string{"http/1.1"}
+	if lt.http2 {
+		nextProtos = []string{"h2", "http/1.1"}
+	}
+
+	conf := &tls.Config{
+		MinVersion:     lt.minVersion,
+		CipherSuites:   lt.cipherSuites,
+		NextProtos:     nextProtos,
+		GetCertificate: lt.getCertificate,
+	}
+
+	if lt.clientAuth != nil {
+		lt.clientAuth.configure(conf)
+	}
+
+	return conf
+}
+
+// getCertificate returns the certificate, whose names match the server name
+// of the client. If there is no match, the first certificate is returned.
+// The changed files are read again, if they can not be, the last good
+// certificate is kept.
+func (lt *listenerTLS) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	var first *tls.Certificate
+
+	for _, lc := range lt.certificates {
+		cert := lc.reload()
+		if cert == nil {
+			continue
+		}
+
+		if first == nil {
+			first = cert
+		}
+
+		if hello.ServerName != "" && cert.Leaf.VerifyHostname(hello.ServerName) == nil {
+			return cert, nil
+		}
+	}
+
+	if first == nil {
+		return nil, errNoTLSCertificates
+	}
+
+	return first, nil
+}
+
+// reload reads the certificate again, if its files are changed.
+func (lc *listenerCertificate) reload() *tls.Certificate {
+	// Both files must be checked, so their times are remembered.
+	certChanged := lc.certFile.isChanged()
+	keyChanged := lc.keyFile.isChanged()
+
+	if certChanged || keyChanged || lc.cert == nil {
+		if cert, err := loadCertificate(lc.certFile.path, lc.keyFile.path); err == nil {
+			lc.cert = cert
+		}
+	}
+
+	return lc.cert
+}
+
+// redirectToHTTPS redirects the plain HTTP request to the same URL by HTTPS.
+func (gw *Gateway) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if gw.info.address != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(gw.info.address))
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
+
+// listen serves the incoming HTTP requests – or HTTPS, if TLS is configured –,
+// until the ctx is done. Then it waits for the in-flight requests, before it returns.
+func (gw *Gateway) listen(ctx context.Context) {
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", gw.info.address),
+		Handler: gw,
+	}
+
+	// The hijacked connections – eg. WebSockets – are not
+	// tracked by the server, so they are closed here.
+	server.RegisterOnShutdown(func() {
+		for _, s := range gw.serviceRegisty.getAllServices() {
+			s.tunnels.closeAll()
+		}
+	})
+
+	serve := server.ListenAndServe
+	servers := []*http.Server{server}
+
+	if gw.listenerTLS != nil {
+		server.TLSConfig = gw.listenerTLS.getConfig()
+
+		// By default the server offers HTTP/2 on its own, the empty map turns it off.
+		if !gw.listenerTLS.http2 {
+			server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+
+		serve = func() error {
+			return server.ListenAndServeTLS("", "")
+		}
+
+		if addr := gw.listenerTLS.redirectAddress; addr > 0 {
+			redirect := &http.Server{
+				Addr:    fmt.Sprintf(":%d", addr),
+				Handler: http.HandlerFunc(gw.redirectToHTTPS),
+			}
+
+			servers = append(servers, redirect)
+
+			go gw.runServer(redirect.ListenAndServe)
+		}
+	}
+
+	go gw.runServer(serve)
+
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			s.Close()
+		}
+	}
+}
+
+// runServer runs the given serve function of a server, and logs its error.
+func (gw *Gateway) runServer(fn func() error) {
+	if err := fn(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		gw.logger.Error(err.Error())
+	}
+}
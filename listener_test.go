@@ -0,0 +1,239 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate issues a server certificate for the given name, and writes it
+// with its key into the dir. It returns the config of the written certificate.
+func writeTestCertificate(t *testing.T, ca *testCA, dir string, name string) *CertificateConfig {
+	certPEM, keyPEM := ca.issue(t, name, x509.ExtKeyUsageServerAuth)
+
+	return &CertificateConfig{
+		CertFile: writeTestFile(t, dir, name+".pem", certPEM),
+		KeyFile:  writeTestFile(t, dir, name+"-key.pem", keyPEM),
+	}
+}
+
+func TestValidateListenerTLS(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *ListenerTLSConfig
+		err  error
+	}
+
+	var (
+		dir  = t.TempDir()
+		cert = writeTestCertificate(t, newTestCA(t), dir, "example.com")
+	)
+
+	tt := []testCase{
+		{
+			name: "the function returns nil if there is no config",
+			conf: nil,
+			err:  nil,
+		},
+		{
+			name: "the function returns error if there is no certificate",
+			conf: &ListenerTLSConfig{},
+			err:  errNoTLSCertificates,
+		},
+		{
+			name: "the function returns error if the key is missing",
+			conf: &ListenerTLSConfig{Certificates: []*CertificateConfig{{CertFile: cert.CertFile}}},
+			err:  errBadTLSKeyPair,
+		},
+		{
+			name: "the function returns error if the certificate does not exist",
+			conf: &ListenerTLSConfig{Certificates: []*CertificateConfig{{
+				CertFile: filepath.Join(dir, "missing.pem"),
+				KeyFile:  cert.KeyFile,
+			}}},
+			err: errBadTLSFile,
+		},
+		{
+			name: "the function returns error if the cipher suite is insecure",
+			conf: &ListenerTLSConfig{
+				Certificates: []*CertificateConfig{cert},
+				CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+			},
+			err: errBadTLSCipherSuite,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &ListenerTLSConfig{
+				Certificates: []*CertificateConfig{cert},
+				MinVersion:   "1.2",
+				CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+			},
+			err: nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateListenerTLS(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestListenerCertificateBySNI(t *testing.T) {
+	type testCase struct {
+		name       string
+		serverName string
+		expName    string
+	}
+
+	var (
+		dir = t.TempDir()
+		ca  = newTestCA(t)
+	)
+
+	lt := newListenerTLS(&ListenerTLSConfig{
+		Certificates: []*CertificateConfig{
+			writeTestCertificate(t, ca, dir, "api.example.com"),
+			writeTestCertificate(t, ca, dir, "*.internal.example.com"),
+		},
+	})
+
+	tt := []testCase{
+		{
+			name:       "the certificate is selected by the exact name",
+			serverName: "api.example.com",
+			expName:    "api.example.com",
+		},
+		{
+			name:       "the certificate is selected by the wildcard name",
+			serverName: "orders.internal.example.com",
+			expName:    "*.internal.example.com",
+		},
+		{
+			name:       "the first certificate is the default",
+			serverName: "other.com",
+			expName:    "api.example.com",
+		},
+		{
+			name:       "the first certificate is used without SNI",
+			serverName: "",
+			expName:    "api.example.com",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cert, err := lt.getCertificate(&tls.ClientHelloInfo{ServerName: tc.serverName})
+			if err != nil {
+				t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+			}
+
+			if name := cert.Leaf.Subject.CommonName; name != tc.expName {
+				t.Errorf("expected name: %s; got name: %s\n", tc.expName, name)
+			}
+		})
+	}
+}
+
+func TestListenerCertificateReload(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		ca  = newTestCA(t)
+	)
+
+	conf := writeTestCertificate(t, ca, dir, "example.com")
+
+	lt := newListenerTLS(&ListenerTLSConfig{Certificates: []*CertificateConfig{conf}})
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = lt.getConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	getServedSerial := func() string {
+		cl := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, ServerName: "example.com"},
+			DisableKeepAlives: true,
+		}}
+
+		res, err := cl.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+		}
+		defer res.Body.Close()
+
+		return res.TLS.PeerCertificates[0].SerialNumber.String()
+	}
+
+	first := getServedSerial()
+
+	// The renewed certificate is served on the next handshake.
+	certPEM, keyPEM := ca.issue(t, "example.com", x509.ExtKeyUsageServerAuth)
+	writeTestFile(t, dir, "example.com.pem", certPEM)
+	writeTestFile(t, dir, "example.com-key.pem", keyPEM)
+
+	future := time.Now().Add(time.Minute)
+	for _, path := range []string{conf.CertFile, conf.KeyFile} {
+		if err := os.Chtimes(path, future, future); err != nil {
+			t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+		}
+	}
+
+	if second := getServedSerial(); second == first {
+		t.Errorf("expected the renewed certificate; got serial: %s\n", second)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	type testCase struct {
+		name     string
+		address  int
+		url      string
+		expected string
+	}
+
+	tt := []testCase{
+		{
+			name:     "the request is redirected to the default port",
+			address:  443,
+			url:      "http://example.com:8080/api/mock?foo=bar",
+			expected: "https://example.com/api/mock?foo=bar",
+		},
+		{
+			name:     "the request is redirected to the port of the gateway",
+			address:  8443,
+			url:      "http://example.com/api/mock",
+			expected: "https://example.com:8443/api/mock",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			gw := &Gateway{info: &GatewayInfo{address: tc.address}}
+
+			rec := httptest.NewRecorder()
+			gw.redirectToHTTPS(rec, httptest.NewRequest(http.MethodPost, tc.url, nil))
+
+			if rec.Code != http.StatusPermanentRedirect {
+				t.Errorf("expected status code: %d; got status code: %d\n", http.StatusPermanentRedirect, rec.Code)
+			}
+
+			if loc := rec.Header().Get("Location"); loc != tc.expected {
+				t.Errorf("expected location: %s; got location: %s\n", tc.expected, loc)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	"sync"
+)
+
+// LoadBalancer picks the instance which should serve the next request.
+type LoadBalancer interface {
+	// Next returns one element of the given – never empty – slice of available instances.
+	Next([]Instance) Instance
+}
+
+// LoadBalancerFactory creates a new LoadBalancer for each service.
+type LoadBalancerFactory func() LoadBalancer
+
+const (
+	LoadBalancerRoundRobin         = "roundRobin"
+	LoadBalancerWeightedRoundRobin = "weightedRoundRobin"
+	LoadBalancerLeastRequests      = "leastRequests"
+	LoadBalancerRandomTwoChoices   = "randomTwoChoices"
+
+	defaultLoadBalancer = LoadBalancerRoundRobin
+)
+
+var (
+	loadBalancersMu sync.RWMutex
+
+	loadBalancerFactories = map[string]LoadBalancerFactory{
+		LoadBalancerRoundRobin:         func() LoadBalancer { return &roundRobin{} },
+		LoadBalancerWeightedRoundRobin: func() LoadBalancer { return newWeightedRoundRobin() },
+		LoadBalancerLeastRequests:      func() LoadBalancer { return &leastRequests{} },
+		LoadBalancerRandomTwoChoices:   func() LoadBalancer { return &randomTwoChoices{} },
+	}
+)
+
+// RegisterLoadBalancer registers a custom load balancing strategy by the given name,
+// so it can be referenced in the config of any service. It must be called before
+// the services are registered. Returns error if the name is already taken.
+func RegisterLoadBalancer(name string, factory LoadBalancerFactory) error {
+	if name == "" || factory == nil {
+		return errBadLoadBalancer
+	}
+
+	loadBalancersMu.Lock()
+	defer loadBalancersMu.Unlock()
+
+	if _, exists := loadBalancerFactories[name]; exists {
+		return errLoadBalancerExists
+	}
+
+	loadBalancerFactories[name] = factory
+
+	return nil
+}
+
+// getLoadBalancerFactory returns the factory registered by the given name.
+// In case of empty name, the default strategy is returned.
+func getLoadBalancerFactory(name string) (LoadBalancerFactory, bool) {
+	if name == "" {
+		name = defaultLoadBalancer
+	}
+
+	loadBalancersMu.RLock()
+	defer loadBalancersMu.RUnlock()
+
+	f, ok := loadBalancerFactories[name]
+	return f, ok
+}
+
+// roundRobin picks the instances one after another.
+type roundRobin struct {
+	mu      sync.Mutex
+	counter uint64
+}
+
+func (rr *roundRobin) Next(instances []Instance) Instance {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	inst := instances[rr.counter%uint64(len(instances))]
+	rr.counter++
+
+	return inst
+}
+
+// weightedRoundRobin is the smooth weighted round-robin algorithm,
+// known from nginx. Instances with higher weight are picked more often,
+// but the picks are interleaved instead of being bursty.
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[Instance]int
+}
+
+func newWeightedRoundRobin() *weightedRoundRobin {
+	return &weightedRoundRobin{
+		current: make(map[Instance]int),
+	}
+}
+
+func (wrr *weightedRoundRobin) Next(instances []Instance) Instance {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+
+	var (
+		total = 0
+		best  Instance
+	)
+
+	for _, inst := range instances {
+		w := inst.GetWeight()
+
+		total += w
+		wrr.current[inst] += w
+
+		if best == nil || wrr.current[inst] > wrr.current[best] {
+			best = inst
+		}
+	}
+
+	wrr.current[best] -= total
+
+	return best
+}
+
+// leastRequests picks the instance with the least outstanding requests.
+type leastRequests struct{}
+
+func (lr *leastRequests) Next(instances []Instance) Instance {
+	best := instances[0]
+
+	for _, inst := range instances[1:] {
+		if inst.GetInFlight() < best.GetInFlight() {
+			best = inst
+		}
+	}
+
+	return best
+}
+
+// randomTwoChoices picks two instances randomly,
+// then chooses the one with less outstanding requests.
+type randomTwoChoices struct{}
+
+func (p2c *randomTwoChoices) Next(instances []Instance) Instance {
+	if len(instances) == 1 {
+		return instances[0]
+	}
+
+	var (
+		i = randomIntn(len(instances))
+		j = randomIntn(len(instances) - 1)
+	)
+
+	// Shifting the second index, so it never equals to the first one.
+	if j >= i {
+		j++
+	}
+
+	if instances[j].GetInFlight() < instances[i].GetInFlight() {
+		return instances[j]
+	}
+
+	return instances[i]
+}
@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"errors"
+	"testing"
+)
+
+type mockInstance struct {
+	address  string
+	weight   int
+	inFlight int64
+}
+
+func (mi *mockInstance) GetAddress() string { return mi.address }
+func (mi *mockInstance) GetWeight() int     { return mi.weight }
+func (mi *mockInstance) GetInFlight() int64 { return mi.inFlight }
+
+var _ Instance = (*mockInstance)(nil)
+
+func getMockInstances() []Instance {
+	return []Instance{
+		&mockInstance{address: "a", weight: 5},
+		&mockInstance{address: "b", weight: 1},
+		&mockInstance{address: "c", weight: 1},
+	}
+}
+
+func TestLoadBalancerNext(t *testing.T) {
+	type testCase struct {
+		name      string
+		balancer  LoadBalancer
+		instances []Instance
+		rounds    int
+		expected  map[string]int
+	}
+
+	tt := []testCase{
+		{
+			name:      "round-robin picks every instance equally",
+			balancer:  &roundRobin{},
+			instances: getMockInstances(),
+			rounds:    9,
+			expected:  map[string]int{"a": 3, "b": 3, "c": 3},
+		},
+		{
+			name:      "weighted round-robin picks the instances by their weight",
+			balancer:  newWeightedRoundRobin(),
+			instances: getMockInstances(),
+			rounds:    14,
+			expected:  map[string]int{"a": 10, "b": 2, "c": 2},
+		},
+		{
+			name:     "least requests always picks the least busy instance",
+			balancer: &leastRequests{},
+			instances: []Instance{
+				&mockInstance{address: "a", inFlight: 3},
+				&mockInstance{address: "b", inFlight: 1},
+				&mockInstance{address: "c", inFlight: 2},
+			},
+			rounds:   4,
+			expected: map[string]int{"b": 4},
+		},
+		{
+			name:     "random two choices never picks the busier of two instances",
+			balancer: &randomTwoChoices{},
+			instances: []Instance{
+				&mockInstance{address: "a", inFlight: 10},
+				&mockInstance{address: "b", inFlight: 0},
+			},
+			rounds:   20,
+			expected: map[string]int{"b": 20},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got := make(map[string]int)
+
+			for i := 0; i < tc.rounds; i++ {
+				got[tc.balancer.Next(tc.instances).GetAddress()]++
+			}
+
+			for addr, count := range tc.expected {
+				if got[addr] != count {
+					t.Errorf("expected %s to be picked %d times; got: %d\n", addr, count, got[addr])
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterLoadBalancer(t *testing.T) {
+	type testCase struct {
+		name    string
+		lbName  string
+		factory LoadBalancerFactory
+		err     error
+	}
+
+	factory := func() LoadBalancer { return &roundRobin{} }
+
+	tt := []testCase{
+		{
+			name:    "the function returns error if the name is empty",
+			lbName:  "",
+			factory: factory,
+			err:     errBadLoadBalancer,
+		},
+		{
+			name:    "the function returns error if the factory is nil",
+			lbName:  "mock-lb",
+			factory: nil,
+			err:     errBadLoadBalancer,
+		},
+		{
+			name:    "the function returns error if the name is already taken",
+			lbName:  LoadBalancerRoundRobin,
+			factory: factory,
+			err:     errLoadBalancerExists,
+		},
+		{
+			name:    "the function registers the new load balancer",
+			lbName:  "mock-lb",
+			factory: factory,
+			err:     nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := RegisterLoadBalancer(tc.lbName, tc.factory); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
@@ -57,6 +57,7 @@ type logger interface {
 	Warning(string)
 	clean()
 	disable(logTypeValue)
+	setDisabled(logTypeValue)
 }
 
 const (
@@ -202,3 +203,8 @@ func (l *gatewayLogger) disable(d logTypeValue) {
 	}
 	l.logLevel -= d
 }
+
+// setDisabled enables every logger, except the given ones.
+func (l *gatewayLogger) setDisabled(d logTypeValue) {
+	l.logLevel = defaultLogLevel &^ d
+}
@@ -0,0 +1,273 @@
+package gateway
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultOutlierConsecutiveErrors = 5
+	defaultOutlierErrorRatePercent  = 50
+	defaultOutlierMinRequests       = 10
+	defaultOutlierWindow            = 10 * time.Second
+	defaultOutlierBaseEjectionTime  = 30 * time.Second
+	defaultOutlierMaxEjectionTime   = 5 * time.Minute
+)
+
+// OutlierDetectionConfig describes the passive healthcheck of a service,
+// which is driven by the results of the proxied requests. The instance
+// which is found to be an outlier is ejected – not picked by the load
+// balancer – for a while, then re-admitted by the next successful
+// healthcheck. Every field is optional, and has a sensible default.
+type OutlierDetectionConfig struct {
+	// Disables the detection for the service.
+	Disabled bool `json:"disabled"`
+
+	// The count of consecutive connection errors, which ejects the instance.
+	ConsecutiveErrors int `json:"consecutiveErrors"`
+
+	// The percentage of the failed – 5xx or connection error – requests
+	// in a window, which ejects the instance. Only applied, if there
+	// were at least MinRequests requests in the window.
+	ErrorRatePercent int    `json:"errorRatePercent"`
+	MinRequests      int    `json:"minRequests"`
+	Window           string `json:"window"`
+
+	// The duration of the first ejection, which is doubled by each
+	// consecutive ejection of the same instance, up to MaxEjectionTime.
+	BaseEjectionTime string `json:"baseEjectionTime"`
+	MaxEjectionTime  string `json:"maxEjectionTime"`
+}
+
+// outlierDetection is the parsed, ready to use form of OutlierDetectionConfig.
+type outlierDetection struct {
+	disabled bool
+
+	consecutiveErrors int
+	errorRatePercent  int
+	minRequests       int
+	window            time.Duration
+
+	baseEjectionTime time.Duration
+	maxEjectionTime  time.Duration
+}
+
+// newOutlierDetection creates the detection from the given – already validated – config.
+func newOutlierDetection(conf *OutlierDetectionConfig) *outlierDetection {
+	od := &outlierDetection{
+		consecutiveErrors: defaultOutlierConsecutiveErrors,
+		errorRatePercent:  defaultOutlierErrorRatePercent,
+		minRequests:       defaultOutlierMinRequests,
+		window:            defaultOutlierWindow,
+		baseEjectionTime:  defaultOutlierBaseEjectionTime,
+		maxEjectionTime:   defaultOutlierMaxEjectionTime,
+	}
+
+	if conf == nil {
+		return od
+	}
+
+	od.disabled = conf.Disabled
+
+	if conf.ConsecutiveErrors > 0 {
+		od.consecutiveErrors = conf.ConsecutiveErrors
+	}
+
+	if conf.ErrorRatePercent > 0 {
+		od.errorRatePercent = conf.ErrorRatePercent
+	}
+
+	if conf.MinRequests > 0 {
+		od.minRequests = conf.MinRequests
+	}
+
+	if d, err := time.ParseDuration(conf.Window); err == nil {
+		od.window = d
+	}
+
+	if d, err := time.ParseDuration(conf.BaseEjectionTime); err == nil {
+		od.baseEjectionTime = d
+	}
+
+	if d, err := time.ParseDuration(conf.MaxEjectionTime); err == nil {
+		od.maxEjectionTime = d
+	}
+
+	if od.maxEjectionTime < od.baseEjectionTime {
+		od.maxEjectionTime = od.baseEjectionTime
+	}
+
+	return od
+}
+
+// validateOutlierDetection validates the given config.
+// It returns the first error that occured.
+func validateOutlierDetection(conf *OutlierDetectionConfig) error {
+	if conf == nil {
+		return nil
+	}
+
+	if conf.ConsecutiveErrors < 0 || conf.MinRequests < 0 {
+		return errBadOutlierThreshold
+	}
+
+	if conf.ErrorRatePercent < 0 || conf.ErrorRatePercent > 100 {
+		return errBadOutlierErrorRate
+	}
+
+	for _, d := range []string{conf.Window, conf.BaseEjectionTime, conf.MaxEjectionTime} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return errBadOutlierDuration
+		}
+	}
+
+	return nil
+}
+
+// outlierState stores the results of the proxied
+// requests and the ejection of one instance.
+type outlierState struct {
+	mu sync.Mutex
+
+	consecutiveErrors int
+
+	// The counters of the current – fixed – window.
+	windowStart time.Time
+	requests    int
+	failures    int
+
+	ejected      bool
+	ejectedUntil time.Time
+
+	// The count of consecutive ejections, which multiplies
+	// the ejection time. Reset by a window without failure.
+	ejections int
+}
+
+// record counts the result of a proxied request. The request failed, if there
+// was a connection error – isConnErr – or the response was 5xx. It returns
+// true, if the instance got ejected by this result.
+func (ost *outlierState) record(od *outlierDetection, failed bool, isConnErr bool, now time.Time) bool {
+	if od == nil || od.disabled {
+		return false
+	}
+
+	ost.mu.Lock()
+	defer ost.mu.Unlock()
+
+	if now.Sub(ost.windowStart) >= od.window {
+		if ost.requests > 0 && ost.failures == 0 && !ost.ejected {
+			ost.ejections = 0
+		}
+
+		ost.windowStart = now
+		ost.requests = 0
+		ost.failures = 0
+	}
+
+	ost.requests++
+
+	if isConnErr {
+		ost.consecutiveErrors++
+	} else {
+		ost.consecutiveErrors = 0
+	}
+
+	if failed {
+		ost.failures++
+	}
+
+	// Already ejected, nothing to do, until it gets re-admitted.
+	if ost.ejected {
+		return false
+	}
+
+	var (
+		isErrorsReached = ost.consecutiveErrors >= od.consecutiveErrors
+		isRateReached   = ost.requests >= od.minRequests && ost.failures*100 >= od.errorRatePercent*ost.requests
+	)
+
+	if !isErrorsReached && !isRateReached {
+		return false
+	}
+
+	ejectionTime := od.baseEjectionTime << ost.ejections
+	if ejectionTime > od.maxEjectionTime || ejectionTime <= 0 {
+		ejectionTime = od.maxEjectionTime
+	}
+
+	ost.ejected = true
+	ost.ejectedUntil = now.Add(ejectionTime)
+	ost.ejections++
+
+	ost.consecutiveErrors = 0
+	ost.windowStart = now
+	ost.requests = 0
+	ost.failures = 0
+
+	return true
+}
+
+// isEjected tells if the instance is ejected at the moment.
+// After the ejection time is over, the instance stays ejected
+// until it is re-admitted by a successful healthcheck.
+func (ost *outlierState) isEjected() bool {
+	ost.mu.Lock()
+	defer ost.mu.Unlock()
+
+	return ost.ejected
+}
+
+// getEjectedUntil returns the end of the ejection time, if the instance is ejected.
+func (ost *outlierState) getEjectedUntil() (time.Time, bool) {
+	ost.mu.Lock()
+	defer ost.mu.Unlock()
+
+	return ost.ejectedUntil, ost.ejected
+}
+
+// readmit re-admits the ejected instance, if its ejection time is over.
+func (ost *outlierState) readmit(now time.Time) {
+	ost.mu.Lock()
+	defer ost.mu.Unlock()
+
+	if ost.ejected && !now.Before(ost.ejectedUntil) {
+		ost.ejected = false
+	}
+}
+
+// inherit copies the counters and the ejection of the given – old – state.
+func (ost *outlierState) inherit(old *outlierState) {
+	old.mu.Lock()
+	defer old.mu.Unlock()
+
+	ost.mu.Lock()
+	defer ost.mu.Unlock()
+
+	ost.consecutiveErrors = old.consecutiveErrors
+	ost.windowStart = old.windowStart
+	ost.requests = old.requests
+	ost.failures = old.failures
+	ost.ejected = old.ejected
+	ost.ejectedUntil = old.ejectedUntil
+	ost.ejections = old.ejections
+}
+
+// getInfo returns the public view of the ejection, or nil if the instance is not ejected.
+func (ost *outlierState) getInfo() *EjectionInfo {
+	ost.mu.Lock()
+	defer ost.mu.Unlock()
+
+	if !ost.ejected {
+		return nil
+	}
+
+	return &EjectionInfo{
+		Until:         ost.ejectedUntil,
+		Ejections:     ost.ejections,
+		AwaitingProbe: !time.Now().Before(ost.ejectedUntil),
+	}
+}
@@ -0,0 +1,234 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValidateOutlierDetection(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *OutlierDetectionConfig
+		err  error
+	}
+
+	tt := []testCase{
+		{
+			name: "the function returns nil if there is no config",
+			conf: nil,
+			err:  nil,
+		},
+		{
+			name: "the function returns error if a threshold is negative",
+			conf: &OutlierDetectionConfig{ConsecutiveErrors: -1},
+			err:  errBadOutlierThreshold,
+		},
+		{
+			name: "the function returns error if the error rate is above 100",
+			conf: &OutlierDetectionConfig{ErrorRatePercent: 101},
+			err:  errBadOutlierErrorRate,
+		},
+		{
+			name: "the function returns error if the ejection time is invalid",
+			conf: &OutlierDetectionConfig{BaseEjectionTime: "30"},
+			err:  errBadOutlierDuration,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &OutlierDetectionConfig{ConsecutiveErrors: 3, ErrorRatePercent: 20, Window: "1m", BaseEjectionTime: "10s", MaxEjectionTime: "1m"},
+			err:  nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateOutlierDetection(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestOutlierRecord(t *testing.T) {
+	type result struct {
+		failed    bool
+		isConnErr bool
+	}
+
+	type testCase struct {
+		name       string
+		conf       *OutlierDetectionConfig
+		results    []result
+		expEjected bool
+	}
+
+	var (
+		connErr = result{failed: true, isConnErr: true}
+		status5 = result{failed: true}
+		success = result{}
+	)
+
+	tt := []testCase{
+		{
+			name:       "the consecutive connection errors eject the instance",
+			conf:       &OutlierDetectionConfig{ConsecutiveErrors: 3},
+			results:    []result{connErr, connErr, connErr},
+			expEjected: true,
+		},
+		{
+			name:       "a success resets the count of connection errors",
+			conf:       &OutlierDetectionConfig{ConsecutiveErrors: 3, MinRequests: 100},
+			results:    []result{connErr, connErr, success, connErr, connErr},
+			expEjected: false,
+		},
+		{
+			name:       "the rate of 5xx responses ejects the instance",
+			conf:       &OutlierDetectionConfig{ErrorRatePercent: 50, MinRequests: 4},
+			results:    []result{success, status5, success, status5},
+			expEjected: true,
+		},
+		{
+			name:       "the rate is not applied below the minimum count of requests",
+			conf:       &OutlierDetectionConfig{ErrorRatePercent: 50, MinRequests: 4},
+			results:    []result{status5, status5, status5},
+			expEjected: false,
+		},
+		{
+			name:       "the disabled detection never ejects",
+			conf:       &OutlierDetectionConfig{Disabled: true, ConsecutiveErrors: 1},
+			results:    []result{connErr, connErr},
+			expEjected: false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				od  = newOutlierDetection(tc.conf)
+				ost = &outlierState{}
+				now = time.Now()
+			)
+
+			for _, r := range tc.results {
+				ost.record(od, r.failed, r.isConnErr, now)
+			}
+
+			if ejected := ost.isEjected(); ejected != tc.expEjected {
+				t.Errorf("expected ejected: %t; got ejected: %t\n", tc.expEjected, ejected)
+			}
+		})
+	}
+}
+
+func TestOutlierEjectionBackoff(t *testing.T) {
+	var (
+		od  = newOutlierDetection(&OutlierDetectionConfig{ConsecutiveErrors: 1, BaseEjectionTime: "10s", MaxEjectionTime: "25s"})
+		ost = &outlierState{}
+		now = time.Now()
+	)
+
+	expected := []time.Duration{10 * time.Second, 20 * time.Second, 25 * time.Second}
+
+	for _, exp := range expected {
+		if !ost.record(od, true, true, now) {
+			t.Fatalf("expected the instance to be ejected\n")
+		}
+
+		until, _ := ost.getEjectedUntil()
+		if got := until.Sub(now); got != exp {
+			t.Errorf("expected ejection time: %v; got ejection time: %v\n", exp, got)
+		}
+
+		// The probe before the end of the ejection time has no effect.
+		ost.readmit(until.Add(-time.Second))
+
+		if !ost.isEjected() {
+			t.Fatalf("expected the instance to stay ejected before the end of the ejection\n")
+		}
+
+		ost.readmit(until)
+
+		if ost.isEjected() {
+			t.Fatalf("expected the instance to be re-admitted after the end of the ejection\n")
+		}
+
+		now = until
+	}
+}
+
+func TestNextInstanceSkipsEjected(t *testing.T) {
+	s := newService(&ServiceConfig{
+		Protocol: "http",
+		Instances: []*InstanceConfig{
+			{Host: "localhost", Port: "8000"},
+			{Host: "localhost", Port: "8001"},
+		},
+		OutlierDetection: &OutlierDetectionConfig{ConsecutiveErrors: 1},
+	})
+
+	s.setState(StateAvailable)
+
+	s.instances[0].recordOutcome(s.outlier, 0, errors.New("mock-conn-error"))
+
+	for i := 0; i < len(s.instances); i++ {
+		if inst := s.nextInstance(); inst == nil || inst.GetAddress() != "localhost:8001" {
+			t.Errorf("expected instance: %s; got: %v\n", "localhost:8001", inst)
+		}
+	}
+
+	if d := s.getNextCheckDelay(time.Hour); d > defaultOutlierBaseEjectionTime {
+		t.Errorf("expected the next check before: %v; got: %v\n", defaultOutlierBaseEjectionTime, d)
+	}
+}
+
+func TestOutlierSkipsClientCancel(t *testing.T) {
+	type testCase struct {
+		name       string
+		isCanceled bool
+		expEjected bool
+	}
+
+	tt := []testCase{
+		{name: "the request canceled by the client does not eject the instance", isCanceled: true, expEjected: false},
+		{name: "the failed request ejects the instance", isCanceled: false, expEjected: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// The connection is dropped by the instance, which is a connection error.
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+					conn.Close()
+				}
+			}))
+			defer srv.Close()
+
+			s := newTestService(t, srv, &ServiceConfig{
+				Name:             "mock-name",
+				Prefix:           "/api/mock",
+				OutlierDetection: &OutlierDetectionConfig{ConsecutiveErrors: 1},
+			})
+			defer s.close()
+
+			reqCtx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if tc.isCanceled {
+				cancel()
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/api/mock/foo", nil).WithContext(reqCtx)
+			ctx, _ := newTestContext(r)
+
+			s.Handle(ctx)
+
+			if ejected := s.instances[0].outlier.isEjected(); ejected != tc.expEjected {
+				t.Errorf("expected ejected: %t; got ejected: %t\n", tc.expEjected, ejected)
+			}
+		})
+	}
+}
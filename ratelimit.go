@@ -0,0 +1,471 @@
+package gateway
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balazskvancz/gorouter"
+)
+
+const (
+	// The supported algorithms of the rate limiter.
+	RateLimitTokenBucket   = "tokenBucket"
+	RateLimitSlidingWindow = "slidingWindow"
+
+	// The sources of the key, which the requests are limited by.
+	RateLimitKeyIP       = "ip"
+	RateLimitKeyHeader   = "header"
+	RateLimitKeyJWTClaim = "jwtClaim"
+	RateLimitKeyService  = "service"
+
+	rateLimitLimitHeader     = "RateLimit-Limit"
+	rateLimitRemainingHeader = "RateLimit-Remaining"
+	rateLimitResetHeader     = "RateLimit-Reset"
+
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "bearer "
+
+	defaultRateLimitPeriod     = time.Second
+	defaultRateLimitEvictAfter = 10 * time.Minute
+	defaultRateLimitMaxKeys    = 10000
+)
+
+var (
+	rateLimitAlgorithms = []string{RateLimitTokenBucket, RateLimitSlidingWindow}
+	rateLimitKeys       = []string{RateLimitKeyIP, RateLimitKeyHeader, RateLimitKeyJWTClaim, RateLimitKeyService}
+)
+
+// RateLimitConfig describes a rate limiter, which limits the count of requests
+// of each client – identified by the key – under the given prefix. The state
+// of the limiter is stored in memory, and the keys, which were idle for a
+// while, are evicted periodically.
+type RateLimitConfig struct {
+	// The prefix of the urls, which are limited – eg. the prefix of a service.
+	// If it is empty, every request is limited.
+	Prefix string `json:"prefix"`
+
+	// Either tokenBucket or slidingWindow. By default it is tokenBucket.
+	Algorithm string `json:"algorithm"`
+
+	// The count of requests allowed in each period – eg. "1s" or "1m".
+	// By default the period is one second.
+	Requests int    `json:"requests"`
+	Period   string `json:"period"`
+
+	// The size of the token bucket, which allows short bursts above
+	// the rate. By default it equals to Requests. Only used by tokenBucket.
+	Burst int `json:"burst"`
+
+	// The source of the key: ip, header, jwtClaim or service. By default
+	// it is ip. With service, all the requests share the same limit. If
+	// the key can not be derived from the request, the ip is used instead.
+	KeyBy string `json:"keyBy"`
+
+	// The name of the header – eg. X-API-Key –, if the key is a header.
+	Header string `json:"header"`
+
+	// The name of the claim – eg. sub –, if the key is a JWT claim. The token
+	// is read from the Authorization header, and it is not verified here.
+	Claim string `json:"claim"`
+
+	// The keys are evicted after being idle for this long – eg. "10m".
+	EvictAfter string `json:"evictAfter"`
+
+	// The maximum count of the keys taken from the headers or the claims, which
+	// are tracked at once. Since the clients can choose these keys freely, the
+	// requests of the new keys beyond it are limited by the ip of the client.
+	// By default it is 10000.
+	MaxKeys int `json:"maxKeys"`
+}
+
+// rateLimitEntry stores the state of one key.
+type rateLimitEntry struct {
+	lastSeen time.Time
+
+	// The key was taken from a header or a claim.
+	isFromRequest bool
+
+	// The state of the token bucket.
+	tokens float64
+
+	// The state of the sliding window: the counts of the
+	// current and the previous fixed windows.
+	windowStart time.Time
+	current     int
+	previous    int
+}
+
+// rateLimitResult is the outcome of one request.
+type rateLimitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// rateLimiter is the parsed, ready to use form of RateLimitConfig.
+type rateLimiter struct {
+	prefix    string
+	algorithm string
+	limit     int
+	burst     int
+	period    time.Duration
+	keyBy     string
+	header    string
+	claim     string
+
+	evictAfter time.Duration
+	maxKeys    int
+
+	mu          sync.Mutex
+	entries     map[string]*rateLimitEntry
+	requestKeys int
+	lastEvicted time.Time
+}
+
+// NewRateLimitMiddleware creates a middleware, which limits the rate
+// of the requests by the given config. It can be registered to the
+// Gateway by RegisterMiddleware. It returns error, if the config is invalid.
+func NewRateLimitMiddleware(conf *RateLimitConfig) (Middleware, error) {
+	if err := validateRateLimit(conf); err != nil {
+		return nil, err
+	}
+
+	rl := newRateLimiter(conf)
+
+	// Disabling the middlewares – eg. in development – must not
+	// lift the limits of the services as well.
+	return gorouter.NewMiddleware(
+		rl.handle,
+		gorouter.MiddlewareWithMatchers(rl.matches),
+		gorouter.MiddlewareWithAlwaysAllowed(true),
+	), nil
+}
+
+// newRateLimiter creates the limiter from the given – already validated – config.
+func newRateLimiter(conf *RateLimitConfig) *rateLimiter {
+	rl := &rateLimiter{
+		prefix:     strings.TrimSuffix(conf.Prefix, "/"),
+		algorithm:  RateLimitTokenBucket,
+		limit:      conf.Requests,
+		burst:      conf.Requests,
+		period:     defaultRateLimitPeriod,
+		keyBy:      RateLimitKeyIP,
+		header:     conf.Header,
+		claim:      conf.Claim,
+		evictAfter: defaultRateLimitEvictAfter,
+		maxKeys:    defaultRateLimitMaxKeys,
+		entries:    make(map[string]*rateLimitEntry),
+	}
+
+	if conf.MaxKeys > 0 {
+		rl.maxKeys = conf.MaxKeys
+	}
+
+	if conf.Algorithm != "" {
+		rl.algorithm = conf.Algorithm
+	}
+
+	if d, err := time.ParseDuration(conf.Period); err == nil {
+		rl.period = d
+	}
+
+	if conf.Burst > 0 {
+		rl.burst = conf.Burst
+	}
+
+	if conf.KeyBy != "" {
+		rl.keyBy = conf.KeyBy
+	}
+
+	if d, err := time.ParseDuration(conf.EvictAfter); err == nil {
+		rl.evictAfter = d
+	}
+
+	// A key must not be evicted, while its state still
+	// counts, otherwise the client would get a fresh limit.
+	if minIdle := rl.getMinIdle(); rl.evictAfter < minIdle {
+		rl.evictAfter = minIdle
+	}
+
+	return rl
+}
+
+// validateRateLimit validates the given config.
+// It returns the first error that occured.
+func validateRateLimit(conf *RateLimitConfig) error {
+	if conf == nil {
+		return errRateLimitConfigIsNil
+	}
+
+	if conf.Prefix != "" && !strings.HasPrefix(conf.Prefix, "/") {
+		return errBadRateLimitPrefix
+	}
+
+	if conf.Algorithm != "" && !includes(rateLimitAlgorithms, conf.Algorithm) {
+		return errBadRateLimitAlgorithm
+	}
+
+	if conf.Requests <= 0 || conf.Burst < 0 || conf.MaxKeys < 0 {
+		return errBadRateLimitRequests
+	}
+
+	for _, d := range []string{conf.Period, conf.EvictAfter} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return errBadRateLimitDuration
+		}
+	}
+
+	if conf.KeyBy != "" && !includes(rateLimitKeys, conf.KeyBy) {
+		return errBadRateLimitKey
+	}
+
+	if conf.KeyBy == RateLimitKeyHeader && conf.Header == "" {
+		return errBadRateLimitKey
+	}
+
+	if conf.KeyBy == RateLimitKeyJWTClaim && conf.Claim == "" {
+		return errBadRateLimitKey
+	}
+
+	return nil
+}
+
+// matches tells if the url of the request is under the prefix of the limiter.
+func (rl *rateLimiter) matches(ctx Context) bool {
+	return isRequestUnderPrefix(ctx, rl.prefix)
+}
+
+// handle is the middleware function of the limiter. The rejected requests
+// get HTTP 429, while every response gets the RateLimit-* headers.
+func (rl *rateLimiter) handle(ctx Context, next HandlerFunc) {
+	res := rl.takeOr(rl.getKey(ctx), rl.getIPKey(ctx), time.Now())
+
+	header := http.Header{}
+	header.Set(rateLimitLimitHeader, strconv.Itoa(res.limit))
+	header.Set(rateLimitRemainingHeader, strconv.Itoa(res.remaining))
+	header.Set(rateLimitResetHeader, strconv.Itoa(ceilSeconds(res.reset)))
+
+	if !res.allowed {
+		// Retry-After is given in whole seconds, so it is at least 1.
+		retryAfter := ceilSeconds(res.retryAfter)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+
+		header.Set(retryAfterHeader, strconv.Itoa(retryAfter))
+
+		ctx.SendRaw([]byte(http.StatusText(http.StatusTooManyRequests)), http.StatusTooManyRequests, header)
+
+		return
+	}
+
+	ctx.AppendHttpHeader(header)
+
+	next(ctx)
+}
+
+// getKey returns the key, which the request is limited by.
+func (rl *rateLimiter) getKey(ctx Context) string {
+	switch rl.keyBy {
+	case RateLimitKeyService:
+		return RateLimitKeyService
+	case RateLimitKeyHeader:
+		if v := ctx.GetRequestHeader(rl.header); v != "" {
+			return RateLimitKeyHeader + ":" + v
+		}
+	case RateLimitKeyJWTClaim:
+		if v, ok := getUnverifiedJWTClaim(ctx.GetRequestHeader(authorizationHeader), rl.claim); ok {
+			return RateLimitKeyJWTClaim + ":" + v
+		}
+	}
+
+	return rl.getIPKey(ctx)
+}
+
+// getIPKey returns the key of the ip of the client.
+func (rl *rateLimiter) getIPKey(ctx Context) string {
+	return RateLimitKeyIP + ":" + getClientIP(ctx.GetRequest())
+}
+
+// take counts a request of the given key, and tells if it is allowed.
+func (rl *rateLimiter) take(key string, now time.Time) rateLimitResult {
+	return rl.takeOr(key, "", now)
+}
+
+// takeOr counts a request of the given key, and tells if it is allowed. If the key
+// was taken from a header or a claim, and it is new, while maxKeys of those are
+// already tracked, the request is counted by the fallback key instead.
+func (rl *rateLimiter) takeOr(key string, fallback string, now time.Time) rateLimitResult {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.evictLocked(now)
+
+	isFromRequest := fallback != "" && key != fallback &&
+		(rl.keyBy == RateLimitKeyHeader || rl.keyBy == RateLimitKeyJWTClaim)
+
+	e, ok := rl.entries[key]
+	if !ok && isFromRequest && rl.requestKeys >= rl.maxKeys {
+		key, isFromRequest = fallback, false
+		e, ok = rl.entries[key]
+	}
+
+	if !ok {
+		e = &rateLimitEntry{
+			tokens:        float64(rl.burst),
+			windowStart:   now,
+			isFromRequest: isFromRequest,
+		}
+		rl.entries[key] = e
+
+		if isFromRequest {
+			rl.requestKeys++
+		}
+	}
+
+	if rl.algorithm == RateLimitSlidingWindow {
+		return rl.takeSlidingWindow(e, now)
+	}
+
+	return rl.takeTokenBucket(e, now)
+}
+
+// takeTokenBucket refills the bucket by the elapsed time, then takes a token from it.
+func (rl *rateLimiter) takeTokenBucket(e *rateLimitEntry, now time.Time) rateLimitResult {
+	// The count of tokens added per nanosecond.
+	rate := float64(rl.limit) / float64(rl.period)
+
+	if elapsed := now.Sub(e.lastSeen); !e.lastSeen.IsZero() && elapsed > 0 {
+		e.tokens = math.Min(float64(rl.burst), e.tokens+float64(elapsed)*rate)
+	}
+
+	e.lastSeen = now
+
+	res := rateLimitResult{limit: rl.burst}
+
+	if e.tokens >= 1 {
+		e.tokens--
+		res.allowed = true
+	} else {
+		res.retryAfter = time.Duration((1 - e.tokens) / rate)
+	}
+
+	res.remaining = int(e.tokens)
+	res.reset = time.Duration((float64(rl.burst) - e.tokens) / rate)
+
+	return res
+}
+
+// takeSlidingWindow estimates the count of requests in the last period
+// from the counts of the current and the previous fixed windows.
+func (rl *rateLimiter) takeSlidingWindow(e *rateLimitEntry, now time.Time) rateLimitResult {
+	if elapsed := now.Sub(e.windowStart); elapsed >= rl.period {
+		e.previous = e.current
+		// If more than one window passed, the previous one was empty.
+		if elapsed >= 2*rl.period {
+			e.previous = 0
+		}
+		e.current = 0
+		e.windowStart = now.Add(-(elapsed % rl.period))
+	}
+
+	e.lastSeen = now
+
+	var (
+		elapsed   = now.Sub(e.windowStart)
+		weight    = 1 - float64(elapsed)/float64(rl.period)
+		estimated = float64(e.previous)*weight + float64(e.current)
+		res       = rateLimitResult{limit: rl.limit, reset: rl.period - elapsed}
+	)
+
+	if estimated+1 <= float64(rl.limit) {
+		e.current++
+		res.allowed = true
+		res.remaining = int(float64(rl.limit) - estimated - 1)
+
+		return res
+	}
+
+	// The time, until the weight of the previous window gets low enough.
+	res.retryAfter = rl.period - elapsed
+	if e.previous > 0 && e.current < rl.limit {
+		ratio := float64(rl.limit-e.current-1) / float64(e.previous)
+		res.retryAfter = time.Duration((1-ratio)*float64(rl.period)) - elapsed
+	}
+
+	return res
+}
+
+// evictLocked removes the idle keys, at most once in every evictAfter.
+func (rl *rateLimiter) evictLocked(now time.Time) {
+	if now.Sub(rl.lastEvicted) < rl.evictAfter {
+		return
+	}
+
+	rl.lastEvicted = now
+
+	for key, e := range rl.entries {
+		if now.Sub(e.lastSeen) >= rl.evictAfter {
+			delete(rl.entries, key)
+
+			if e.isFromRequest {
+				rl.requestKeys--
+			}
+		}
+	}
+}
+
+// getMinIdle returns the idle time, after which the
+// state of a key is the same as a fresh one.
+func (rl *rateLimiter) getMinIdle() time.Duration {
+	if rl.algorithm == RateLimitSlidingWindow {
+		return 2 * rl.period
+	}
+	return time.Duration(float64(rl.period) * float64(rl.burst) / float64(rl.limit))
+}
+
+// getUnverifiedJWTClaim returns the value of the given claim from the bearer
+// token of the Authorization header. The signature of the token is NOT
+// verified, so the value must only be used, where it can not do harm.
+func getUnverifiedJWTClaim(authorization string, claim string) (string, bool) {
+	token, ok := getBearerToken(authorization)
+	if !ok {
+		return "", false
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	var claims map[string]any
+
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", false
+	}
+
+	value, ok := claims[claim]
+	if !ok || value == nil {
+		return "", false
+	}
+
+	return fmt.Sprint(value), true
+}
+
+// ceilSeconds returns the given duration in whole seconds, rounded up.
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,337 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValidateRateLimit(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *RateLimitConfig
+		err  error
+	}
+
+	tt := []testCase{
+		{
+			name: "the function returns error if there is no config",
+			conf: nil,
+			err:  errRateLimitConfigIsNil,
+		},
+		{
+			name: "the function returns error if the prefix is not started with a slash",
+			conf: &RateLimitConfig{Prefix: "api", Requests: 10},
+			err:  errBadRateLimitPrefix,
+		},
+		{
+			name: "the function returns error if the algorithm is unknown",
+			conf: &RateLimitConfig{Algorithm: "leakyBucket", Requests: 10},
+			err:  errBadRateLimitAlgorithm,
+		},
+		{
+			name: "the function returns error if the count of requests is not given",
+			conf: &RateLimitConfig{},
+			err:  errBadRateLimitRequests,
+		},
+		{
+			name: "the function returns error if the period is invalid",
+			conf: &RateLimitConfig{Requests: 10, Period: "a minute"},
+			err:  errBadRateLimitDuration,
+		},
+		{
+			name: "the function returns error if the header key has no header name",
+			conf: &RateLimitConfig{Requests: 10, KeyBy: RateLimitKeyHeader},
+			err:  errBadRateLimitKey,
+		},
+		{
+			name: "the function returns error if the claim key has no claim name",
+			conf: &RateLimitConfig{Requests: 10, KeyBy: RateLimitKeyJWTClaim},
+			err:  errBadRateLimitKey,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &RateLimitConfig{Prefix: "/api", Algorithm: RateLimitSlidingWindow, Requests: 10, Period: "1m", KeyBy: RateLimitKeyHeader, Header: "X-API-Key"},
+			err:  nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateRateLimit(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	type call struct {
+		after     time.Duration
+		allowed   bool
+		remaining int
+	}
+
+	type testCase struct {
+		name  string
+		conf  *RateLimitConfig
+		calls []call
+	}
+
+	tt := []testCase{
+		{
+			name: "the token bucket allows the burst, then refills by the rate",
+			conf: &RateLimitConfig{Requests: 2, Period: "1s", Burst: 3},
+			calls: []call{
+				{after: 0, allowed: true, remaining: 2},
+				{after: 0, allowed: true, remaining: 1},
+				{after: 0, allowed: true, remaining: 0},
+				{after: 0, allowed: false, remaining: 0},
+				{after: 500 * time.Millisecond, allowed: true, remaining: 0},
+				{after: 0, allowed: false, remaining: 0},
+			},
+		},
+		{
+			name: "the sliding window weights the previous window",
+			conf: &RateLimitConfig{Algorithm: RateLimitSlidingWindow, Requests: 2, Period: "1s"},
+			calls: []call{
+				{after: 0, allowed: true, remaining: 1},
+				{after: 0, allowed: true, remaining: 0},
+				{after: 0, allowed: false, remaining: 0},
+				// The previous window still counts with 75%.
+				{after: 1250 * time.Millisecond, allowed: false, remaining: 0},
+				// The previous window counts with 25%.
+				{after: 500 * time.Millisecond, allowed: true, remaining: 0},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				rl  = newRateLimiter(tc.conf)
+				now = time.Now()
+			)
+
+			for i, c := range tc.calls {
+				now = now.Add(c.after)
+
+				res := rl.take("key", now)
+
+				if res.allowed != c.allowed {
+					t.Errorf("call %d: expected allowed: %t; got allowed: %t\n", i, c.allowed, res.allowed)
+				}
+
+				if res.remaining != c.remaining {
+					t.Errorf("call %d: expected remaining: %d; got remaining: %d\n", i, c.remaining, res.remaining)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterEvict(t *testing.T) {
+	var (
+		rl  = newRateLimiter(&RateLimitConfig{Requests: 1, Period: "1s", EvictAfter: "1m"})
+		now = time.Now()
+	)
+
+	rl.take("idle", now)
+	rl.take("active", now.Add(50*time.Second))
+
+	// Triggers the eviction.
+	rl.take("active", now.Add(70*time.Second))
+
+	if _, ok := rl.entries["idle"]; ok {
+		t.Errorf("expected the idle key to be evicted\n")
+	}
+
+	if _, ok := rl.entries["active"]; !ok {
+		t.Errorf("expected the active key to be kept\n")
+	}
+}
+
+func TestRateLimiterGetKey(t *testing.T) {
+	type testCase struct {
+		name   string
+		conf   *RateLimitConfig
+		header http.Header
+		expKey string
+	}
+
+	var (
+		payload = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-1","tenant":42}`))
+		token   = "Bearer header." + payload + ".signature"
+	)
+
+	tt := []testCase{
+		{
+			name:   "the key is the ip of the client by default",
+			conf:   &RateLimitConfig{Requests: 1},
+			expKey: "ip:192.0.2.1",
+		},
+		{
+			name:   "the key is the value of the header",
+			conf:   &RateLimitConfig{Requests: 1, KeyBy: RateLimitKeyHeader, Header: "X-API-Key"},
+			header: http.Header{"X-Api-Key": {"secret"}},
+			expKey: "header:secret",
+		},
+		{
+			name:   "the key falls back to the ip, if the header is missing",
+			conf:   &RateLimitConfig{Requests: 1, KeyBy: RateLimitKeyHeader, Header: "X-API-Key"},
+			expKey: "ip:192.0.2.1",
+		},
+		{
+			name:   "the key is the claim of the token",
+			conf:   &RateLimitConfig{Requests: 1, KeyBy: RateLimitKeyJWTClaim, Claim: "sub"},
+			header: http.Header{authorizationHeader: {token}},
+			expKey: "jwtClaim:user-1",
+		},
+		{
+			name:   "the key is the numeric claim of the token",
+			conf:   &RateLimitConfig{Requests: 1, KeyBy: RateLimitKeyJWTClaim, Claim: "tenant"},
+			header: http.Header{authorizationHeader: {token}},
+			expKey: "jwtClaim:42",
+		},
+		{
+			name:   "the key falls back to the ip, if the token is malformed",
+			conf:   &RateLimitConfig{Requests: 1, KeyBy: RateLimitKeyJWTClaim, Claim: "sub"},
+			header: http.Header{authorizationHeader: {"Bearer not-a-token"}},
+			expKey: "ip:192.0.2.1",
+		},
+		{
+			name:   "the key is the same for every request of the service",
+			conf:   &RateLimitConfig{Requests: 1, KeyBy: RateLimitKeyService},
+			expKey: RateLimitKeyService,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/foo", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+
+			for k, v := range tc.header {
+				r.Header[k] = v
+			}
+
+			ctx, _ := newTestContext(r)
+
+			if key := newRateLimiter(tc.conf).getKey(ctx); key != tc.expKey {
+				t.Errorf("expected key: %s; got key: %s\n", tc.expKey, key)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	mw, err := NewRateLimitMiddleware(&RateLimitConfig{Prefix: "/api/foo", Requests: 1, Period: "1m"})
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	var calls int
+
+	next := func(ctx Context) {
+		calls++
+		ctx.SendOk()
+	}
+
+	serve := func(url string) (bool, *httptest.ResponseRecorder) {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+
+		ctx, rec := newTestContext(r)
+
+		if !mw.DoesMatch(ctx) {
+			return false, rec
+		}
+
+		mw.Execute(ctx, next)
+		ctx.WriteToResponseNow()
+
+		return true, rec
+	}
+
+	if matched, _ := serve("/api/bar"); matched {
+		t.Errorf("expected the middleware not to match an other prefix\n")
+	}
+
+	_, rec := serve("/api/foo/bar")
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status code: %d; got status code: %d\n", http.StatusOK, rec.Code)
+	}
+
+	if rem := rec.Header().Get(rateLimitRemainingHeader); rem != "0" {
+		t.Errorf("expected remaining: %s; got remaining: %s\n", "0", rem)
+	}
+
+	// The paths routed to the same service – even by a longer
+	// segment, or by encoded characters – are limited too.
+	for _, url := range []string{"/api/foobar", "/api/fo%6F/bar"} {
+		if matched, rec := serve(url); !matched || rec.Code != http.StatusTooManyRequests {
+			t.Errorf("expected status code of %s: %d; got status code: %d\n", url, http.StatusTooManyRequests, rec.Code)
+		}
+	}
+
+	_, rec = serve("/api/foo")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status code: %d; got status code: %d\n", http.StatusTooManyRequests, rec.Code)
+	}
+
+	if ra := rec.Header().Get(retryAfterHeader); ra != "60" {
+		t.Errorf("expected retry after: %s; got retry after: %s\n", "60", ra)
+	}
+
+	if limit := rec.Header().Get(rateLimitLimitHeader); limit != "1" {
+		t.Errorf("expected limit: %s; got limit: %s\n", "1", limit)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected calls: %d; got calls: %d\n", 1, calls)
+	}
+}
+
+func TestRateLimiterMaxKeys(t *testing.T) {
+	var (
+		rl = newRateLimiter(&RateLimitConfig{
+			Requests: 1,
+			Period:   "1m",
+			KeyBy:    RateLimitKeyHeader,
+			Header:   "X-Client-Id",
+			MaxKeys:  2,
+		})
+		now = time.Now()
+	)
+
+	for _, key := range []string{"header:a", "header:b"} {
+		if res := rl.takeOr(key, "ip:192.0.2.1", now); !res.allowed {
+			t.Errorf("expected the first request of %s to be allowed\n", key)
+		}
+	}
+
+	// Beyond the cap, the new keys are limited by the ip of the client.
+	if res := rl.takeOr("header:c", "ip:192.0.2.1", now); !res.allowed {
+		t.Errorf("expected the first request of the ip to be allowed\n")
+	}
+
+	if res := rl.takeOr("header:d", "ip:192.0.2.1", now); res.allowed {
+		t.Errorf("expected the rotated key to be limited by the ip\n")
+	}
+
+	if l := len(rl.entries); l != 3 {
+		t.Errorf("expected entries: %d; got entries: %d\n", 3, l)
+	}
+
+	// Once the keys are evicted, there is room for the new ones again.
+	if res := rl.takeOr("header:d", "ip:192.0.2.1", now.Add(defaultRateLimitEvictAfter)); !res.allowed {
+		t.Errorf("expected the new key to be allowed after the eviction\n")
+	}
+
+	if rl.requestKeys != 1 {
+		t.Errorf("expected request keys: %d; got request keys: %d\n", 1, rl.requestKeys)
+	}
+}
@@ -0,0 +1,336 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// serviceDiff stores the names of the services which
+// were added, removed or changed by a new config.
+type serviceDiff struct {
+	added   []string
+	removed []string
+	changed []string
+}
+
+func (d *serviceDiff) isEmpty() bool {
+	return len(d.added) == 0 && len(d.removed) == 0 && len(d.changed) == 0
+}
+
+func (d *serviceDiff) String() string {
+	return fmt.Sprintf("added: [%s], removed: [%s], changed: [%s]",
+		strings.Join(d.added, ", "),
+		strings.Join(d.removed, ", "),
+		strings.Join(d.changed, ", "),
+	)
+}
+
+// WithConfigWatch makes the gateway watch its config file, and reload
+// it whenever it is changed. The file is checked with the given interval.
+// It only takes effect, if the gateway was created by NewFromConfig.
+func WithConfigWatch(interval time.Duration) GatewayOptionFunc {
+	return func(g *Gateway) {
+		g.info.configWatchInterval = interval
+	}
+}
+
+// parseConfigWatchInterval parses the interval of the config
+// watch – eg. "10s" –, which must be positive.
+func parseConfigWatchInterval(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, errBadConfigWatchInterval
+	}
+
+	return d, nil
+}
+
+// withConfigSource stores the path and the content of the config,
+// which the gateway was created from, so it can be reloaded later.
+func withConfigSource(path string, conf *GatewayConfig) GatewayOptionFunc {
+	return func(g *Gateway) {
+		g.configPath = path
+		g.config = conf
+	}
+}
+
+// ReloadConfig reads the config file – which the gateway was created from –
+// again, then applies the changes of the services, the healthcheck interval
+// and the disabled loggers. If the new config is invalid, it returns error
+// and the old config is kept.
+func (gw *Gateway) ReloadConfig() error {
+	if gw.configPath == "" {
+		return errNoConfigPath
+	}
+
+	conf, err := readConfigFile(gw.configPath)
+	if err != nil {
+		return err
+	}
+
+	if err := validateConfig(conf); err != nil {
+		return err
+	}
+
+	// Only the services and the splits of the previous config are removed,
+	// the ones registered in runtime – eg. by the system API – are kept.
+	// The services and the splits are swapped together.
+	diff, err := gw.serviceRegisty.applyServices(conf.Services, conf.TrafficSplits, gw.getConfigServiceNames(), gw.getConfigSplitNames())
+	if err != nil {
+		return err
+	}
+
+	if interval := getHealthCheckInterval(conf.HealthCheckInterval); interval != 0 {
+		gw.serviceRegisty.withHealthCheck(interval)
+	} else {
+		gw.serviceRegisty.withHealthCheck(defaultHealthCheckFreq)
+	}
+
+	gw.logger.setDisabled(getDisabledLoggers(conf.LoggerConfig))
+
+	gw.warnAboutStaticChanges(conf)
+	gw.config = conf
+
+	for _, name := range append(diff.added, diff.changed...) {
+		gw.checkServiceStatusAsync(name)
+	}
+
+	if diff.isEmpty() {
+		gw.logger.Info("[reload] config reloaded, no service changed")
+	} else {
+		gw.logger.Info(fmt.Sprintf("[reload] config reloaded – %s", diff))
+	}
+
+	return nil
+}
+
+// getConfigServiceNames returns the names of the services of the current config.
+func (gw *Gateway) getConfigServiceNames() []string {
+	if gw.config == nil {
+		return nil
+	}
+
+	names := make([]string, len(gw.config.Services))
+	for i, conf := range gw.config.Services {
+		names[i] = conf.Name
+	}
+
+	return names
+}
+
+// getConfigSplitNames returns the names of the split services of the current config.
+func (gw *Gateway) getConfigSplitNames() []string {
+	if gw.config == nil {
+		return nil
+	}
+
+	names := make([]string, len(gw.config.TrafficSplits))
+	for i, conf := range gw.config.TrafficSplits {
+		names[i] = conf.ServiceName
+	}
+
+	return names
+}
+
+// warnAboutStaticChanges logs a warning for every changed
+// option, which can not be applied without a restart.
+func (gw *Gateway) warnAboutStaticChanges(conf *GatewayConfig) {
+	if gw.config == nil {
+		return
+	}
+
+	static := map[string][2]any{
+		"address":              {gw.config.Address, conf.Address},
+		"secretKey":            {gw.config.SecretKey, conf.SecretKey},
+		"signatureMaxSkew":     {gw.config.SignatureMaxSkew, conf.SignatureMaxSkew},
+		"productionLevel":      {gw.config.ProductionLevel, conf.ProductionLevel},
+		"middlewaresEnabled":   {gw.config.MiddlewaresEnabled, conf.MiddlewaresEnabled},
+		"grpcProxy":            {gw.config.GrpcProxy, conf.GrpcProxy},
+		"jwt":                  {gw.config.JWT, conf.JWT},
+		"consumers":            {gw.config.Consumers, conf.Consumers},
+		"consumersFile":        {gw.config.ConsumersFile, conf.ConsumersFile},
+		"apiKeys":              {gw.config.APIKeys, conf.APIKeys},
+		"rateLimits":           {gw.config.RateLimits, conf.RateLimits},
+		"trustedProxies":       {gw.config.TrustedProxies, conf.TrustedProxies},
+		"rejectEncodedSlashes": {gw.config.RejectEncodedSlashes, conf.RejectEncodedSlashes},
+		"tls":                  {gw.config.TLS, conf.TLS},
+	}
+
+	for name, values := range static {
+		if !reflect.DeepEqual(values[0], values[1]) {
+			gw.logger.Warning(fmt.Sprintf("[reload] %s has changed, it is only applied after restart", name))
+		}
+	}
+}
+
+// reloadConfigAndLog reloads the config, and logs the error if there is any.
+func (gw *Gateway) reloadConfigAndLog() {
+	if err := gw.ReloadConfig(); err != nil {
+		gw.logger.Error(fmt.Sprintf("[reload] the config is rejected, keeping the old one: %v", err))
+	}
+}
+
+// watchConfig checks the modification time of the config file periodically,
+// and reloads the config if it has changed. It stops when the ctx is done.
+func (gw *Gateway) watchConfig(ctx context.Context) {
+	getModTime := func() time.Time {
+		info, err := os.Stat(gw.configPath)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+
+	var (
+		lastModTime = getModTime()
+		t           = time.NewTicker(gw.info.configWatchInterval)
+	)
+
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			modTime := getModTime()
+			if modTime.IsZero() || modTime.Equal(lastModTime) {
+				continue
+			}
+
+			lastModTime = modTime
+			gw.reloadConfigAndLog()
+		}
+	}
+}
+
+// validateConfig validates each service, traffic split, JWT, consumer, API key, rate
+// limit and trusted proxy, the TLS and the signature max skew of the given config, also
+// checks that the names and prefixes of the services are unique.
+// It returns the first error that occured.
+func validateConfig(conf *GatewayConfig) error {
+	var (
+		names    = make(map[string]struct{}, len(conf.Services))
+		services = make([]*service, len(conf.Services))
+	)
+
+	for i, sc := range conf.Services {
+		if err := validateService(sc); err != nil {
+			return fmt.Errorf("service %d: %w", i, err)
+		}
+
+		if _, exists := names[sc.Name]; exists {
+			return fmt.Errorf("service %s: %w", sc.Name, errServiceExists)
+		}
+
+		names[sc.Name] = struct{}{}
+		// Only the prefixes and hosts are needed to check the conflicts,
+		// so the service is not built – with its transport – here.
+		services[i] = &service{ServiceConfig: normalizeServiceConfig(sc)}
+	}
+
+	// The splits of the config may only refer to the services of the config.
+	exists := func(name string) bool {
+		_, ok := names[name]
+		return ok
+	}
+
+	for i, tc := range conf.TrafficSplits {
+		if err := validateTrafficSplit(tc, exists); err != nil {
+			return fmt.Errorf("traffic split %d: %w", i, err)
+		}
+	}
+
+	for i, jc := range conf.JWT {
+		if err := validateJWT(jc); err != nil {
+			return fmt.Errorf("jwt %d: %w", i, err)
+		}
+	}
+
+	if err := validateConsumers(conf.Consumers); err != nil {
+		return err
+	}
+
+	if conf.ConsumersFile != "" {
+		if _, err := readConsumersFile(conf.ConsumersFile); err != nil {
+			return err
+		}
+	}
+
+	for i, ac := range conf.APIKeys {
+		if err := validateAPIKey(ac); err != nil {
+			return fmt.Errorf("api key %d: %w", i, err)
+		}
+	}
+
+	for i, rc := range conf.RateLimits {
+		if err := validateRateLimit(rc); err != nil {
+			return fmt.Errorf("rate limit %d: %w", i, err)
+		}
+	}
+
+	if _, err := parseTrustedProxies(conf.TrustedProxies); err != nil {
+		return err
+	}
+
+	if err := validateListenerTLS(conf.TLS); err != nil {
+		return err
+	}
+
+	if conf.SignatureMaxSkew != "" {
+		if _, err := parseSignatureMaxSkew(conf.SignatureMaxSkew); err != nil {
+			return err
+		}
+	}
+
+	if conf.ConfigWatchInterval != "" {
+		if _, err := parseConfigWatchInterval(conf.ConfigWatchInterval); err != nil {
+			return err
+		}
+	}
+
+	_, _, err := buildTrees(services)
+
+	return err
+}
+
+// diffServices compares the currently registered services to the given configs.
+func diffServices(current []*service, next []*ServiceConfig) *serviceDiff {
+	var (
+		diff     = &serviceDiff{}
+		existing = make(map[string]*service, len(current))
+		kept     = make(map[string]struct{}, len(next))
+	)
+
+	for _, s := range current {
+		existing[s.Name] = s
+	}
+
+	for _, conf := range next {
+		kept[conf.Name] = struct{}{}
+
+		s, ok := existing[conf.Name]
+		if !ok {
+			diff.added = append(diff.added, conf.Name)
+			continue
+		}
+
+		// Comparing the normalized configs, so the
+		// defaults do not count as a change.
+		if !reflect.DeepEqual(s.ServiceConfig, normalizeServiceConfig(conf)) {
+			diff.changed = append(diff.changed, conf.Name)
+		}
+	}
+
+	for _, s := range current {
+		if _, ok := kept[s.Name]; !ok {
+			diff.removed = append(diff.removed, s.Name)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,317 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func getReloadTestConfigs() []*ServiceConfig {
+	return []*ServiceConfig{
+		{Protocol: "http", Name: "mock-name-1", Host: "localhost", Port: "3000", Prefix: "/foo"},
+		{Protocol: "http", Name: "mock-name-2", Host: "localhost", Port: "3010", Prefix: "/bar"},
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *GatewayConfig
+		err  error
+	}
+
+	tt := []testCase{
+		{
+			name: "the function returns error if a service is invalid",
+			conf: &GatewayConfig{
+				Services: []*ServiceConfig{{Name: "mock-name"}},
+			},
+			err: errEmptyHost,
+		},
+		{
+			name: "the function returns error if the names are not unique",
+			conf: &GatewayConfig{
+				Services: []*ServiceConfig{
+					{Protocol: "http", Name: "mock-name", Host: "localhost", Port: "3000", Prefix: "/foo"},
+					{Protocol: "http", Name: "mock-name", Host: "localhost", Port: "3000", Prefix: "/bar"},
+				},
+			},
+			err: errServiceExists,
+		},
+		{
+			name: "the function returns error if the prefixes are not unique",
+			conf: &GatewayConfig{
+				Services: []*ServiceConfig{
+					{Protocol: "http", Name: "mock-name-1", Host: "localhost", Port: "3000", Prefix: "/foo"},
+					{Protocol: "http", Name: "mock-name-2", Host: "localhost", Port: "3000", Prefix: "/foo"},
+				},
+			},
+			err: errServiceExists,
+		},
+		{
+			name: "the function returns error if a split refers to an unknown service",
+			conf: &GatewayConfig{
+				Services: getReloadTestConfigs(),
+				TrafficSplits: []*TrafficSplitConfig{
+					{ServiceName: "mock-name-1", Versions: []*SplitVersion{{ServiceName: "mock-name-3", Weight: 1}}},
+				},
+			},
+			err: ErrServiceNotExists,
+		},
+		{
+			name: "the function returns error if the signature max skew is invalid",
+			conf: &GatewayConfig{
+				Services:         getReloadTestConfigs(),
+				SignatureMaxSkew: "5",
+			},
+			err: errBadSignatureMaxSkew,
+		},
+		{
+			name: "the function returns error if the config watch interval is invalid",
+			conf: &GatewayConfig{
+				Services:            getReloadTestConfigs(),
+				ConfigWatchInterval: "10",
+			},
+			err: errBadConfigWatchInterval,
+		},
+		{
+			name: "the function returns error if the config watch interval is not positive",
+			conf: &GatewayConfig{
+				Services:            getReloadTestConfigs(),
+				ConfigWatchInterval: "0s",
+			},
+			err: errBadConfigWatchInterval,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &GatewayConfig{
+				Services:            getReloadTestConfigs(),
+				SignatureMaxSkew:    "1m30s",
+				ConfigWatchInterval: "10s",
+			},
+			err: nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateConfig(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestConfigWatchIntervalConfig(t *testing.T) {
+	type testCase struct {
+		name        string
+		interval    string
+		expInterval time.Duration
+	}
+
+	tt := []testCase{
+		{name: "the seconds are parsed", interval: "10s", expInterval: 10 * time.Second},
+		{name: "the compound duration is parsed", interval: "1m30s", expInterval: 90 * time.Second},
+		{name: "the duration without unit is ignored", interval: "10", expInterval: 0},
+		{name: "the negative duration is ignored", interval: "-1s", expInterval: 0},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			gw := New(getGatewayOptionFuncs(&GatewayConfig{ConfigWatchInterval: tc.interval})...)
+
+			if gw.info.configWatchInterval != tc.expInterval {
+				t.Errorf("expected interval: %v; got interval: %v\n", tc.expInterval, gw.info.configWatchInterval)
+			}
+		})
+	}
+}
+
+func TestApplyServices(t *testing.T) {
+	type testCase struct {
+		name     string
+		confs    []*ServiceConfig
+		diff     *serviceDiff
+		services int
+
+		// The services, whose instances are new, so their state is not known.
+		unknown []string
+	}
+
+	tt := []testCase{
+		{
+			name:     "nothing changes if the configs are the same",
+			confs:    getReloadTestConfigs(),
+			diff:     &serviceDiff{},
+			services: 3,
+		},
+		{
+			name: "the function returns the added, removed and changed services",
+			confs: []*ServiceConfig{
+				{Protocol: "http", Name: "mock-name-1", Host: "localhost", Port: "3001", Prefix: "/foo"},
+				{Protocol: "http", Name: "mock-name-3", Host: "localhost", Port: "3020", Prefix: "/baz"},
+			},
+			diff: &serviceDiff{
+				added:   []string{"mock-name-3"},
+				removed: []string{"mock-name-2"},
+				changed: []string{"mock-name-1"},
+			},
+			services: 3,
+			unknown:  []string{"mock-name-1", "mock-name-3"},
+		},
+		{
+			name: "the service registered in runtime is replaced by the config with the same name",
+			confs: append(getReloadTestConfigs(),
+				&ServiceConfig{Protocol: "http", Name: "mock-runtime", Host: "localhost", Port: "3031", Prefix: "/runtime"},
+			),
+			diff: &serviceDiff{
+				changed: []string{"mock-runtime"},
+			},
+			services: 3,
+			unknown:  []string{"mock-runtime"},
+		},
+		{
+			name: "the changed service keeps the state of its instances with the same address",
+			confs: []*ServiceConfig{
+				{Protocol: "http", Name: "mock-name-1", Host: "localhost", Port: "3000", Prefix: "/foo", TimeOutSec: 10},
+				{Protocol: "http", Name: "mock-name-2", Host: "localhost", Port: "3010", Prefix: "/bar"},
+			},
+			diff: &serviceDiff{
+				changed: []string{"mock-name-1"},
+			},
+			services: 3,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := newRegistry()
+
+			for _, conf := range getReloadTestConfigs() {
+				if err := reg.addService(conf); err != nil {
+					t.Fatalf("expected not to get error; but got: %v\n", err)
+				}
+			}
+
+			// The service, which is not managed by the config, is kept.
+			runtime := &ServiceConfig{Protocol: "http", Name: "mock-runtime", Host: "localhost", Port: "3030", Prefix: "/runtime"}
+			if err := reg.addService(runtime); err != nil {
+				t.Fatalf("expected not to get error; but got: %v\n", err)
+			}
+
+			reg.setServiceAvailable("mock-name-1")
+			reg.setServiceAvailable("mock-name-2")
+			reg.setServiceAvailable("mock-runtime")
+
+			managed := []string{"mock-name-1", "mock-name-2"}
+
+			diff, err := reg.applyServices(tc.confs, nil, managed, nil)
+			if err != nil {
+				t.Fatalf("expected not to get error; but got: %v\n", err)
+			}
+
+			if !reflect.DeepEqual(diff, tc.diff) {
+				t.Errorf("expected diff: %s; got diff: %s\n", tc.diff, diff)
+			}
+
+			services := reg.getAllServices()
+			if len(services) != tc.services {
+				t.Errorf("expected services: %d; got: %d\n", tc.services, len(services))
+			}
+
+			if s := reg.getServiceByName("mock-runtime"); s == nil {
+				t.Errorf("expected the service registered in runtime to be kept\n")
+			}
+
+			// The services must keep their state, unless their instances are new.
+			for _, s := range services {
+				if !includes(tc.unknown, s.Name) && s.getState() != StateAvailable {
+					t.Errorf("expected %s to keep its state; got state: %d\n", s.Name, s.getState())
+				}
+			}
+		})
+	}
+}
+
+func TestApplyServicesWithSplits(t *testing.T) {
+	reg := newRegistry()
+
+	for _, conf := range getReloadTestConfigs() {
+		if err := reg.addService(conf); err != nil {
+			t.Fatalf("expected not to get error; but got: %v\n", err)
+		}
+	}
+
+	var (
+		confs   = getReloadTestConfigs()[:1]
+		managed = []string{"mock-name-1", "mock-name-2"}
+		splits  = []*TrafficSplitConfig{
+			{ServiceName: "mock-name-1", Versions: []*SplitVersion{{ServiceName: "mock-name-2", Weight: 1}}},
+		}
+	)
+
+	// The split refers to the removed service, so nothing is changed.
+	if _, err := reg.applyServices(confs, splits, managed, nil); !errors.Is(err, ErrServiceNotExists) {
+		t.Fatalf("expected error: %v; got error: %v\n", ErrServiceNotExists, err)
+	}
+
+	if l := len(reg.getAllServices()); l != 2 {
+		t.Errorf("expected services: %d; got services: %d\n", 2, l)
+	}
+
+	// Without the removal, the services and the split are applied together.
+	if _, err := reg.applyServices(getReloadTestConfigs(), splits, managed, nil); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	if l := len(reg.getTrafficSplits()); l != 1 {
+		t.Errorf("expected splits: %d; got splits: %d\n", 1, l)
+	}
+}
+
+func TestReloadConfigKeepsRuntimeSplits(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		split = &TrafficSplitConfig{ServiceName: "mock-name-1", Versions: []*SplitVersion{{ServiceName: "mock-name-2", Weight: 1}}}
+	)
+
+	writeConfig := func(splits []*TrafficSplitConfig) string {
+		b, err := json.Marshal(&GatewayConfig{Services: getReloadTestConfigs(), TrafficSplits: splits})
+		if err != nil {
+			t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+		}
+
+		return writeTestFile(t, dir, "config.json", b)
+	}
+
+	gw, err := NewFromConfig(writeConfig([]*TrafficSplitConfig{split}))
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	// The split created by the API must survive the reload,
+	// while the one removed from the config must be removed.
+	runtimeSplit := &TrafficSplitConfig{ServiceName: "mock-name-2", Versions: []*SplitVersion{{ServiceName: "mock-name-1", Weight: 1}}}
+	if err := gw.SetTrafficSplit(runtimeSplit); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	writeConfig(nil)
+
+	if err := gw.ReloadConfig(); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	names := make([]string, 0)
+	for _, conf := range gw.serviceRegisty.getTrafficSplits() {
+		names = append(names, conf.ServiceName)
+	}
+	sort.Strings(names)
+
+	if expected := []string{"mock-name-2"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected splits: %v; got splits: %v\n", expected, names)
+	}
+}
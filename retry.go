@@ -0,0 +1,340 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// The header of the response, which tells how many times the request was retried.
+	retriesHeader = "X-Gateway-Retries"
+
+	retryAfterHeader = "Retry-After"
+
+	// The classes of errors, which can be retried.
+	RetryOnConnectFailure = "connect-failure"
+	RetryOnReset          = "reset"
+	RetryOnTimeout        = "timeout"
+
+	defaultRetryBaseBackoff   = 25 * time.Millisecond
+	defaultRetryMaxBackoff    = 250 * time.Millisecond
+	defaultRetryBudgetPercent = 20
+
+	// The retry budget is counted in windows of this length,
+	// with a minimum count of retries allowed in each, so
+	// the services with low traffic can also be retried.
+	retryBudgetWindow      = 10 * time.Second
+	minRetriesPerWindow    = 3
+	maxRetryBudgetPercent  = 100
+	minRetryableStatusCode = 100
+	maxRetryableStatusCode = 599
+)
+
+var (
+	retryErrorClasses = []string{RetryOnConnectFailure, RetryOnReset, RetryOnTimeout}
+
+	defaultRetryableStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+	// The methods which are retried by default.
+	idempotentMethods = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodOptions,
+		http.MethodTrace,
+		http.MethodPut,
+		http.MethodDelete,
+	}
+)
+
+// RetryPolicyConfig describes how the failed requests to a service are retried.
+// Since the body is sent again with each attempt, it is buffered for the retryable
+// requests. The bodies, which are too large to be buffered, are not retried.
+type RetryPolicyConfig struct {
+	// The count of attempts including the first one. With 1 – or less –
+	// there is no retry at all.
+	MaxAttempts int `json:"maxAttempts"`
+
+	// The timeout of each attempt – eg. "1s". By default only the timeout of the service applies.
+	PerTryTimeout string `json:"perTryTimeout"`
+
+	// The backoff before each retry is a random duration up to the base
+	// backoff doubled by each retry, but at most the max backoff.
+	BaseBackoff string `json:"baseBackoff"`
+	MaxBackoff  string `json:"maxBackoff"`
+
+	// The status codes of the responses, which are retried.
+	// By default these are 502, 503 and 504.
+	RetryableStatuses []int `json:"retryableStatuses"`
+
+	// The classes of errors, which are retried: connect-failure, reset
+	// and timeout. By default all of them are retried.
+	RetryOn []string `json:"retryOn"`
+
+	// The methods, which are retried. By default only the idempotent ones.
+	Methods []string `json:"methods"`
+
+	// The count of retries, as a percentage of the requests, which
+	// are allowed, so the retries can not overload the service.
+	// By default it is 20%.
+	BudgetPercent int `json:"budgetPercent"`
+}
+
+// retryPolicy is the parsed, ready to use form of RetryPolicyConfig.
+type retryPolicy struct {
+	maxAttempts   int
+	perTryTimeout time.Duration
+	baseBackoff   time.Duration
+	maxBackoff    time.Duration
+	statuses      []int
+	retryOn       []string
+	methods       []string
+	budgetPercent int
+
+	budget retryBudget
+}
+
+// newRetryPolicy creates the policy from the given – already validated – config.
+// It returns nil, if there is no config, or it does not allow any retry.
+func newRetryPolicy(conf *RetryPolicyConfig) *retryPolicy {
+	if conf == nil || conf.MaxAttempts <= 1 {
+		return nil
+	}
+
+	rp := &retryPolicy{
+		maxAttempts:   conf.MaxAttempts,
+		baseBackoff:   defaultRetryBaseBackoff,
+		maxBackoff:    defaultRetryMaxBackoff,
+		statuses:      defaultRetryableStatuses,
+		retryOn:       retryErrorClasses,
+		methods:       idempotentMethods,
+		budgetPercent: defaultRetryBudgetPercent,
+	}
+
+	if d, err := time.ParseDuration(conf.PerTryTimeout); err == nil {
+		rp.perTryTimeout = d
+	}
+
+	if d, err := time.ParseDuration(conf.BaseBackoff); err == nil {
+		rp.baseBackoff = d
+	}
+
+	if d, err := time.ParseDuration(conf.MaxBackoff); err == nil {
+		rp.maxBackoff = d
+	}
+
+	if rp.maxBackoff < rp.baseBackoff {
+		rp.maxBackoff = rp.baseBackoff
+	}
+
+	if len(conf.RetryableStatuses) > 0 {
+		rp.statuses = conf.RetryableStatuses
+	}
+
+	if len(conf.RetryOn) > 0 {
+		rp.retryOn = conf.RetryOn
+	}
+
+	if len(conf.Methods) > 0 {
+		rp.methods = make([]string, len(conf.Methods))
+
+		for i, m := range conf.Methods {
+			rp.methods[i] = strings.ToUpper(m)
+		}
+	}
+
+	if conf.BudgetPercent > 0 {
+		rp.budgetPercent = conf.BudgetPercent
+	}
+
+	return rp
+}
+
+// validateRetryPolicy validates the given config.
+// It returns the first error that occured.
+func validateRetryPolicy(conf *RetryPolicyConfig) error {
+	if conf == nil {
+		return nil
+	}
+
+	for _, d := range []string{conf.PerTryTimeout, conf.BaseBackoff, conf.MaxBackoff} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return errBadRetryDuration
+		}
+	}
+
+	for _, code := range conf.RetryableStatuses {
+		if code < minRetryableStatusCode || code > maxRetryableStatusCode {
+			return errBadRetryStatus
+		}
+	}
+
+	for _, class := range conf.RetryOn {
+		if !includes(retryErrorClasses, class) {
+			return errBadRetryOn
+		}
+	}
+
+	if conf.BudgetPercent < 0 || conf.BudgetPercent > maxRetryBudgetPercent {
+		return errBadRetryBudget
+	}
+
+	return nil
+}
+
+// getMaxAttempts returns the count of attempts allowed for the request
+// with the given method. If the body can not be sent again, it is 1.
+func (rp *retryPolicy) getMaxAttempts(method string, isReplayable bool) int {
+	if rp == nil || !isReplayable || !includes(rp.methods, method) {
+		return 1
+	}
+	return rp.maxAttempts
+}
+
+// getTimeout returns the timeout of one attempt, which is the
+// given timeout of the service, unless the per try timeout is less.
+func (rp *retryPolicy) getTimeout(def time.Duration) time.Duration {
+	if rp == nil || rp.perTryTimeout <= 0 || rp.perTryTimeout > def {
+		return def
+	}
+	return rp.perTryTimeout
+}
+
+// isRetryable tells if the attempt with the given result should be retried.
+func (rp *retryPolicy) isRetryable(res *http.Response, err error) bool {
+	if rp == nil {
+		return false
+	}
+
+	if err != nil {
+		class := getErrorClass(err)
+		return class != "" && includes(rp.retryOn, class)
+	}
+
+	return includes(rp.statuses, res.StatusCode)
+}
+
+// getBackoff returns the duration to wait before the given – 1 based – retry.
+func (rp *retryPolicy) getBackoff(retry int) time.Duration {
+	backoff := rp.baseBackoff << (retry - 1)
+	if backoff > rp.maxBackoff || backoff <= 0 {
+		backoff = rp.maxBackoff
+	}
+
+	// Full jitter, so the retries of concurrent requests are spread.
+	return randomDuration(backoff + 1)
+}
+
+// recordRequest counts the request for the retry budget.
+func (rp *retryPolicy) recordRequest() {
+	if rp == nil {
+		return
+	}
+	rp.budget.recordRequest(time.Now(), retryBudgetWindow)
+}
+
+// allowRetry tells if there is retry budget left, and takes one from it.
+func (rp *retryPolicy) allowRetry() bool {
+	return rp.budget.takeRetry(time.Now(), retryBudgetWindow, rp.budgetPercent)
+}
+
+// retryBudget counts the requests and retries of a service in fixed windows.
+type retryBudget struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	requests    int
+	retries     int
+}
+
+func (rb *retryBudget) rollLocked(now time.Time, window time.Duration) {
+	if now.Sub(rb.windowStart) >= window {
+		rb.windowStart = now
+		rb.requests = 0
+		rb.retries = 0
+	}
+}
+
+func (rb *retryBudget) recordRequest(now time.Time, window time.Duration) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.rollLocked(now, window)
+	rb.requests++
+}
+
+func (rb *retryBudget) takeRetry(now time.Time, window time.Duration, percent int) bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.rollLocked(now, window)
+
+	allowed := rb.requests * percent / 100
+	if allowed < minRetriesPerWindow {
+		allowed = minRetriesPerWindow
+	}
+
+	if rb.retries >= allowed {
+		return false
+	}
+
+	rb.retries++
+
+	return true
+}
+
+// getErrorClass returns the retry class of the given error,
+// or empty string if it is not a retryable one.
+func getErrorClass(err error) string {
+	// The cancel of the incoming request must not be retried.
+	if errors.Is(err, context.Canceled) {
+		return ""
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return RetryOnTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return RetryOnTimeout
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return RetryOnConnectFailure
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return RetryOnConnectFailure
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return RetryOnReset
+	}
+
+	return ""
+}
+
+// sleepWithContext waits for the given duration. It returns
+// false, if the ctx is done before the duration is over.
+func sleepWithContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,279 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestValidateRetryPolicy(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *RetryPolicyConfig
+		err  error
+	}
+
+	tt := []testCase{
+		{
+			name: "the function returns nil if there is no config",
+			conf: nil,
+			err:  nil,
+		},
+		{
+			name: "the function returns error if the per try timeout is invalid",
+			conf: &RetryPolicyConfig{MaxAttempts: 3, PerTryTimeout: "1"},
+			err:  errBadRetryDuration,
+		},
+		{
+			name: "the function returns error if a retryable status is invalid",
+			conf: &RetryPolicyConfig{MaxAttempts: 3, RetryableStatuses: []int{5000}},
+			err:  errBadRetryStatus,
+		},
+		{
+			name: "the function returns error if an error class is unknown",
+			conf: &RetryPolicyConfig{MaxAttempts: 3, RetryOn: []string{"everything"}},
+			err:  errBadRetryOn,
+		},
+		{
+			name: "the function returns error if the budget is above 100",
+			conf: &RetryPolicyConfig{MaxAttempts: 3, BudgetPercent: 150},
+			err:  errBadRetryBudget,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &RetryPolicyConfig{MaxAttempts: 3, PerTryTimeout: "1s", RetryableStatuses: []int{503}, RetryOn: []string{RetryOnReset}},
+			err:  nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateRetryPolicy(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestGetMaxAttempts(t *testing.T) {
+	type testCase struct {
+		name         string
+		conf         *RetryPolicyConfig
+		method       string
+		isReplayable bool
+		expected     int
+	}
+
+	tt := []testCase{
+		{
+			name:         "there is one attempt without policy",
+			conf:         nil,
+			method:       http.MethodGet,
+			isReplayable: true,
+			expected:     1,
+		},
+		{
+			name:         "the idempotent methods are retried by default",
+			conf:         &RetryPolicyConfig{MaxAttempts: 3},
+			method:       http.MethodGet,
+			isReplayable: true,
+			expected:     3,
+		},
+		{
+			name:         "the other methods are not retried by default",
+			conf:         &RetryPolicyConfig{MaxAttempts: 3},
+			method:       http.MethodPost,
+			isReplayable: true,
+			expected:     1,
+		},
+		{
+			name:         "the given methods are retried",
+			conf:         &RetryPolicyConfig{MaxAttempts: 3, Methods: []string{"post"}},
+			method:       http.MethodPost,
+			isReplayable: true,
+			expected:     3,
+		},
+		{
+			name:         "the request whose body can not be replayed is not retried",
+			conf:         &RetryPolicyConfig{MaxAttempts: 3},
+			method:       http.MethodPut,
+			isReplayable: false,
+			expected:     1,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rp := newRetryPolicy(tc.conf)
+
+			if got := rp.getMaxAttempts(tc.method, tc.isReplayable); got != tc.expected {
+				t.Errorf("expected attempts: %d; got attempts: %d\n", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestGetErrorClass(t *testing.T) {
+	type testCase struct {
+		name     string
+		err      error
+		expected string
+	}
+
+	tt := []testCase{
+		{
+			name:     "the refused dial is a connect failure",
+			err:      &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED},
+			expected: RetryOnConnectFailure,
+		},
+		{
+			name:     "the reset connection is a reset",
+			err:      &net.OpError{Op: "read", Err: syscall.ECONNRESET},
+			expected: RetryOnReset,
+		},
+		{
+			name:     "the unexpected end of the response is a reset",
+			err:      io.ErrUnexpectedEOF,
+			expected: RetryOnReset,
+		},
+		{
+			name:     "the exceeded deadline is a timeout",
+			err:      context.DeadlineExceeded,
+			expected: RetryOnTimeout,
+		},
+		{
+			name:     "the canceled request is not retryable",
+			err:      context.Canceled,
+			expected: "",
+		},
+		{
+			name:     "the unknown error is not retryable",
+			err:      errors.New("mock-error"),
+			expected: "",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := getErrorClass(tc.err); got != tc.expected {
+				t.Errorf("expected class: %s; got class: %s\n", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	var (
+		rb  = &retryBudget{}
+		now = time.Now()
+	)
+
+	for i := 0; i < 20; i++ {
+		rb.recordRequest(now, retryBudgetWindow)
+	}
+
+	// 20% of 20 requests is 4 retries.
+	for i := 0; i < 4; i++ {
+		if !rb.takeRetry(now, retryBudgetWindow, 20) {
+			t.Fatalf("expected retry %d to be allowed\n", i+1)
+		}
+	}
+
+	if rb.takeRetry(now, retryBudgetWindow, 20) {
+		t.Errorf("expected the retry over the budget not to be allowed\n")
+	}
+
+	// In the new window the minimum count of retries is allowed.
+	if !rb.takeRetry(now.Add(retryBudgetWindow), retryBudgetWindow, 20) {
+		t.Errorf("expected the retry in the new window to be allowed\n")
+	}
+}
+
+func TestHandleRetries(t *testing.T) {
+	type testCase struct {
+		name        string
+		method      string
+		failures    int32
+		expCode     int
+		expRetries  string
+		expRequests int32
+	}
+
+	tt := []testCase{
+		{
+			name:        "the failed idempotent request is retried until it succeeds",
+			method:      http.MethodGet,
+			failures:    2,
+			expCode:     http.StatusOK,
+			expRetries:  "2",
+			expRequests: 3,
+		},
+		{
+			name:        "the request is retried at most by the max attempts",
+			method:      http.MethodGet,
+			failures:    5,
+			expCode:     http.StatusServiceUnavailable,
+			expRetries:  "2",
+			expRequests: 3,
+		},
+		{
+			name:        "the non idempotent request is not retried",
+			method:      http.MethodPost,
+			failures:    1,
+			expCode:     http.StatusServiceUnavailable,
+			expRetries:  "",
+			expRequests: 1,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests int32
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if body, _ := io.ReadAll(r.Body); r.Method == http.MethodPost && string(body) != "mock-body" {
+					t.Errorf("expected body: %s; got body: %s\n", "mock-body", body)
+				}
+
+				if atomic.AddInt32(&requests, 1) <= tc.failures {
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			s := newTestService(t, srv, &ServiceConfig{
+				Name:             "mock-name",
+				Prefix:           "/api/mock",
+				Retry:            &RetryPolicyConfig{MaxAttempts: 3, BaseBackoff: "1ms", MaxBackoff: "2ms"},
+				OutlierDetection: &OutlierDetectionConfig{Disabled: true},
+			})
+
+			ctx, rec := newTestContext(httptest.NewRequest(tc.method, "/api/mock/foo", strings.NewReader("mock-body")))
+
+			s.Handle(ctx)
+			ctx.WriteToResponseNow()
+
+			if rec.Code != tc.expCode {
+				t.Errorf("expected status code: %d; got status code: %d\n", tc.expCode, rec.Code)
+			}
+
+			if got := rec.Header().Get(retriesHeader); got != tc.expRetries {
+				t.Errorf("expected retries: %s; got retries: %s\n", tc.expRetries, got)
+			}
+
+			if got := atomic.LoadInt32(&requests); got != tc.expRequests {
+				t.Errorf("expected requests: %d; got requests: %d\n", tc.expRequests, got)
+			}
+		})
+	}
+}
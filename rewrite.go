@@ -0,0 +1,183 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	locationHeader = "Location"
+)
+
+// RewriteConfig describes how the path of the incoming
+// request is rewritten before it is sent to the service.
+type RewriteConfig struct {
+	// If it is true, the prefix of the service is stripped
+	// from the path, eg. /api/test/foo becomes /foo.
+	StripPrefix bool `json:"stripPrefix"`
+
+	// If it is given, the prefix of the service is replaced
+	// by it, eg. /api/test/foo becomes /v1/foo.
+	ReplacePrefix string `json:"replacePrefix"`
+
+	// Regex based rules, which are applied after the prefix is handled.
+	// Only the first matching rule is applied.
+	Rules []*RewriteRule `json:"rules"`
+}
+
+// RewriteRule rewrites the paths matching the Pattern to the Replacement,
+// which can refer to the capture groups of the pattern, eg. $1 or ${name}.
+type RewriteRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+type rewriteRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+type rewriter struct {
+	isPrefixReplaced bool
+	basePath         string
+	rules            []*rewriteRule
+}
+
+// newRewriter creates a rewriter based on the given config.
+// If there is nothing to rewrite, it returns nil.
+// Rules with invalid pattern are skipped, since the
+// config must be validated beforehand.
+func newRewriter(conf *RewriteConfig) *rewriter {
+	if conf == nil {
+		return nil
+	}
+
+	rw := &rewriter{
+		isPrefixReplaced: conf.StripPrefix || conf.ReplacePrefix != "",
+		basePath:         strings.TrimSuffix(conf.ReplacePrefix, "/"),
+		rules:            make([]*rewriteRule, 0, len(conf.Rules)),
+	}
+
+	for _, r := range conf.Rules {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			continue
+		}
+
+		rw.rules = append(rw.rules, &rewriteRule{
+			pattern:     pattern,
+			replacement: r.Replacement,
+		})
+	}
+
+	if !rw.isPrefixReplaced && len(rw.rules) == 0 {
+		return nil
+	}
+
+	return rw
+}
+
+// validateRewrite validates the given rewrite config.
+func validateRewrite(conf *RewriteConfig) error {
+	if conf == nil {
+		return nil
+	}
+
+	if conf.ReplacePrefix != "" && conf.ReplacePrefix[0] != slash {
+		return errBadReplacePrefix
+	}
+
+	for _, r := range conf.Rules {
+		if r == nil {
+			return errBadRewriteRule
+		}
+
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("%w: %v", errBadRewriteRule, err)
+		}
+	}
+
+	return nil
+}
+
+// rewrite returns the rewritten form of the given url,
+// which was matched by the given prefix. The query part
+// of the url is kept untouched.
+func (rw *rewriter) rewrite(prefix string, u string) string {
+	if rw == nil {
+		return u
+	}
+
+	path, query := splitQuery(u)
+
+	if rw.isPrefixReplaced && strings.HasPrefix(path, prefix) {
+		path = rw.basePath + path[len(prefix):]
+	}
+
+	for _, r := range rw.rules {
+		if r.pattern.MatchString(path) {
+			path = r.pattern.ReplaceAllString(path, r.replacement)
+			break
+		}
+	}
+
+	if path == "" || path[0] != slash {
+		path = string(slash) + path
+	}
+
+	return path + query
+}
+
+// reverseLocation maps the Location header of the given
+// response header back to the URL space of the gateway.
+// Absolute URLs pointing to one of the given addresses are
+// made relative, then the prefix handling is reversed.
+// The regex based rules can not be reversed.
+func (rw *rewriter) reverseLocation(prefix string, header http.Header, addresses []string) {
+	location := header.Get(locationHeader)
+	if location == "" {
+		return
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+
+	if u.IsAbs() {
+		// Redirecting to somewhere else, must not touch it.
+		if !includes(addresses, u.Host) {
+			return
+		}
+
+		u.Scheme = ""
+		u.Host = ""
+		u.User = nil
+	}
+
+	if rw != nil && rw.isPrefixReplaced {
+		switch {
+		case strings.HasPrefix(u.Path, rw.basePath+string(slash)):
+			u.Path = prefix + u.Path[len(rw.basePath):]
+			u.RawPath = ""
+		case u.Path != "" && u.Path == rw.basePath:
+			u.Path = prefix
+			u.RawPath = ""
+		}
+	}
+
+	header.Set(locationHeader, u.String())
+}
+
+// splitQuery splits the given url into its path and query part.
+// The query part contains the leading question mark.
+func splitQuery(u string) (string, string) {
+	idx := strings.IndexByte(u, '?')
+	if idx < 0 {
+		return u, ""
+	}
+	return u[:idx], u[idx:]
+}
@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestValidateRewrite(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *RewriteConfig
+		err  error
+	}
+
+	tt := []testCase{
+		{
+			name: "the function returns nil if there is no config",
+			conf: nil,
+			err:  nil,
+		},
+		{
+			name: "the function returns error if the replace prefix is not started with slash",
+			conf: &RewriteConfig{ReplacePrefix: "v1"},
+			err:  errBadReplacePrefix,
+		},
+		{
+			name: "the function returns error if the pattern of a rule is invalid",
+			conf: &RewriteConfig{Rules: []*RewriteRule{{Pattern: "(foo"}}},
+			err:  errBadRewriteRule,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &RewriteConfig{ReplacePrefix: "/v1", Rules: []*RewriteRule{{Pattern: "^/users/(\\d+)$", Replacement: "/user?id=$1"}}},
+			err:  nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateRewrite(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	type testCase struct {
+		name     string
+		conf     *RewriteConfig
+		input    string
+		expected string
+	}
+
+	const prefix = "/api/test"
+
+	tt := []testCase{
+		{
+			name:     "the url is untouched if there is no config",
+			conf:     nil,
+			input:    "/api/test/foo?bar=baz",
+			expected: "/api/test/foo?bar=baz",
+		},
+		{
+			name:     "the prefix is stripped and the query is kept",
+			conf:     &RewriteConfig{StripPrefix: true},
+			input:    "/api/test/foo?bar=baz",
+			expected: "/foo?bar=baz",
+		},
+		{
+			name:     "the stripped url is never empty",
+			conf:     &RewriteConfig{StripPrefix: true},
+			input:    "/api/test?bar=baz",
+			expected: "/?bar=baz",
+		},
+		{
+			name:     "the prefix is replaced",
+			conf:     &RewriteConfig{ReplacePrefix: "/v1/"},
+			input:    "/api/test/foo",
+			expected: "/v1/foo",
+		},
+		{
+			name:     "the first matching rule is applied with the capture groups",
+			conf:     &RewriteConfig{StripPrefix: true, Rules: []*RewriteRule{{Pattern: "^/bar$", Replacement: "/baz"}, {Pattern: "^/users/(\\d+)$", Replacement: "/user/$1/profile"}, {Pattern: ".*", Replacement: "/never"}}},
+			input:    "/api/test/users/42?full=1",
+			expected: "/user/42/profile?full=1",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := newRewriter(tc.conf)
+
+			if got := rw.rewrite(prefix, tc.input); got != tc.expected {
+				t.Errorf("expected url: %s; got url: %s\n", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestReverseLocation(t *testing.T) {
+	type testCase struct {
+		name     string
+		conf     *RewriteConfig
+		location string
+		expected string
+	}
+
+	const prefix = "/api/test"
+
+	var addresses = []string{"localhost:3001", "localhost:3002"}
+
+	tt := []testCase{
+		{
+			name:     "the absolute location of an instance is made relative",
+			conf:     nil,
+			location: "http://localhost:3002/api/test/foo?bar=baz",
+			expected: "/api/test/foo?bar=baz",
+		},
+		{
+			name:     "the absolute location of other hosts is untouched",
+			conf:     &RewriteConfig{StripPrefix: true},
+			location: "https://example.com/foo",
+			expected: "https://example.com/foo",
+		},
+		{
+			name:     "the stripped prefix is added back",
+			conf:     &RewriteConfig{StripPrefix: true},
+			location: "http://localhost:3001/foo",
+			expected: "/api/test/foo",
+		},
+		{
+			name:     "the replaced prefix is changed back",
+			conf:     &RewriteConfig{ReplacePrefix: "/v1"},
+			location: "/v1/foo?bar=baz",
+			expected: "/api/test/foo?bar=baz",
+		},
+		{
+			name:     "the location outside of the replaced prefix is untouched",
+			conf:     &RewriteConfig{ReplacePrefix: "/v1"},
+			location: "/v2/foo",
+			expected: "/v2/foo",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				rw     = newRewriter(tc.conf)
+				header = http.Header{}
+			)
+
+			header.Set(locationHeader, tc.location)
+
+			rw.reverseLocation(prefix, header, addresses)
+
+			if got := header.Get(locationHeader); got != tc.expected {
+				t.Errorf("expected location: %s; got location: %s\n", tc.expected, got)
+			}
+		})
+	}
+}
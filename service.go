@@ -3,14 +3,14 @@ package gateway
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
-
-	"github.com/balazskvancz/gorouter"
 )
 
 type serviceState uint8
@@ -59,6 +59,11 @@ type ServiceConfig struct {
 	// The unique prefix which identified a URL
 	Prefix string `json:"prefix"`
 
+	// The hosts – eg. api.example.com or *.example.com – which the service
+	// is bound to. The prefix only has to be unique amongst the services
+	// of the same host. If it is empty, the service serves every host.
+	Hosts []string `json:"hosts"`
+
 	// Which protocol is used to call the service.
 	// Only http and https are supported.
 	Protocol string `json:"protocol"`
@@ -67,11 +72,60 @@ type ServiceConfig struct {
 	Host string `json:"host"`
 	Port string `json:"port"`
 
+	// The upstream instances of the service. If it is empty,
+	// then the Host and Port above is the only instance.
+	Instances []*InstanceConfig `json:"instances"`
+
+	// The name of the load balancing strategy, which picks
+	// the instance for each request. By default it is round-robin.
+	LoadBalancer string `json:"loadBalancer"`
+
 	// How many seconds it should wait before timeout.
 	TimeOutSec int `json:"timeOutSec"`
 
 	// The url to call for healtcheck.
 	StatusPath string `json:"statusPath"`
+
+	// The detailed settings of the healthcheck. See the type def.
+	HealthCheck *HealthCheckConfig `json:"healthCheck"`
+
+	// The passive healthcheck based on the proxied requests. See the type def.
+	OutlierDetection *OutlierDetectionConfig `json:"outlierDetection"`
+
+	// The policy of retrying the failed requests. See the type def.
+	Retry *RetryPolicyConfig `json:"retry"`
+
+	// The responses of unknown length – eg. Server-Sent Events – are streamed to
+	// the client. Instead of the timeout of the service, they are cut, if the
+	// service does not send anything for this long – eg. "5m". By default it is 1 minute.
+	StreamIdleTimeout string `json:"streamIdleTimeout"`
+
+	// The maximum size of the request body in bytes. The requests with
+	// larger bodies are rejected with HTTP 413. By default there is no limit.
+	MaxBodySize int64 `json:"maxBodySize"`
+
+	// The circuit breaker, which stops sending requests to a failing service
+	// for a while. See the type def.
+	CircuitBreaker *CircuitBreakerConfig `json:"circuitBreaker"`
+
+	// The limit of the requests served at the same time. See the type def.
+	Bulkhead *BulkheadConfig `json:"bulkhead"`
+
+	// The settings of the connections to the instances. See the type def.
+	Transport *TransportConfig `json:"transport"`
+
+	// The TLS settings of the connections to the instances, if the protocol is https.
+	TLS *UpstreamTLSConfig `json:"tls"`
+
+	// The settings of the upgraded – eg. WebSocket – connections. See the type def.
+	WebSocket *WebSocketConfig `json:"webSocket"`
+
+	// The client certificates verified by the Gateway, which may access the service.
+	// By default every client may access it. See the type def.
+	ClientCert *ClientCertPolicyConfig `json:"clientCert"`
+
+	// The rules of rewriting the path, before it is sent to the service.
+	Rewrite *RewriteConfig `json:"rewrite"`
 }
 
 type Service interface {
@@ -88,8 +142,25 @@ type Service interface {
 type service struct {
 	*ServiceConfig
 
-	state      serviceState
-	clientPool sync.Pool
+	instances   []*instance
+	balancer    LoadBalancer
+	rewriter    *rewriter
+	healthCheck *healthCheck
+	outlier     *outlierDetection
+	retry       *retryPolicy
+	breaker     *circuitBreaker
+	bulkhead    *bulkhead
+	tunnels     *webSocketTunnels
+	clientCert  *clientCertPolicy
+
+	// The transport shared by the instances, so the connections are reused.
+	transport   http.RoundTripper
+	upstreamTLS *upstreamTLS
+
+	timeout           time.Duration
+	streamIdleTimeout time.Duration
+
+	lastCheck checkResult
 }
 
 var _ Service = (*service)(nil)
@@ -102,38 +173,243 @@ func (s *service) Handle(ctx Context) {
 		return
 	}
 
-	if s.state != StateAvailable {
+	// Without an allowed client certificate, nothing is sent to the service.
+	if !s.authorizeClientCert(ctx) {
+		return
+	}
+
+	var (
+		prefix = s.getMatchedPrefix(ctx)
+		url    = s.rewriter.rewrite(prefix, ctx.GetUrl())
+	)
+
+	// The upgraded connections live long, so they are limited by
+	// their own max count, instead of the bulkhead of the service.
+	if isUpgradeRequest(ctx.GetRequest()) {
+		s.handleUpgrade(ctx, prefix, url)
+
+		return
+	}
+
+	// The body, which is known to be too large, is rejected before
+	// anything else. The others are checked, while they are read.
+	if s.MaxBodySize > 0 && ctx.GetRequest().ContentLength > s.MaxBodySize {
+		s.sendBodyTooLarge(ctx)
+
+		return
+	}
+
+	// The slot is taken before asking the breaker, since every
+	// request allowed by the breaker must record its result.
+	if err := s.bulkhead.acquire(ctx.GetRequest().Context()); err != nil {
+		s.sendBulkheadFull(ctx, err)
+
+		return
+	}
+	defer s.bulkhead.release()
+
+	// While the circuit breaker is open, the request is rejected right away.
+	if !s.breaker.allow(time.Now()) {
+		s.sendCircuitOpen(ctx)
+
+		return
+	}
+
+	start := time.Now()
+
+	res, retries, att, err := s.send(ctx, prefix, url)
+	defer att.done()
+
+	isTooLarge := isBodyTooLarge(err)
+
+	s.breaker.record(isFailedResponse(ctx.GetRequest(), res, err) && !isTooLarge, time.Since(start), time.Now())
+
+	if isTooLarge {
+		s.sendBodyTooLarge(ctx)
+
+		return
+	}
+
+	if errors.Is(err, errServiceNotAvailable) {
 		ctx.SetStatusCode(http.StatusServiceUnavailable)
 
 		return
 	}
 
-	cl := s.clientPool.Get().(httpClient)
-	defer s.clientPool.Put(cl)
+	if err != nil {
+		ctx.Error("[Handle]: %v", err)
+
+		ctx.SendInternalServerError()
+
+		return
+	}
+	defer res.Body.Close()
+
+	if retries > 0 {
+		res.Header.Set(retriesHeader, strconv.Itoa(retries))
+	}
 
-	// If the body of the incoming request is a formData
-	// then the original body reader must be used instead of
-	// the already read body, which is []byte.
-	var body io.Reader = func() io.Reader {
-		if strings.Contains(ctx.GetContentType(), gorouter.MultiPartFormContentType) {
-			return ctx.GetRequest().Body
+	removeHopByHopHeaders(res.Header)
+
+	s.rewriter.reverseLocation(prefix, res.Header, s.getAddresses())
+
+	// The streams are sent as they arrive, if the writer
+	// of the request can be used directly.
+	if isStreamingResponse(res) {
+		if sw := getStreamWriter(ctx); sw != nil {
+			s.stream(ctx, sw, res, att)
+
+			return
 		}
+	}
 
-		return bytes.NewReader(ctx.GetBody())
-	}()
+	pipeResponse(ctx, res)
+}
+
+// sendBodyTooLarge sends HTTP 413, if the body is above the limit of the service.
+func (s *service) sendBodyTooLarge(ctx Context) {
+	ctx.SendRaw([]byte(http.StatusText(http.StatusRequestEntityTooLarge)), http.StatusRequestEntityTooLarge, http.Header{})
+}
+
+// sendCircuitOpen sends HTTP 503, with the time left until the breaker lets the probes through.
+func (s *service) sendCircuitOpen(ctx Context) {
+	header := http.Header{}
+	header.Set(retryAfterHeader, strconv.Itoa(ceilSeconds(s.breaker.getRetryAfter(time.Now()))))
+
+	ctx.SendRaw([]byte(http.StatusText(http.StatusServiceUnavailable)), http.StatusServiceUnavailable, header)
+}
+
+// sendBulkheadFull sends HTTP 503, if the service is saturated. If the client
+// gave up while waiting in the queue, there is no one to send it to.
+func (s *service) sendBulkheadFull(ctx Context, err error) {
+	if !errors.Is(err, errBulkheadFull) {
+		return
+	}
+
+	ctx.Warning("[Handle]: service %s is saturated, the request is rejected", s.Name)
 
-	res, err := cl.pipe(ctx.GetRequestMethod(), ctx.GetUrl(), ctx.GetRequestHeaders(), body)
+	header := http.Header{}
+	header.Set(retryAfterHeader, strconv.Itoa(ceilSeconds(s.bulkhead.getRetryAfter())))
+
+	ctx.SendRaw([]byte(http.StatusText(http.StatusServiceUnavailable)), http.StatusServiceUnavailable, header)
+}
+
+// send proxies the request to an instance of the service. The failed attempts
+// are retried – each on the next picked instance – by the retry policy of the
+// service. Besides the response, it returns the count of retries and the
+// last attempt, which must be done once the response is consumed.
+func (s *service) send(ctx Context, prefix string, url string) (*http.Response, int, *attempt, error) {
+	var (
+		req    = ctx.GetRequest()
+		method = ctx.GetRequestMethod()
+		header = getForwardHeader(req, prefix)
+	)
+
+	// The body is streamed to the instance, unless it must be sent again
+	// by a retry. In that case it is buffered – if it is not too large.
+	body, err := newRequestBody(req, s.MaxBodySize, s.retry.getMaxAttempts(method, true) > 1)
 	if err != nil {
-		s.setState(StateUnknown)
+		return nil, 0, nil, err
+	}
 
-		ctx.Error("[Handle]: %v", err)
+	maxAttempts := s.retry.getMaxAttempts(method, body.isReplayable())
 
-		ctx.SendInternalServerError()
+	s.retry.recordRequest()
+
+	for retries := 0; ; retries++ {
+		inst := s.nextInstance()
+		if inst == nil {
+			body.release()
+			return nil, retries, nil, errServiceNotAvailable
+		}
+
+		tryCtx, att := newAttempt(req.Context(), s.retry.getTimeout(s.timeout))
+
+		inst.acquire()
+		att.release = inst.release
+
+		res, err := func() (*http.Response, error) {
+			cl := inst.getClient()
+			defer inst.putClient(cl)
+
+			return cl.pipe(tryCtx, method, url, header, body.getReader(), body.contentLength)
+		}()
+
+		err = att.wrapErr(err)
+
+		// The last attempt releases the body too, once its response is consumed.
+		last := func() *attempt {
+			att.release = func() {
+				inst.release()
+				body.release()
+			}
+			return att
+		}
+
+		// The body above the limit is the fault of the client, not the instance.
+		if isBodyTooLarge(err) {
+			return nil, retries, last(), err
+		}
+
+		s.recordOutcome(ctx, inst, res, err)
+
+		isLast := retries+1 >= maxAttempts || req.Context().Err() != nil
+
+		if isLast || !s.retry.isRetryable(res, err) || !s.retry.allowRetry() {
+			if retries > 0 {
+				ctx.Info("[Handle]: request to service %s was retried %d times", s.Name, retries)
+			}
+			return res, retries, last(), err
+		}
+
+		if res != nil {
+			res.Body.Close()
+		}
+		att.done()
+
+		if !sleepWithContext(req.Context(), s.retry.getBackoff(retries+1)) {
+			body.release()
+			return nil, retries, nil, req.Context().Err()
+		}
+	}
+}
+
+// isFailedResponse tells if the proxied request failed, by a connection
+// error or by a 5xx response. The requests canceled by their clients
+// are not failed, so they can not open the circuit for everyone.
+func isFailedResponse(r *http.Request, res *http.Response, err error) bool {
+	if isCanceledByClient(r, err) {
+		return false
+	}
 
+	return err != nil || res == nil || res.StatusCode >= http.StatusInternalServerError
+}
+
+// isCanceledByClient tells if the proxied request failed, because its client
+// canceled it – eg. by disconnecting, or by aborting the upload –, which is
+// not the fault of the instance.
+func isCanceledByClient(r *http.Request, err error) bool {
+	var bodyErr *clientBodyError
+
+	return err != nil && (errors.Is(err, context.Canceled) || errors.As(err, &bodyErr) || r.Context().Err() != nil)
+}
+
+// recordOutcome records the result of the proxied request for the outlier
+// detection, and logs the ejection. The requests canceled by their clients
+// are not recorded, so the impatient clients can not eject the instance.
+func (s *service) recordOutcome(ctx Context, inst *instance, res *http.Response, err error) {
+	if isCanceledByClient(ctx.GetRequest(), err) {
 		return
 	}
 
-	ctx.Pipe(res)
+	var statusCode int
+	if res != nil {
+		statusCode = res.StatusCode
+	}
+
+	if inst.recordOutcome(s.outlier, statusCode, err) {
+		ctx.Warning("[Handle]: instance %s of service %s is ejected", inst.GetAddress(), s.Name)
+	}
 }
 
 // Get sends a HTTP GET request to the given service.
@@ -166,14 +442,37 @@ func (s *service) Delete(url string, header ...http.Header) (*http.Response, err
 	return s.doRequest(http.MethodDelete, url, nil, header...)
 }
 
-// GetAddressWithProtocol returns the address of the service with the protocol in it.
+// GetAddressWithProtocol returns the address of the service's
+// first instance with the protocol in it.
 func (s *service) GetAddressWithProtocol() string {
-	return fmt.Sprintf("%s://%s:%s", s.Protocol, s.Host, s.Port)
+	return s.instances[0].getAddressWithProtocol()
 }
 
-// GetAddress simply returns the address of the service in the form HOST:PORT.
+// GetAddress simply returns the address of the service's
+// first instance in the form HOST:PORT.
 func (s *service) GetAddress() string {
-	return fmt.Sprintf("%s:%s", s.Host, s.Port)
+	return s.instances[0].GetAddress()
+}
+
+// getMatchedPrefix returns the prefix which matched the url of the request.
+// It differs from the service's own prefix, if the request is
+// routed to this service as a version of an other – split – service.
+func (s *service) getMatchedPrefix(ctx Context) string {
+	if prefix, ok := ctx.GetBindedValue(matchedPrefixKey).(string); ok {
+		return prefix
+	}
+	return s.Prefix
+}
+
+// getAddresses returns the addresses of all the instances.
+func (s *service) getAddresses() []string {
+	addresses := make([]string, len(s.instances))
+
+	for i, inst := range s.instances {
+		addresses[i] = inst.GetAddress()
+	}
+
+	return addresses
 }
 
 // GetConfig returns the actual config of the given service.
@@ -185,53 +484,136 @@ func (s *service) doRequest(method string, url string, body io.Reader, header ..
 	if s.ServiceType != serviceRESTType {
 		return nil, fmt.Errorf("[%s]: is not a REST type service, cant perform HTTP %s", s.Name, method)
 	}
-	if s.state != StateAvailable {
+
+	inst := s.nextInstance()
+	if inst == nil {
 		return nil, errServiceNotAvailable
 	}
 
-	cl := s.clientPool.Get().(httpClient)
-	defer s.clientPool.Put(cl)
+	inst.acquire()
+	defer inst.release()
+
+	cl := inst.getClient()
+	defer inst.putClient(cl)
 
 	return cl.doRequest(method, url, body, header...)
 }
 
-func (s *service) checkStatus() error {
-	// Little hack for now. We perform the healthcheck only for REST services.
-	if s.ServiceType != serviceRESTType {
+// nextInstance returns the instance – picked by the load balancer –
+// which should serve the next request. If there is no available
+// instance at all, it returns nil.
+func (s *service) nextInstance() *instance {
+	available := make([]Instance, 0, len(s.instances))
+
+	for _, inst := range s.instances {
+		if inst.isAvailable() {
+			available = append(available, inst)
+		}
+	}
+
+	if len(available) == 0 {
 		return nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeOutDur)
-	defer cancel()
+	inst, ok := s.balancer.Next(available).(*instance)
+	if !ok {
+		return nil
+	}
 
-	cl := s.clientPool.Get().(httpClient)
-	defer s.clientPool.Put(cl)
+	return inst
+}
 
-	url := s.GetAddressWithProtocol() + s.StatusPath
+// checkStatus performs the healthcheck on each instance of the service.
+// It returns the first error that occured.
+func (s *service) checkStatus() error {
+	var (
+		firstErr error
+		start    = time.Now()
+	)
+
+	for _, inst := range s.instances {
+		if err := inst.checkStatus(s.healthCheck); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("instance %s: %w", inst.GetAddress(), err)
+		}
+	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		s.setState(StateUnknown)
-		return err
+	s.lastCheck.set(start, time.Since(start), firstErr)
+
+	return firstErr
+}
+
+// getHealthCheckInterval returns the interval of the healthcheck
+// of the service, or the given default, if it is not set.
+func (s *service) getHealthCheckInterval(def time.Duration) time.Duration {
+	if s.healthCheck.interval > 0 {
+		return s.healthCheck.interval
 	}
+	return def
+}
 
-	res, err := cl.Do(req)
-	if err != nil {
-		s.setState(StateRefused)
-		return err
+// getNextCheckDelay returns the delay of the next healthcheck. It is the given
+// interval, unless an ejection is over sooner, since the ejected instances
+// are re-admitted by the healthcheck right after their ejection time.
+func (s *service) getNextCheckDelay(interval time.Duration) time.Duration {
+	delay := interval
+
+	for _, inst := range s.instances {
+		until, ok := inst.outlier.getEjectedUntil()
+		if !ok {
+			continue
+		}
+
+		// The ones whose ejection is already over are probed by the interval.
+		if d := time.Until(until); d > 0 && d < delay {
+			delay = d
+		}
 	}
 
-	if res.StatusCode != http.StatusOK {
-		s.setState(StateRefused)
-		return nil
+	return delay
+}
+
+// close closes the open tunnels, then releases
+// the resources held by the instances of the service.
+func (s *service) close() {
+	s.tunnels.closeAll()
+	s.release()
+}
+
+// release releases the resources held by the instances
+// of the service, but leaves the open tunnels alone.
+func (s *service) release() {
+	for _, inst := range s.instances {
+		inst.close()
 	}
 
-	s.setState(StateAvailable)
-	return nil
+	closeIdleConnections(s.transport)
+}
+
+// getState returns the aggregated state of the instances. The service
+// is available as long as there is at least one available instance.
+func (s *service) getState() serviceState {
+	var state = StateRegistered
+
+	for _, inst := range s.instances {
+		st := inst.getState()
+
+		if st == StateAvailable {
+			return StateAvailable
+		}
+
+		if st == StateRefused || (st == StateUnknown && state == StateRegistered) {
+			state = st
+		}
+	}
+
+	return state
 }
 
+// setState sets the state of all the instances of the service.
 func (s *service) setState(state serviceState) {
-	s.state = state
+	for _, inst := range s.instances {
+		inst.setState(state)
+	}
 }
 
 func newService(conf *ServiceConfig) *service {
@@ -239,27 +621,33 @@ func newService(conf *ServiceConfig) *service {
 		return nil
 	}
 
-	statusPath := func() string {
-		if conf != nil && conf.StatusPath != "" {
-			return conf.StatusPath
-		}
-		return defaultStatusPath
-	}()
+	lbFactory, ok := getLoadBalancerFactory(conf.LoadBalancer)
+	if !ok {
+		lbFactory, _ = getLoadBalancerFactory(defaultLoadBalancer)
+	}
+
+	var (
+		normalized = normalizeServiceConfig(conf)
+		instances  = normalized.Instances
+	)
 
 	serv := &service{
-		state: StateUnknown,
-		ServiceConfig: &ServiceConfig{
-			ServiceType: conf.ServiceType,
-			Name:        conf.Name,
-			Prefix:      conf.Prefix,
-			Protocol:    conf.Protocol,
-			Host:        conf.Host,
-			Port:        conf.Port,
-			TimeOutSec:  conf.TimeOutSec,
-			StatusPath:  statusPath,
-		},
+		ServiceConfig: normalized,
+		instances:     make([]*instance, len(instances)),
+		balancer:      lbFactory(),
+		rewriter:      newRewriter(conf.Rewrite),
+		healthCheck:   newHealthCheck(conf.HealthCheck, normalized.StatusPath, conf.ServiceType == serviceGRPCType),
+		outlier:       newOutlierDetection(conf.OutlierDetection),
+		retry:         newRetryPolicy(conf.Retry),
+		breaker:       newCircuitBreaker(conf.CircuitBreaker),
+		bulkhead:      newBulkhead(conf.Bulkhead),
+		tunnels:       newWebSocketTunnels(conf.WebSocket),
+		clientCert:    newClientCertPolicy(conf.ClientCert),
+		upstreamTLS:   newUpstreamTLS(conf.TLS),
 	}
 
+	serv.transport = newTransport(conf.Transport, conf.Protocol, serv.upstreamTLS)
+
 	duration := func() time.Duration {
 		if conf != nil && conf.TimeOutSec != 0 {
 			return time.Duration(conf.TimeOutSec) * time.Second
@@ -267,15 +655,94 @@ func newService(conf *ServiceConfig) *service {
 		return defaultClientTimeout
 	}()
 
-	serv.clientPool = sync.Pool{
-		New: func() any {
-			return newHttpClient(withHostName(serv.GetAddressWithProtocol()), withTimeOut(duration))
-		},
+	serv.timeout = duration
+	serv.streamIdleTimeout = defaultStreamIdleTimeout
+
+	if d, err := time.ParseDuration(conf.StreamIdleTimeout); err == nil {
+		serv.streamIdleTimeout = d
+	}
+
+	for i, ic := range instances {
+		serv.instances[i] = newInstance(ic, conf.Protocol, duration, serv.transport, serv.upstreamTLS.getConfig(ic.Host))
 	}
 
 	return serv
 }
 
+// inheritState hands the state of the given – old – service over to this one,
+// which is built to replace it. The instances with the same address keep their
+// health and ejection, so the service stays available without waiting for the
+// next healthcheck. The breaker and the open tunnels are kept as they are, if
+// their config is unchanged. It must be called before the service is served.
+func (s *service) inheritState(old *service) {
+	if old == nil {
+		return
+	}
+
+	s.lastCheck.inherit(&old.lastCheck)
+
+	for _, inst := range s.instances {
+		for _, oldInst := range old.instances {
+			if inst.getAddressWithProtocol() == oldInst.getAddressWithProtocol() {
+				inst.inheritState(oldInst)
+				break
+			}
+		}
+	}
+
+	if reflect.DeepEqual(s.CircuitBreaker, old.CircuitBreaker) {
+		s.breaker = old.breaker
+	}
+
+	if reflect.DeepEqual(s.WebSocket, old.WebSocket) {
+		s.tunnels = old.tunnels
+	}
+}
+
+// normalizeServiceConfig returns a copy of the given config with the defaults
+// filled in. It builds nothing, so it is cheap to compare the configs by it.
+func normalizeServiceConfig(conf *ServiceConfig) *ServiceConfig {
+	statusPath := func() string {
+		if conf != nil && conf.StatusPath != "" {
+			return conf.StatusPath
+		}
+		return defaultStatusPath
+	}()
+
+	instances := func() []*InstanceConfig {
+		if len(conf.Instances) > 0 {
+			return conf.Instances
+		}
+		return []*InstanceConfig{{Host: conf.Host, Port: conf.Port}}
+	}()
+
+	return &ServiceConfig{
+		ServiceType:       conf.ServiceType,
+		Name:              conf.Name,
+		Prefix:            conf.Prefix,
+		Hosts:             normalizeHosts(conf.Hosts),
+		Protocol:          conf.Protocol,
+		Host:              conf.Host,
+		Port:              conf.Port,
+		Instances:         instances,
+		LoadBalancer:      conf.LoadBalancer,
+		TimeOutSec:        conf.TimeOutSec,
+		StatusPath:        statusPath,
+		HealthCheck:       conf.HealthCheck,
+		OutlierDetection:  conf.OutlierDetection,
+		Retry:             conf.Retry,
+		CircuitBreaker:    conf.CircuitBreaker,
+		Bulkhead:          conf.Bulkhead,
+		WebSocket:         conf.WebSocket,
+		ClientCert:        conf.ClientCert,
+		Transport:         conf.Transport,
+		TLS:               conf.TLS,
+		MaxBodySize:       conf.MaxBodySize,
+		StreamIdleTimeout: conf.StreamIdleTimeout,
+		Rewrite:           conf.Rewrite,
+	}
+}
+
 // validateService validates a service by the given config.
 // It returns the first error that occured.
 func validateService(config *ServiceConfig) error {
@@ -285,20 +752,107 @@ func validateService(config *ServiceConfig) error {
 	if !includes(supportedServiceTypes, config.ServiceType) {
 		return errUnsupportedServiceType
 	}
-	if config.Host == "" {
+	if config.Host == "" && len(config.Instances) == 0 {
 		return errEmptyHost
 	}
 	if config.Name == "" {
 		return errEmptyName
 	}
-	if config.Port == "" {
+	if config.Port == "" && len(config.Instances) == 0 {
 		return errEmptyPort
 	}
+	for _, inst := range config.Instances {
+		if inst == nil || inst.Host == "" {
+			return errEmptyHost
+		}
+		if inst.Port == "" {
+			return errEmptyPort
+		}
+	}
 	if config.Prefix == "" {
 		return errEmptyPrefix
 	}
 	if !includes(enabledProtocols, config.Protocol) {
 		return errBadProtocol
 	}
+	if _, ok := getLoadBalancerFactory(config.LoadBalancer); !ok {
+		return errUnknownLoadBalancer
+	}
+	if err := validateRewrite(config.Rewrite); err != nil {
+		return err
+	}
+	if err := validateHealthCheck(config.HealthCheck); err != nil {
+		return err
+	}
+	if err := validateOutlierDetection(config.OutlierDetection); err != nil {
+		return err
+	}
+	if err := validateRetryPolicy(config.Retry); err != nil {
+		return err
+	}
+	if err := validateCircuitBreaker(config.CircuitBreaker); err != nil {
+		return err
+	}
+	if err := validateBulkhead(config.Bulkhead); err != nil {
+		return err
+	}
+	if err := validateWebSocket(config.WebSocket); err != nil {
+		return err
+	}
+	if err := validateClientCertPolicy(config.ClientCert); err != nil {
+		return err
+	}
+	if err := validateTransport(config.Transport); err != nil {
+		return err
+	}
+	if err := validateUpstreamTLS(config.TLS); err != nil {
+		return err
+	}
+	if config.MaxBodySize < 0 {
+		return errBadMaxBodySize
+	}
+	if config.StreamIdleTimeout != "" {
+		if d, err := time.ParseDuration(config.StreamIdleTimeout); err != nil || d <= 0 {
+			return errBadStreamIdleTimeout
+		}
+	}
+	for _, host := range config.Hosts {
+		if err := validateHost(host); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// validateHost validates the given host pattern. The only
+// wildcard that is allowed is a leading "*." label.
+func validateHost(host string) error {
+	if host == "" {
+		return errBadHost
+	}
+
+	if strings.HasPrefix(host, wildcardHostPrefix) {
+		host = host[len(wildcardHostPrefix):]
+	}
+
+	if host == "" || strings.ContainsAny(host, "*/: ") {
+		return errBadHost
+	}
+
+	return nil
+}
+
+// normalizeHosts returns the lowercase form of the given hosts.
+func normalizeHosts(hosts []string) []string {
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	normalized := make([]string, len(hosts))
+
+	for i, h := range hosts {
+		normalized[i] = strings.ToLower(h)
+	}
+
+	return normalized
+}
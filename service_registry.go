@@ -2,18 +2,41 @@ package gateway
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/balazskvancz/rtree"
 )
 
 const (
 	defaultHealthCheckFreq = time.Minute * 2
+
+	wildcardHostPrefix = "*."
 )
 
 type registry struct {
 	healthCheckFrequency time.Duration
-	serviceTree          *tree
+
+	// Guards the services and the trees. Since the tree does not support
+	// removal, every mutation builds new trees, then swaps the old ones.
+	mu sync.RWMutex
+
+	// All the registered services in the order of their registration.
+	services []*service
+
+	// The tree of the services which are not bound to any host.
+	// It is the fallback for every host as well.
+	serviceTree *tree
+
+	// The trees of the services which are bound to hosts,
+	// keyed by the host patterns, eg. api.example.com or *.example.com.
+	hostTrees map[string]*tree
+
+	// The traffic splits keyed by the name of the split service.
+	splits map[string]*trafficSplit
+
+	// Signals the changes of the services to the health scheduler.
+	changes chan struct{}
 	logger
 }
 
@@ -21,16 +44,30 @@ type registry struct {
 func newRegistry() *registry {
 	r := &registry{
 		healthCheckFrequency: defaultHealthCheckFreq,
+		services:             make([]*service, 0),
 		serviceTree:          newTree(),
+		hostTrees:            make(map[string]*tree),
+		splits:               make(map[string]*trafficSplit),
+		changes:              make(chan struct{}, 1),
 	}
 
 	return r
 }
 
 func (r *registry) withHealthCheck(freq time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.healthCheckFrequency = freq
 }
 
+func (r *registry) getHealthCheckFrequency() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.healthCheckFrequency
+}
+
 func (r *registry) withLogger(l logger) {
 	r.logger = l
 }
@@ -45,67 +82,485 @@ func (r *registry) addService(conf *ServiceConfig) error {
 		return errRegistryNil
 	}
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	// If the map hasnt been initialized, we return error.
 	if r.serviceTree == nil {
 		return errServiceTreeNil
 	}
 
-	service := newService(conf)
+	if r.getServiceByNameLocked(conf.Name) != nil {
+		return errServiceExists
+	}
+
+	return r.setServicesLocked(append(r.getAllServicesLocked(), newService(conf)))
+}
+
+// updateService replaces the service – identified by the name
+// in the given config – with a new one built from the config.
+func (r *registry) updateService(conf *ServiceConfig) error {
+	if err := validateService(conf); err != nil {
+		return err
+	}
+
+	if r == nil {
+		return errRegistryNil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.serviceTree == nil {
+		return errServiceTreeNil
+	}
+
+	if r.getServiceByNameLocked(conf.Name) == nil {
+		return ErrServiceNotExists
+	}
+
+	// The service is replaced in place, so the order of the registration is kept.
+	var (
+		services = r.getAllServicesLocked()
+		built    *service
+	)
+
+	for i, s := range services {
+		if s.Name == conf.Name {
+			built = newService(conf)
+			built.inheritState(s)
+			services[i] = built
+		}
+	}
+
+	if err := r.setServicesLocked(services); err != nil {
+		// The tunnels may be inherited from the old service, so they are left open.
+		built.release()
+
+		return err
+	}
+
+	return nil
+}
+
+// removeService removes the service identified by the given name from the registry.
+// The service can not be removed, while a traffic split refers to it.
+func (r *registry) removeService(name string) error {
+	if r == nil {
+		return errRegistryNil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.serviceTree == nil {
+		return errServiceTreeNil
+	}
+
+	if r.getServiceByNameLocked(name) == nil {
+		return ErrServiceNotExists
+	}
 
-	if node := r.serviceTree.FindLongestMatch(service.Prefix); node != nil {
+	for _, split := range r.splits {
+		if split.refersTo(name) {
+			return errServiceInSplit
+		}
+	}
+
+	services := filter(r.getAllServicesLocked(), func(s *service) bool {
+		return s.Name != name
+	})
+
+	return r.setServicesLocked(services)
+}
+
+// applyServices replaces the registered services with the ones
+// built from the given configs, and the splits with the given ones in
+// one step. The unchanged services are kept as they are – with their
+// states –, while the changed ones are rebuilt. The services and the
+// splits, which are not among the managed ones – eg. registered by the
+// system API –, are kept as well, unless the configs declare one with
+// the same name. If the services or the splits are invalid, nothing is
+// changed. Returns the difference between the old and new state.
+// The configs must be validated by the caller.
+func (r *registry) applyServices(confs []*ServiceConfig, splitConfs []*TrafficSplitConfig, managed []string, managedSplits []string) (*serviceDiff, error) {
+	if r == nil {
+		return nil, errRegistryNil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.serviceTree == nil {
+		return nil, errServiceTreeNil
+	}
+
+	current := r.getAllServicesLocked()
+	confs = withUnmanagedServices(current, confs, managed)
+	splitConfs = withUnmanagedSplits(r.splits, splitConfs, managedSplits)
+
+	var (
+		diff     = diffServices(current, confs)
+		names    = make(map[string]struct{}, len(confs))
+		splits   = make(map[string]*trafficSplit, len(splitConfs))
+		existing = make(map[string]*service, len(current))
+		services = make([]*service, len(confs))
+	)
+
+	for _, conf := range confs {
+		names[conf.Name] = struct{}{}
+	}
+
+	// The splits are validated against the new services,
+	// before anything is built or swapped.
+	exists := func(name string) bool {
+		_, ok := names[name]
+		return ok
+	}
+
+	for _, conf := range splitConfs {
+		if err := validateTrafficSplit(conf, exists); err != nil {
+			return nil, err
+		}
+
+		splits[conf.ServiceName] = newTrafficSplit(conf)
+	}
+
+	for _, s := range current {
+		existing[s.Name] = s
+	}
+
+	built := make([]*service, 0, len(diff.added)+len(diff.changed))
+
+	for i, conf := range confs {
+		s, ok := existing[conf.Name]
+		if ok && !includes(diff.changed, conf.Name) {
+			services[i] = s
+			continue
+		}
+
+		services[i] = newService(conf)
+		services[i].inheritState(s)
+		built = append(built, services[i])
+	}
+
+	if err := r.setServicesLocked(services); err != nil {
+		// The rejected services must release their resources as well,
+		// but the tunnels may be inherited from the old services.
+		for _, s := range built {
+			s.release()
+		}
+
+		return nil, err
+	}
+
+	r.splits = splits
+
+	return diff, nil
+}
+
+// withUnmanagedServices returns the given configs extended by the configs of the
+// current services, which are neither managed, nor declared by the given configs.
+func withUnmanagedServices(current []*service, confs []*ServiceConfig, managed []string) []*ServiceConfig {
+	declared := make(map[string]struct{}, len(confs))
+	for _, conf := range confs {
+		declared[conf.Name] = struct{}{}
+	}
+
+	all := append(make([]*ServiceConfig, 0, len(confs)), confs...)
+
+	for _, s := range current {
+		if _, ok := declared[s.Name]; ok || includes(managed, s.Name) {
+			continue
+		}
+
+		all = append(all, s.ServiceConfig)
+	}
+
+	return all
+}
+
+// withUnmanagedSplits returns the given configs extended by the configs of the
+// current splits, which are neither managed, nor declared by the given configs.
+func withUnmanagedSplits(current map[string]*trafficSplit, confs []*TrafficSplitConfig, managed []string) []*TrafficSplitConfig {
+	declared := make(map[string]struct{}, len(confs))
+	for _, conf := range confs {
+		declared[conf.ServiceName] = struct{}{}
+	}
+
+	all := append(make([]*TrafficSplitConfig, 0, len(confs)), confs...)
+
+	for name, split := range current {
+		if _, ok := declared[name]; ok || includes(managed, name) {
+			continue
+		}
+
+		all = append(all, split.TrafficSplitConfig)
+	}
+
+	return all
+}
+
+// setServicesLocked builds the trees from the given services,
+// then swaps the current ones. In case of error – eg. conflicting
+// prefixes – the registry is left untouched.
+func (r *registry) setServicesLocked(services []*service) error {
+	defaultTree, hostTrees, err := buildTrees(services)
+	if err != nil {
+		return err
+	}
+
+	// The services which are removed or replaced must release their resources,
+	// but the tunnels inherited by the new services are kept open.
+	var (
+		kept    = make(map[*service]struct{}, len(services))
+		tunnels = make(map[*webSocketTunnels]struct{}, len(services))
+	)
+
+	for _, s := range services {
+		kept[s] = struct{}{}
+		tunnels[s.tunnels] = struct{}{}
+	}
+
+	for _, s := range r.services {
+		if _, ok := kept[s]; ok {
+			continue
+		}
+
+		if _, ok := tunnels[s.tunnels]; !ok {
+			s.tunnels.closeAll()
+		}
+
+		s.release()
+	}
+
+	r.services = services
+	r.serviceTree = defaultTree
+	r.hostTrees = hostTrees
+
+	// There is no need to block, one pending signal is enough.
+	select {
+	case r.changes <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// buildTrees creates the default tree and the trees of the hosts,
+// which store all the given services. The services without hosts
+// are stored in the default one, the rest in the tree of each of their host.
+func buildTrees(services []*service) (*tree, map[string]*tree, error) {
+	var (
+		defaultTree = newTree()
+		hostTrees   = make(map[string]*tree)
+	)
+
+	for _, s := range services {
+		if len(s.Hosts) == 0 {
+			if err := insertService(defaultTree, s); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		for _, host := range s.Hosts {
+			t, ok := hostTrees[host]
+			if !ok {
+				t = newTree()
+				hostTrees[host] = t
+			}
+
+			if err := insertService(t, s); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return defaultTree, hostTrees, nil
+}
+
+// insertService inserts the given service into the tree,
+// if its prefix does not conflict with the already stored ones.
+func insertService(t *tree, s *service) error {
+	if node := t.FindLongestMatch(s.Prefix); node != nil {
 		return errServiceExists
 	}
 
-	return r.serviceTree.insert(service.Prefix, service)
+	return t.insert(s.Prefix, s)
 }
 
-// findService searches the tree based on the given url.
+// findService searches the default tree based on the given url.
 func (r *registry) findService(url string) *service {
-	node := r.serviceTree.FindLongestMatch(url)
-	if node == nil {
-		return nil
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return findInTree(r.serviceTree, url)
+}
+
+// findServiceByHost searches for the service by the given host and url.
+// First the tree of the host is selected – by exact match, then by the
+// longest matching wildcard pattern –, and the longest prefix match is
+// done in it. If there is no match, the default tree is used.
+func (r *registry) findServiceByHost(host string, url string) *service {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if t := r.getHostTreeLocked(host); t != nil {
+		if s := findInTree(t, url); s != nil {
+			return s
+		}
 	}
-	return node.GetValue()
+
+	return findInTree(r.serviceTree, url)
 }
 
-// getServiceByName searches for services by the given name.
-func (r *registry) getServiceByName(name string) *service {
-	var findServiceByName = func(n *rtree.Node[*service]) bool {
-		if n == nil {
-			return false
+// getHostTreeLocked returns the tree which belongs to the given host.
+func (r *registry) getHostTreeLocked(host string) *tree {
+	if host == "" || len(r.hostTrees) == 0 {
+		return nil
+	}
+
+	if t, ok := r.hostTrees[host]; ok {
+		return t
+	}
+
+	var (
+		bestTree    *tree
+		bestPattern string
+	)
+
+	for pattern, t := range r.hostTrees {
+		if !strings.HasPrefix(pattern, wildcardHostPrefix) {
+			continue
+		}
+
+		// The pattern *.example.com matches foo.example.com
+		// but does not match example.com itself.
+		suffix := pattern[len(wildcardHostPrefix)-1:]
+		if !strings.HasSuffix(host, suffix) || len(host) == len(suffix) {
+			continue
 		}
-		if n.GetValue() == nil {
-			return false
+
+		if len(pattern) > len(bestPattern) {
+			bestTree = t
+			bestPattern = pattern
 		}
-		return n.GetValue().GetValue().Name == name
 	}
 
-	node := r.serviceTree.GetByPredicate(findServiceByName)
+	return bestTree
+}
+
+func findInTree(t *tree, url string) *service {
+	node := t.FindLongestMatch(url)
 	if node == nil {
 		return nil
 	}
+	return node.GetValue()
+}
+
+// resolveSplit returns the version of the given service which should serve
+// the request. If the traffic of the service is not split, or the picked
+// version does not exist anymore, then the service itself is returned.
+func (r *registry) resolveSplit(s *service, req *http.Request) *service {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	split, ok := r.splits[s.Name]
+	if !ok {
+		return s
+	}
+
+	name := split.pick(req)
+	if name == "" || name == s.Name {
+		return s
+	}
 
-	return node.GetValue().GetValue()
+	if version := r.getServiceByNameLocked(name); version != nil {
+		return version
+	}
+
+	return s
 }
 
-// Updates the status of the services, in the registry.
-func (r *registry) updateStatus() {
-	t := time.NewTicker(r.healthCheckFrequency)
+// setTrafficSplit validates then stores the given split,
+// replacing the previous one of the same service.
+func (r *registry) setTrafficSplit(conf *TrafficSplitConfig) error {
+	if r == nil {
+		return errRegistryNil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	for {
-		nodes := r.serviceTree.GetAllLeaf()
+	if err := validateTrafficSplit(conf, r.serviceExistsLocked); err != nil {
+		return err
+	}
 
-		for _, n := range nodes {
-			service := n.GetValue().GetValue()
+	r.splits[conf.ServiceName] = newTrafficSplit(conf)
 
-			if err := service.checkStatus(); err != nil {
-				l := fmt.Sprintf("[registry] service %s – checkStatus error: %v", service.Name, err)
-				r.logger.Error(l)
-			}
+	return nil
+}
+
+// removeTrafficSplit removes the split of the service by the given name.
+func (r *registry) removeTrafficSplit(name string) error {
+	if r == nil {
+		return errRegistryNil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.splits[name]; !ok {
+		return ErrServiceNotExists
+	}
+
+	delete(r.splits, name)
+
+	return nil
+}
+
+// getTrafficSplits returns the configs of all the splits.
+func (r *registry) getTrafficSplits() []*TrafficSplitConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	confs := make([]*TrafficSplitConfig, 0, len(r.splits))
+
+	for _, s := range r.splits {
+		confs = append(confs, s.TrafficSplitConfig)
+	}
+
+	return confs
+}
+
+func (r *registry) serviceExistsLocked(name string) bool {
+	return r.getServiceByNameLocked(name) != nil
+}
+
+// getServiceByName searches for services by the given name.
+func (r *registry) getServiceByName(name string) *service {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.getServiceByNameLocked(name)
+}
+
+func (r *registry) getServiceByNameLocked(name string) *service {
+	for _, s := range r.services {
+		if s.Name == name {
+			return s
 		}
+	}
 
-		// Lets sleep for the given amount.
-		<-t.C
+	return nil
+}
+
+// checkServiceStatus performs the healthcheck of given service and logs its error.
+func (r *registry) checkServiceStatus(service *service) {
+	if err := service.checkStatus(); err != nil {
+		l := fmt.Sprintf("[registry] service %s – checkStatus error: %v", service.Name, err)
+		r.logger.Error(l)
 	}
 }
 
@@ -122,13 +577,18 @@ func (r *registry) setServiceAvailable(name string) {
 }
 
 func (r *registry) getAllServices() []*service {
-	var (
-		nodes = r.serviceTree.GetAllLeaf()
-		s     = make([]*service, len(nodes))
-	)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.getAllServicesLocked()
+}
+
+// getAllServicesLocked returns a copy of the slice of services,
+// so the caller is free to modify it.
+func (r *registry) getAllServicesLocked() []*service {
+	s := make([]*service, len(r.services))
+
+	copy(s, r.services)
 
-	for i, node := range nodes {
-		s[i] = node.GetValue().GetValue()
-	}
 	return s
 }
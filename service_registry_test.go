@@ -254,3 +254,303 @@ func TestGetServiceByName(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateService(t *testing.T) {
+	type (
+		registryFactory func(*testing.T) *registry
+	)
+
+	type testCase struct {
+		name        string
+		getRegistry registryFactory
+		conf        *ServiceConfig
+		expError    error
+	}
+
+	var getRegistry = func(t *testing.T) *registry {
+		r := newRegistry()
+
+		for _, conf := range []*ServiceConfig{
+			{Protocol: "http", Name: "mock-name-1", Host: "localhost", Port: "3000", Prefix: "/foo"},
+			{Protocol: "http", Name: "mock-name-2", Host: "localhost", Port: "3010", Prefix: "/bar"},
+		} {
+			if err := r.addService(conf); err != nil {
+				t.Fatalf("expected not to get error; but got: %v\n", err)
+			}
+		}
+
+		return r
+	}
+
+	tt := []testCase{
+		{
+			name:        "the function returns error if the config is invalid",
+			getRegistry: getRegistry,
+			conf:        &ServiceConfig{Name: "mock-name-1"},
+			expError:    errEmptyHost,
+		},
+		{
+			name:        "the function returns error if the service does not exist",
+			getRegistry: getRegistry,
+			conf:        &ServiceConfig{Protocol: "http", Name: "mock-name-3", Host: "localhost", Port: "3000", Prefix: "/baz"},
+			expError:    ErrServiceNotExists,
+		},
+		{
+			name:        "the function returns error if the new prefix is taken by an other service",
+			getRegistry: getRegistry,
+			conf:        &ServiceConfig{Protocol: "http", Name: "mock-name-1", Host: "localhost", Port: "3000", Prefix: "/bar"},
+			expError:    errServiceExists,
+		},
+		{
+			name:        "the function does not return error",
+			getRegistry: getRegistry,
+			conf:        &ServiceConfig{Protocol: "http", Name: "mock-name-1", Host: "localhost", Port: "3020", Prefix: "/baz"},
+			expError:    nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := tc.getRegistry(t)
+
+			if err := reg.updateService(tc.conf); !errors.Is(err, tc.expError) {
+				t.Errorf("expected error: %v; got: %v\n", tc.expError, err)
+			}
+
+			if tc.expError != nil {
+				return
+			}
+
+			serv := reg.findService(tc.conf.Prefix)
+			if serv == nil || serv.Port != tc.conf.Port {
+				t.Errorf("expected to find the updated service, but did not")
+			}
+
+			if reg.findService("/foo") != nil {
+				t.Errorf("not expected to find the service by its old prefix, but did")
+			}
+
+			// The updated service keeps its place in the order of the registration.
+			if services := reg.getAllServices(); services[0].Name != tc.conf.Name {
+				t.Errorf("expected first service: %s; got first service: %s\n", tc.conf.Name, services[0].Name)
+			}
+		})
+	}
+}
+
+func TestUpdateServiceKeepsState(t *testing.T) {
+	reg := newRegistry()
+
+	conf := &ServiceConfig{
+		Protocol: "http",
+		Name:     "mock-name-1",
+		Prefix:   "/foo",
+		Instances: []*InstanceConfig{
+			{Host: "localhost", Port: "3000"},
+			{Host: "localhost", Port: "3010"},
+		},
+	}
+
+	if err := reg.addService(conf); err != nil {
+		t.Fatalf("expected not to get error; but got: %v\n", err)
+	}
+
+	old := reg.getServiceByName("mock-name-1")
+	old.setState(StateAvailable)
+	old.instances[0].outlier.ejected = true
+
+	// The timeout is changed, and the second instance is moved to an other port.
+	if err := reg.updateService(&ServiceConfig{
+		Protocol:   "http",
+		Name:       "mock-name-1",
+		Prefix:     "/foo",
+		TimeOutSec: 10,
+		Instances: []*InstanceConfig{
+			{Host: "localhost", Port: "3000"},
+			{Host: "localhost", Port: "3020"},
+		},
+	}); err != nil {
+		t.Fatalf("expected not to get error; but got: %v\n", err)
+	}
+
+	s := reg.getServiceByName("mock-name-1")
+
+	if s == old {
+		t.Fatalf("expected the service to be rebuilt\n")
+	}
+
+	if st := s.instances[0].getState(); st != StateAvailable {
+		t.Errorf("expected state: %v; got state: %v\n", StateAvailable, st)
+	}
+
+	if !s.instances[0].outlier.isEjected() {
+		t.Errorf("expected the instance to stay ejected\n")
+	}
+
+	if st := s.instances[1].getState(); st != StateUnknown {
+		t.Errorf("expected state: %v; got state: %v\n", StateUnknown, st)
+	}
+
+	if s.breaker != old.breaker || s.tunnels != old.tunnels {
+		t.Errorf("expected the breaker and the tunnels to be kept\n")
+	}
+
+	if !s.tunnels.reserve() {
+		t.Errorf("expected the tunnels to stay open\n")
+	}
+}
+
+func TestRemoveService(t *testing.T) {
+	type testCase struct {
+		name        string
+		serviceName string
+		expError    error
+	}
+
+	tt := []testCase{
+		{
+			name:        "the function returns error if the service does not exist",
+			serviceName: "mock-name-4",
+			expError:    ErrServiceNotExists,
+		},
+		{
+			name:        "the function returns error if the service is split",
+			serviceName: "mock-name-1",
+			expError:    errServiceInSplit,
+		},
+		{
+			name:        "the function returns error if the service is a version of a split",
+			serviceName: "mock-name-2",
+			expError:    errServiceInSplit,
+		},
+		{
+			name:        "the function removes the service",
+			serviceName: "mock-name-3",
+			expError:    nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := newRegistry()
+
+			for _, conf := range []*ServiceConfig{
+				{Protocol: "http", Name: "mock-name-1", Host: "localhost", Port: "3000", Prefix: "/foo"},
+				{Protocol: "http", Name: "mock-name-2", Host: "localhost", Port: "3010", Prefix: "/bar"},
+				{Protocol: "http", Name: "mock-name-3", Host: "localhost", Port: "3020", Prefix: "/baz"},
+			} {
+				if err := reg.addService(conf); err != nil {
+					t.Fatalf("expected not to get error; but got: %v\n", err)
+				}
+			}
+
+			split := &TrafficSplitConfig{ServiceName: "mock-name-1", Versions: []*SplitVersion{{ServiceName: "mock-name-2", Weight: 1}}}
+			if err := reg.setTrafficSplit(split); err != nil {
+				t.Fatalf("expected not to get error; but got: %v\n", err)
+			}
+
+			if err := reg.removeService(tc.serviceName); !errors.Is(err, tc.expError) {
+				t.Errorf("expected error: %v; got: %v\n", tc.expError, err)
+			}
+
+			if tc.expError != nil {
+				// Nothing is removed on error.
+				if l := len(reg.getAllServices()); l != 3 {
+					t.Errorf("expected services: %d; got services: %d\n", 3, l)
+				}
+
+				return
+			}
+
+			if reg.getServiceByName(tc.serviceName) != nil {
+				t.Error("not expected to find the removed service, but did")
+			}
+
+			if reg.findService("/bar") == nil {
+				t.Error("expected to find the remaining service, but did not")
+			}
+		})
+	}
+}
+
+func TestFindServiceByHost(t *testing.T) {
+	type testCase struct {
+		name        string
+		host        string
+		url         string
+		expectedSvc string
+	}
+
+	reg := newRegistry()
+
+	for _, conf := range []*ServiceConfig{
+		{Protocol: "http", Name: "default-api", Host: "localhost", Port: "3000", Prefix: "/api"},
+		{Protocol: "http", Name: "default-static", Host: "localhost", Port: "3001", Prefix: "/static"},
+		{Protocol: "http", Name: "tenant-a-api", Host: "localhost", Port: "3010", Prefix: "/api", Hosts: []string{"a.example.com"}},
+		{Protocol: "http", Name: "tenant-b-api", Host: "localhost", Port: "3020", Prefix: "/api", Hosts: []string{"*.example.com"}},
+		{Protocol: "http", Name: "tenant-c-api", Host: "localhost", Port: "3030", Prefix: "/api", Hosts: []string{"*.c.example.com"}},
+	} {
+		if err := reg.addService(conf); err != nil {
+			t.Fatalf("expected not to get error; but got: %v\n", err)
+		}
+	}
+
+	tt := []testCase{
+		{
+			name:        "the exact host match is selected",
+			host:        "a.example.com",
+			url:         "/api/foo",
+			expectedSvc: "tenant-a-api",
+		},
+		{
+			name:        "the wildcard host match is selected",
+			host:        "b.example.com",
+			url:         "/api/foo",
+			expectedSvc: "tenant-b-api",
+		},
+		{
+			name:        "the longest wildcard host match is selected",
+			host:        "foo.c.example.com",
+			url:         "/api/foo",
+			expectedSvc: "tenant-c-api",
+		},
+		{
+			name:        "the wildcard does not match the bare domain",
+			host:        "example.com",
+			url:         "/api/foo",
+			expectedSvc: "default-api",
+		},
+		{
+			name:        "the default tree is the fallback for unknown hosts",
+			host:        "other.com",
+			url:         "/api/foo",
+			expectedSvc: "default-api",
+		},
+		{
+			name:        "the default tree is the fallback if there is no match in the tree of the host",
+			host:        "a.example.com",
+			url:         "/static/foo",
+			expectedSvc: "default-static",
+		},
+		{
+			name:        "the function returns nil if there is no match at all",
+			host:        "a.example.com",
+			url:         "/foo",
+			expectedSvc: "",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			serv := reg.findServiceByHost(tc.host, tc.url)
+
+			if tc.expectedSvc == "" && serv != nil {
+				t.Errorf("not expected to find, but found: %s\n", serv.Name)
+			}
+
+			if tc.expectedSvc != "" && (serv == nil || serv.Name != tc.expectedSvc) {
+				t.Errorf("expected service: %s; got: %v\n", tc.expectedSvc, serv)
+			}
+		})
+	}
+}
@@ -1,11 +1,15 @@
 package gateway
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/http"
-	"sync"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+
+	"github.com/balazskvancz/gorouter"
 )
 
 func TestValidateService(t *testing.T) {
@@ -82,6 +86,50 @@ func TestValidateService(t *testing.T) {
 			},
 			err: nil,
 		},
+		{
+			name: "the function returns error if the port of an instance is empty",
+			conf: &ServiceConfig{
+				Name:      "mock-name",
+				Prefix:    "/mock",
+				Protocol:  "http",
+				Instances: []*InstanceConfig{{Host: "mock-host"}},
+			},
+			err: errEmptyPort,
+		},
+		{
+			name: "the function returns error if the load balancer is unknown",
+			conf: &ServiceConfig{
+				Name:         "mock-name",
+				Prefix:       "/mock",
+				Protocol:     "http",
+				Instances:    []*InstanceConfig{{Host: "mock-host", Port: "8000"}},
+				LoadBalancer: "foo",
+			},
+			err: errUnknownLoadBalancer,
+		},
+		{
+			name: "the function returns error if a host pattern is invalid",
+			conf: &ServiceConfig{
+				Host:     "mock-host",
+				Name:     "mock-name",
+				Port:     "8000",
+				Prefix:   "/mock",
+				Protocol: "http",
+				Hosts:    []string{"api.*.com"},
+			},
+			err: errBadHost,
+		},
+		{
+			name: "the function returns nil if the config is valid with instances",
+			conf: &ServiceConfig{
+				Name:         "mock-name",
+				Prefix:       "/mock",
+				Protocol:     "http",
+				Instances:    []*InstanceConfig{{Host: "mock-host", Port: "8000"}},
+				LoadBalancer: LoadBalancerLeastRequests,
+			},
+			err: nil,
+		},
 	}
 
 	for _, tc := range tt {
@@ -100,7 +148,7 @@ type mockHttpClient struct {
 	// httpClient
 }
 
-func (mc *mockHttpClient) pipe(method string, url string, header http.Header, body io.Reader) (*http.Response, error) {
+func (mc *mockHttpClient) pipe(_ context.Context, method string, url string, header http.Header, body io.Reader, _ int64) (*http.Response, error) {
 	return mc.mockPipe(method, url, header, body)
 }
 
@@ -114,6 +162,45 @@ func (mc *mockHttpClient) doRequest(_ string, _ string, _ io.Reader, _ ...http.H
 
 var _ (httpClient) = (*mockHttpClient)(nil)
 
+type mockRouterLogger struct{}
+
+func (mockRouterLogger) Info(string, ...any)    {}
+func (mockRouterLogger) Error(string, ...any)   {}
+func (mockRouterLogger) Warning(string, ...any) {}
+
+// newTestContext creates a Context for the given request, whose
+// response is written to the returned recorder by WriteToResponseNow.
+func newTestContext(r *http.Request) (Context, *httptest.ResponseRecorder) {
+	var (
+		rec = httptest.NewRecorder()
+		ctx = gorouter.NewContext(gorouter.ContextConfig{
+			DefaultResponseStatusCode: http.StatusOK,
+			Logger:                    mockRouterLogger{},
+		})
+	)
+
+	ctx.Reset(rec, r)
+
+	return ctx, rec
+}
+
+// newTestService creates an available service, whose only instance is the given test server.
+func newTestService(t *testing.T, srv *httptest.Server, conf *ServiceConfig) *service {
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	conf.Protocol = "http"
+	conf.Host = u.Hostname()
+	conf.Port = u.Port()
+
+	s := newService(conf)
+	s.setState(StateAvailable)
+
+	return s
+}
+
 // For now, these tests are commented out, will fix it later.
 /*
 func TestHandle(t *testing.T) {
@@ -147,7 +234,7 @@ func TestHandle(t *testing.T) {
 
 				s.setState(StateAvailable)
 
-				s.clientPool = sync.Pool{
+				s.instances[0].clientPool = sync.Pool{
 					New: func() any {
 						return &mockHttpClient{
 							mockPipe: func(r *http.Request) (*http.Response, error) {
@@ -170,7 +257,7 @@ func TestHandle(t *testing.T) {
 
 				s.setState(StateAvailable)
 
-				s.clientPool = sync.Pool{
+				s.instances[0].clientPool = sync.Pool{
 					New: func() any {
 						return &mockHttpClient{
 							mockPipe: func(r *http.Request) (*http.Response, error) {
@@ -210,8 +297,8 @@ func TestHandle(t *testing.T) {
 				t.Errorf("expected body: %s; got body: %s\n", tc.expBody, ctx.writer.b)
 			}
 
-			if service.state != tc.expState {
-				t.Errorf("expected state: %d; got state: %d\n", tc.expState, service.state)
+			if service.getState() != tc.expState {
+				t.Errorf("expected state: %d; got state: %d\n", tc.expState, service.getState())
 			}
 		})
 	}
@@ -241,13 +328,9 @@ func TestChechStatus(t *testing.T) {
 					Port:     "8000",
 				})
 
-				s.clientPool = sync.Pool{
-					New: func() any {
-						return &mockHttpClient{
-							mockDo: func(r *http.Request) (*http.Response, error) {
-								return nil, httpDoError
-							},
-						}
+				s.instances[0].healthClient = &mockHttpClient{
+					mockDo: func(r *http.Request) (*http.Response, error) {
+						return nil, httpDoError
 					},
 				}
 
@@ -265,17 +348,13 @@ func TestChechStatus(t *testing.T) {
 					Port:     "8000",
 				})
 
-				s.clientPool = sync.Pool{
-					New: func() any {
-						return &mockHttpClient{
-							mockDo: func(r *http.Request) (*http.Response, error) {
-								res := &http.Response{}
+				s.instances[0].healthClient = &mockHttpClient{
+					mockDo: func(r *http.Request) (*http.Response, error) {
+						res := &http.Response{}
 
-								res.StatusCode = http.StatusBadRequest
+						res.StatusCode = http.StatusBadRequest
 
-								return res, nil
-							},
-						}
+						return res, nil
 					},
 				}
 
@@ -293,17 +372,13 @@ func TestChechStatus(t *testing.T) {
 					Port:     "8000",
 				})
 
-				s.clientPool = sync.Pool{
-					New: func() any {
-						return &mockHttpClient{
-							mockDo: func(r *http.Request) (*http.Response, error) {
-								res := &http.Response{}
+				s.instances[0].healthClient = &mockHttpClient{
+					mockDo: func(r *http.Request) (*http.Response, error) {
+						res := &http.Response{}
 
-								res.StatusCode = http.StatusOK
+						res.StatusCode = http.StatusOK
 
-								return res, nil
-							},
-						}
+						return res, nil
 					},
 				}
 
@@ -322,8 +397,66 @@ func TestChechStatus(t *testing.T) {
 				t.Errorf("expected error: %v; got error: %v\n", tc.expErr, err)
 			}
 
-			if service.state != tc.expState {
-				t.Errorf("expected state: %d; got state: %v\n", tc.expState, service.state)
+			if state := service.getState(); state != tc.expState {
+				t.Errorf("expected state: %d; got state: %v\n", tc.expState, state)
+			}
+		})
+	}
+}
+
+func TestNextInstance(t *testing.T) {
+	type testCase struct {
+		name       string
+		getService serviceFactory
+
+		expAddress string
+	}
+
+	var conf = &ServiceConfig{
+		Protocol: "http",
+		Instances: []*InstanceConfig{
+			{Host: "localhost", Port: "8000"},
+			{Host: "localhost", Port: "8001"},
+		},
+	}
+
+	tt := []testCase{
+		{
+			name: "the function returns nil if there is no available instance",
+			getService: func(t *testing.T) *service {
+				return newService(conf)
+			},
+			expAddress: "",
+		},
+		{
+			name: "the function skips the instances which are not available",
+			getService: func(t *testing.T) *service {
+				s := newService(conf)
+
+				s.instances[1].setState(StateAvailable)
+
+				return s
+			},
+			expAddress: "localhost:8001",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			service := tc.getService(t)
+
+			// Picking more than once, so the round-robin
+			// would reach every instance.
+			for i := 0; i < len(service.instances); i++ {
+				inst := service.nextInstance()
+
+				if tc.expAddress == "" && inst != nil {
+					t.Errorf("expected not to get instance; got: %s\n", inst.GetAddress())
+				}
+
+				if tc.expAddress != "" && (inst == nil || inst.GetAddress() != tc.expAddress) {
+					t.Errorf("expected instance: %s; got: %v\n", tc.expAddress, inst)
+				}
 			}
 		})
 	}
@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	X_GW_TIMESTAMP_KEY string = "X-GATEWAY-TIMESTAMP"
+	X_GW_NONCE_KEY     string = "X-GATEWAY-NONCE"
+
+	// The timestamp of a signed request may differ from the
+	// clock of the Gateway by this much in both directions.
+	defaultSignatureMaxSkew = 5 * time.Minute
+
+	maxSignatureNonceLength = 128
+	signatureNonceSize      = 16
+)
+
+// signatureVerifier verifies the signed requests of the system endpoints. The
+// nonces of the verified requests are remembered, until their timestamps expire,
+// so a captured request can not be replayed.
+type signatureVerifier struct {
+	secretKey []byte
+	maxSkew   time.Duration
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+// SignRequest signs the given request to the system endpoints of the Gateway by
+// the shared secret key. It sets the timestamp and a random nonce of the request,
+// and the HMAC-SHA256 signature of its method, url, timestamp, nonce and body.
+// The body is read, and replaced by the same content, so it can be sent.
+func SignRequest(r *http.Request, secretKey string) error {
+	var body []byte
+
+	if r.Body != nil && r.Body != http.NoBody {
+		b, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		body = b
+
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+	}
+
+	n := make([]byte, signatureNonceSize)
+	if _, err := rand.Read(n); err != nil {
+		return err
+	}
+
+	var (
+		timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+		nonce     = hex.EncodeToString(n)
+		mac       = getRequestSignature([]byte(secretKey), r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	)
+
+	r.Header.Set(X_GW_TIMESTAMP_KEY, timestamp)
+	r.Header.Set(X_GW_NONCE_KEY, nonce)
+	r.Header.Set(X_GW_HEADER_KEY, hex.EncodeToString(mac))
+
+	return nil
+}
+
+// getRequestSignature returns the HMAC-SHA256 of the given parts of a request,
+// each – except the body – followed by a newline.
+func getRequestSignature(secretKey []byte, method, url, timestamp, nonce string, body []byte) []byte {
+	h := hmac.New(sha256.New, secretKey)
+
+	for _, part := range []string{method, url, timestamp, nonce} {
+		h.Write([]byte(part))
+		h.Write([]byte{'\n'})
+	}
+
+	h.Write(body)
+
+	return h.Sum(nil)
+}
+
+// parseSignatureMaxSkew parses the allowed skew of the timestamps
+// of the config – eg. "90s" or "5m" –, which must be positive.
+func parseSignatureMaxSkew(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, errBadSignatureMaxSkew
+	}
+
+	return d, nil
+}
+
+func newSignatureVerifier(secretKey string, maxSkew time.Duration) *signatureVerifier {
+	if maxSkew <= 0 {
+		maxSkew = defaultSignatureMaxSkew
+	}
+
+	return &signatureVerifier{
+		secretKey: []byte(secretKey),
+		maxSkew:   maxSkew,
+		nonces:    make(map[string]time.Time),
+	}
+}
+
+// verify verifies the signature of the given request with the given body. The
+// signature is compared in constant time, and only the nonces of the correctly
+// signed requests are remembered, so they can not be used up by others.
+func (sv *signatureVerifier) verify(r *http.Request, body []byte, now time.Time) error {
+	var (
+		timestamp = r.Header.Get(X_GW_TIMESTAMP_KEY)
+		nonce     = r.Header.Get(X_GW_NONCE_KEY)
+	)
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce == "" || len(nonce) > maxSignatureNonceLength {
+		return errSignatureMissing
+	}
+
+	mac, err := hex.DecodeString(r.Header.Get(X_GW_HEADER_KEY))
+	if err != nil {
+		return errSignatureMissing
+	}
+
+	at := time.Unix(sec, 0)
+	if at.Before(now.Add(-sv.maxSkew)) || at.After(now.Add(sv.maxSkew)) {
+		return errSignatureExpired
+	}
+
+	expected := getRequestSignature(sv.secretKey, r.Method, r.RequestURI, timestamp, nonce, body)
+	if !hmac.Equal(mac, expected) {
+		return errSignatureMismatch
+	}
+
+	if !sv.useNonce(nonce, at.Add(sv.maxSkew), now) {
+		return errSignatureReplayed
+	}
+
+	return nil
+}
+
+// useNonce remembers the given nonce until it expires. It returns
+// false, if the nonce was already used, and it has not expired yet.
+func (sv *signatureVerifier) useNonce(nonce string, expiresAt time.Time, now time.Time) bool {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if now.Sub(sv.lastSweep) >= sv.maxSkew {
+		for n, exp := range sv.nonces {
+			if !now.Before(exp) {
+				delete(sv.nonces, n)
+			}
+		}
+
+		sv.lastSweep = now
+	}
+
+	if exp, exists := sv.nonces[nonce]; exists && now.Before(exp) {
+		return false
+	}
+
+	sv.nonces[nonce] = expiresAt
+
+	return true
+}
@@ -0,0 +1,231 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestSignedRequest returns a request to the given system url, signed by the given secret key.
+func newTestSignedRequest(t *testing.T, url string, body string, secretKey string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+
+	if err := SignRequest(r, secretKey); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	return r
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	type testCase struct {
+		name     string
+		modify   func(r *http.Request) []byte
+		expError error
+	}
+
+	const (
+		secretKey = "secret"
+		body      = `{"serviceName": "foo"}`
+	)
+
+	var (
+		now    = time.Now()
+		resign = func(r *http.Request, timestamp int64) {
+			ts := strconv.FormatInt(timestamp, 10)
+			mac := getRequestSignature([]byte(secretKey), r.Method, r.RequestURI, ts, r.Header.Get(X_GW_NONCE_KEY), []byte(body))
+
+			r.Header.Set(X_GW_TIMESTAMP_KEY, ts)
+			r.Header.Set(X_GW_HEADER_KEY, hex.EncodeToString(mac))
+		}
+	)
+
+	tt := []testCase{
+		{
+			name:     "the correctly signed request is valid",
+			modify:   func(r *http.Request) []byte { return []byte(body) },
+			expError: nil,
+		},
+		{
+			name: "the request without signature is rejected",
+			modify: func(r *http.Request) []byte {
+				r.Header.Del(X_GW_HEADER_KEY)
+				return []byte(body)
+			},
+			expError: errSignatureMismatch,
+		},
+		{
+			name: "the request without nonce is rejected",
+			modify: func(r *http.Request) []byte {
+				r.Header.Del(X_GW_NONCE_KEY)
+				return []byte(body)
+			},
+			expError: errSignatureMissing,
+		},
+		{
+			name:     "the request with a modified body is rejected",
+			modify:   func(r *http.Request) []byte { return []byte(`{"serviceName":"foo"}`) },
+			expError: errSignatureMismatch,
+		},
+		{
+			name: "the request with a modified url is rejected",
+			modify: func(r *http.Request) []byte {
+				r.RequestURI = routeDeregisterService
+				return []byte(body)
+			},
+			expError: errSignatureMismatch,
+		},
+		{
+			name: "the request signed by an other key is rejected",
+			modify: func(r *http.Request) []byte {
+				other := newTestSignedRequest(t, routeUpdateServiceState, body, "other")
+				r.Header = other.Header
+				return []byte(body)
+			},
+			expError: errSignatureMismatch,
+		},
+		{
+			name: "the old request is rejected",
+			modify: func(r *http.Request) []byte {
+				resign(r, now.Add(-6*time.Minute).Unix())
+				return []byte(body)
+			},
+			expError: errSignatureExpired,
+		},
+		{
+			name: "the request from the future is rejected",
+			modify: func(r *http.Request) []byte {
+				resign(r, now.Add(6*time.Minute).Unix())
+				return []byte(body)
+			},
+			expError: errSignatureExpired,
+		},
+		{
+			name: "the request within the skew is valid",
+			modify: func(r *http.Request) []byte {
+				resign(r, now.Add(-4*time.Minute).Unix())
+				return []byte(body)
+			},
+			expError: nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				sv = newSignatureVerifier(secretKey, 0)
+				r  = newTestSignedRequest(t, routeUpdateServiceState, body, secretKey)
+				b  = tc.modify(r)
+			)
+
+			if err := sv.verify(r, b, now); !errors.Is(err, tc.expError) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.expError, err)
+			}
+		})
+	}
+}
+
+func TestSignatureVerifierReplay(t *testing.T) {
+	var (
+		sv  = newSignatureVerifier("secret", time.Minute)
+		r   = newTestSignedRequest(t, routeSystemInfo, "{}", "secret")
+		now = time.Now()
+	)
+
+	if err := sv.verify(r, []byte("{}"), now); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	if err := sv.verify(r, []byte("{}"), now.Add(time.Second)); !errors.Is(err, errSignatureReplayed) {
+		t.Errorf("expected error: %v; got error: %v\n", errSignatureReplayed, err)
+	}
+
+	// Once the timestamp expired, the request is rejected by it.
+	if err := sv.verify(r, []byte("{}"), now.Add(2*time.Minute)); !errors.Is(err, errSignatureExpired) {
+		t.Errorf("expected error: %v; got error: %v\n", errSignatureExpired, err)
+	}
+
+	// So the expired nonce is forgotten by the next verified request.
+	sv.useNonce("other", now.Add(3*time.Minute), now.Add(2*time.Minute))
+
+	if l := len(sv.nonces); l != 1 {
+		t.Errorf("expected nonces: %d; got nonces: %d\n", 1, l)
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	const body = `{"serviceName": "foo"}`
+
+	r := newTestSignedRequest(t, routeUpdateServiceState, body, "secret")
+
+	// The body can still be sent after signing.
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	if string(b) != body {
+		t.Errorf("expected body: %s; got body: %s\n", body, string(b))
+	}
+
+	// The signed request is accepted by the system endpoints of the Gateway.
+	gw := New(WithSecretKey("secret"), WithConsumers(newTestConsumer("foo", "key", "/")))
+
+	type testCase struct {
+		name      string
+		secretKey string
+		expStatus int
+	}
+
+	tt := []testCase{
+		{name: "the request signed by the secret key is accepted", secretKey: "secret", expStatus: http.StatusOK},
+		{name: "the request signed by an other key is rejected", secretKey: "other", expStatus: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				r   = newTestSignedRequest(t, routeRemoveConsumer, `{"consumerId": "foo"}`, tc.secretKey)
+				rec = httptest.NewRecorder()
+			)
+
+			gw.ServeHTTP(rec, r)
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("expected status code: %d; got status code: %d\n", tc.expStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestSignatureMaxSkewConfig(t *testing.T) {
+	type testCase struct {
+		name       string
+		maxSkew    string
+		expMaxSkew time.Duration
+	}
+
+	tt := []testCase{
+		{name: "the seconds are parsed", maxSkew: "90s", expMaxSkew: 90 * time.Second},
+		{name: "the compound duration is parsed", maxSkew: "1m30s", expMaxSkew: 90 * time.Second},
+		{name: "the hours are parsed", maxSkew: "1h", expMaxSkew: time.Hour},
+		{name: "the duration without unit is ignored", maxSkew: "5", expMaxSkew: 0},
+		{name: "the negative duration is ignored", maxSkew: "-1m", expMaxSkew: 0},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			gw := New(getGatewayOptionFuncs(&GatewayConfig{SignatureMaxSkew: tc.maxSkew})...)
+
+			if gw.info.signatureMaxSkew != tc.expMaxSkew {
+				t.Errorf("expected max skew: %v; got max skew: %v\n", tc.expMaxSkew, gw.info.signatureMaxSkew)
+			}
+		})
+	}
+}
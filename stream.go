@@ -0,0 +1,225 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	eventStreamContentType = "text/event-stream"
+
+	defaultStreamIdleTimeout = time.Minute
+
+	streamChunkSize = 32 * 1024
+)
+
+type streamWriterKey struct{}
+
+var chunkPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, streamChunkSize)
+		return &b
+	},
+}
+
+// attempt is one request sent to an instance. Its timeout is applied by a timer,
+// which cancels the request, instead of the deadline of its context, so it can be
+// changed to an idle timeout, once the response turns out to be a stream.
+type attempt struct {
+	timer    *time.Timer
+	cancel   context.CancelFunc
+	timedOut int32
+
+	// The function which releases the resources of the attempt.
+	release func()
+}
+
+// newAttempt returns the context of a new attempt, which is
+// canceled if the timeout is over, or the given ctx is done.
+func newAttempt(ctx context.Context, timeout time.Duration) (context.Context, *attempt) {
+	tryCtx, cancel := context.WithCancel(ctx)
+
+	a := &attempt{cancel: cancel}
+
+	a.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&a.timedOut, 1)
+		cancel()
+	})
+
+	return tryCtx, a
+}
+
+// setIdleTimeout restarts the timer with the given duration.
+func (a *attempt) setIdleTimeout(d time.Duration) {
+	if a == nil {
+		return
+	}
+	a.timer.Reset(d)
+}
+
+// isTimedOut tells if the request was canceled by the timer.
+func (a *attempt) isTimedOut() bool {
+	return a != nil && atomic.LoadInt32(&a.timedOut) == 1
+}
+
+// wrapErr returns the error of the request. If the request was canceled
+// by the timer, the returned error wraps context.DeadlineExceeded.
+func (a *attempt) wrapErr(err error) error {
+	if err == nil || !a.isTimedOut() {
+		return err
+	}
+	return fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+}
+
+// done finishes the attempt, and releases its resources. It
+// must be called once the response of the attempt is consumed.
+func (a *attempt) done() {
+	if a == nil {
+		return
+	}
+
+	a.timer.Stop()
+	a.cancel()
+
+	if a.release != nil {
+		a.release()
+	}
+}
+
+// streamWriter wraps the writer of the incoming request, so the response
+// can be written directly – bypassing the buffered response of the router.
+// Once it is detached, the writes of the router are dropped.
+type streamWriter struct {
+	http.ResponseWriter
+
+	detached bool
+}
+
+var _ http.ResponseWriter = (*streamWriter)(nil)
+
+func (sw *streamWriter) Header() http.Header {
+	if sw.detached {
+		return http.Header{}
+	}
+	return sw.ResponseWriter.Header()
+}
+
+func (sw *streamWriter) WriteHeader(statusCode int) {
+	if sw.detached {
+		return
+	}
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sw *streamWriter) Write(b []byte) (int, error) {
+	if sw.detached {
+		return len(b), nil
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// detach returns the underlying writer, which is owned by the caller from now on.
+func (sw *streamWriter) detach() http.ResponseWriter {
+	sw.detached = true
+	return sw.ResponseWriter
+}
+
+// getStreamWriter returns the writer of the request,
+// or nil if the request was not served by the Gateway.
+func getStreamWriter(ctx Context) *streamWriter {
+	sw, _ := ctx.GetRequest().Context().Value(streamWriterKey{}).(*streamWriter)
+	return sw
+}
+
+// ServeHTTP is the entrypoint of every incoming HTTP request.
+// The writer and the client of the request are stored in its context.
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if gw.info.rejectEncodedSlashes && hasEncodedSlash(r) {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	var (
+		sw  = &streamWriter{ResponseWriter: w}
+		ctx = context.WithValue(r.Context(), streamWriterKey{}, sw)
+	)
+
+	info := gw.trustedProxies.getClientInfo(r)
+	gw.listenerTLS.setClientIdentity(r, info)
+
+	ctx = context.WithValue(ctx, clientInfoKey{}, info)
+
+	gw.router.ServeHTTP(sw, r.WithContext(ctx))
+}
+
+// isStreamingResponse tells if the response must be sent to the client
+// chunk by chunk, as it arrives: Server-Sent Events, and the responses
+// of unknown length – eg. chunked ones.
+func isStreamingResponse(res *http.Response) bool {
+	if mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err == nil && mediaType == eventStreamContentType {
+		return true
+	}
+	return res.ContentLength < 0
+}
+
+// stream writes the response to the client directly, flushing each chunk
+// immediately. The response is cut, if the service is idle for longer than
+// the idle timeout. If the client disconnects, the request to the service is
+// canceled too, since its context is derived from the incoming request.
+func (s *service) stream(ctx Context, sw *streamWriter, res *http.Response, att *attempt) {
+	var (
+		w       = sw.detach()
+		flusher = func() {}
+	)
+
+	if f, ok := w.(http.Flusher); ok {
+		flusher = f.Flush
+	}
+
+	header := w.Header()
+	for k, v := range res.Header {
+		header[k] = v
+	}
+
+	// The status code is still set, so it is logged by the router.
+	ctx.SetStatusCode(res.StatusCode)
+
+	w.WriteHeader(res.StatusCode)
+	flusher()
+
+	chunk := chunkPool.Get().(*[]byte)
+	defer chunkPool.Put(chunk)
+
+	for {
+		att.setIdleTimeout(s.streamIdleTimeout)
+
+		n, err := res.Body.Read(*chunk)
+		if n > 0 {
+			if _, werr := w.Write((*chunk)[:n]); werr != nil {
+				return
+			}
+			flusher()
+		}
+
+		if err == nil {
+			continue
+		}
+
+		switch {
+		case att.isTimedOut():
+			ctx.Warning("[Handle]: stream of service %s was idle for %s, it is closed", s.Name, s.streamIdleTimeout)
+		case !errors.Is(err, io.EOF) && ctx.GetRequest().Context().Err() == nil:
+			ctx.Warning("[Handle]: stream of service %s is broken: %v", s.Name, err)
+		}
+
+		return
+	}
+}
@@ -0,0 +1,257 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/balazskvancz/gorouter"
+)
+
+// newTestGateway creates a gateway – without listening –, which proxies to the given service.
+func newTestGateway(t *testing.T, srv *httptest.Server, conf *ServiceConfig) *Gateway {
+	gw := &Gateway{info: &GatewayInfo{}, serviceRegisty: newRegistry()}
+
+	gw.router = gorouter.New(
+		gorouter.WithNotFoundHandler(gw.serve),
+		gorouter.WithEmptyTreeHandler(gw.serve),
+		gorouter.WithBodyReader(gw.readBody),
+	)
+
+	s := newTestService(t, srv, conf)
+
+	if err := gw.serviceRegisty.addService(s.ServiceConfig); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	gw.serviceRegisty.getServiceByName(conf.Name).setState(StateAvailable)
+
+	return gw
+}
+
+func TestIsStreamingResponse(t *testing.T) {
+	type testCase struct {
+		name          string
+		contentType   string
+		contentLength int64
+		expected      bool
+	}
+
+	tt := []testCase{
+		{
+			name:          "the event stream is streamed",
+			contentType:   "text/event-stream; charset=utf-8",
+			contentLength: 128,
+			expected:      true,
+		},
+		{
+			name:          "the response of unknown length is streamed",
+			contentType:   JsonContentType,
+			contentLength: -1,
+			expected:      true,
+		},
+		{
+			name:          "the response of known length is not streamed",
+			contentType:   JsonContentType,
+			contentLength: 128,
+			expected:      false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			res := &http.Response{
+				Header:        http.Header{"Content-Type": {tc.contentType}},
+				ContentLength: tc.contentLength,
+			}
+
+			if got := isStreamingResponse(res); got != tc.expected {
+				t.Errorf("expected: %t; got: %t\n", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	ctx, att := newAttempt(context.Background(), 10*time.Millisecond)
+	defer att.done()
+
+	<-ctx.Done()
+
+	err := att.wrapErr(ctx.Err())
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error: %v; got error: %v\n", context.DeadlineExceeded, err)
+	}
+
+	if class := getErrorClass(err); class != RetryOnTimeout {
+		t.Errorf("expected class: %s; got class: %s\n", RetryOnTimeout, class)
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	var (
+		release   = make(chan struct{})
+		cancelled = make(chan struct{})
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", eventStreamContentType)
+		w.WriteHeader(http.StatusOK)
+
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-release:
+			io.WriteString(w, "data: second\n\n")
+		case <-r.Context().Done():
+			close(cancelled)
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	gw := newTestGateway(t, srv, &ServiceConfig{
+		Name:       "mock-name",
+		Prefix:     "/api/mock",
+		TimeOutSec: 1,
+	})
+
+	gwSrv := httptest.NewServer(gw)
+	defer gwSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, gwSrv.URL+"/api/mock/events", nil)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != eventStreamContentType {
+		t.Errorf("expected content type: %s; got content type: %s\n", eventStreamContentType, ct)
+	}
+
+	// The first event arrives, while the service is still sending,
+	// and even after the timeout of the service is over.
+	time.Sleep(1100 * time.Millisecond)
+
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	if line != "data: first\n" {
+		t.Errorf("expected line: %q; got line: %q\n", "data: first\n", line)
+	}
+
+	// The disconnect of the client cancels the request to the service.
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected the request to the service to be canceled\n")
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+
+		io.WriteString(w, "chunk")
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	gw := newTestGateway(t, srv, &ServiceConfig{
+		Name:              "mock-name",
+		Prefix:            "/api/mock",
+		StreamIdleTimeout: "50ms",
+	})
+
+	gwSrv := httptest.NewServer(gw)
+	defer gwSrv.Close()
+
+	res, err := http.Get(gwSrv.URL + "/api/mock/chunks")
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+	defer res.Body.Close()
+
+	done := make(chan string, 1)
+	go func() {
+		b, _ := io.ReadAll(res.Body)
+		done <- string(b)
+	}()
+
+	select {
+	case body := <-done:
+		if !strings.HasPrefix(body, "chunk") {
+			t.Errorf("expected body: %s; got body: %s\n", "chunk", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected the idle stream to be cut\n")
+	}
+}
+
+func TestServeHTTPEncodedSlash(t *testing.T) {
+	type testCase struct {
+		name      string
+		reject    bool
+		url       string
+		expStatus int
+		expCalls  int
+	}
+
+	var calls int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+
+	gw := newTestGateway(t, srv, &ServiceConfig{
+		Name:       "mock-name",
+		Prefix:     "/api/mock",
+		TimeOutSec: 1,
+	})
+
+	tt := []testCase{
+		{name: "the encoded slash is served by default", url: "/api/mock/a%2Fb", expStatus: http.StatusOK, expCalls: 1},
+		{name: "the encoded slash is rejected", reject: true, url: "/api/mock%2Fadmin", expStatus: http.StatusBadRequest, expCalls: 0},
+		{name: "the lowercase encoded slash is rejected", reject: true, url: "/api/mock/a%2fb", expStatus: http.StatusBadRequest, expCalls: 0},
+		{name: "the encoded backslash is rejected", reject: true, url: "/api/mock/a%5Cb", expStatus: http.StatusBadRequest, expCalls: 0},
+		{name: "the encoded slash of the query is kept", reject: true, url: "/api/mock/a?next=%2Fb", expStatus: http.StatusOK, expCalls: 1},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			calls = 0
+			gw.info.rejectEncodedSlashes = tc.reject
+
+			rec := httptest.NewRecorder()
+			gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("expected status code: %d; got status code: %d\n", tc.expStatus, rec.Code)
+			}
+
+			if calls != tc.expCalls {
+				t.Errorf("expected calls: %d; got calls: %d\n", tc.expCalls, calls)
+			}
+		})
+	}
+}
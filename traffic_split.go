@@ -0,0 +1,191 @@
+package gateway
+
+import (
+	"hash/fnv"
+	"net/http"
+)
+
+const (
+	// The prefix of the service which was matched by the url,
+	// in case the request is served by an other version of it.
+	matchedPrefixKey ContextKey = "matchedPrefix"
+)
+
+// TrafficSplitConfig describes how the traffic of a service is split
+// amongst its versions. The versions are registered services, referred
+// by their names, while the split is bound to the prefix – and hosts –
+// of the service given by its name.
+type TrafficSplitConfig struct {
+	// The name of the service, whose traffic is split.
+	ServiceName string `json:"serviceName"`
+
+	// The rules are evaluated in order, before the weights.
+	// The first matching rule selects the version.
+	Rules []*SplitRule `json:"rules"`
+
+	// The versions with their weights. The weights are relative to
+	// each other, so with 1 and 99 the first version gets 1% of the traffic.
+	Versions []*SplitVersion `json:"versions"`
+
+	// The name of the header or cookie, which identifies the client.
+	// If it is present, the same client always gets the same version
+	// – as long as the weights are unchanged –, otherwise it is random.
+	StickyHeader string `json:"stickyHeader"`
+	StickyCookie string `json:"stickyCookie"`
+}
+
+// SplitVersion is a version of a service with its weight.
+type SplitVersion struct {
+	ServiceName string `json:"serviceName"`
+	Weight      int    `json:"weight"`
+}
+
+// SplitRule selects the version by the value of a header or a cookie.
+// If the value is empty, then any non-empty value matches.
+type SplitRule struct {
+	Header      string `json:"header"`
+	Cookie      string `json:"cookie"`
+	Value       string `json:"value"`
+	ServiceName string `json:"serviceName"`
+}
+
+type trafficSplit struct {
+	*TrafficSplitConfig
+
+	totalWeight int
+}
+
+func newTrafficSplit(conf *TrafficSplitConfig) *trafficSplit {
+	total := reduce(conf.Versions, func(acc int, v *SplitVersion) int {
+		return acc + v.Weight
+	}, 0)
+
+	return &trafficSplit{
+		TrafficSplitConfig: conf,
+		totalWeight:        total,
+	}
+}
+
+// validateTrafficSplit validates the given config. Every referred
+// service must be known by the given function.
+func validateTrafficSplit(conf *TrafficSplitConfig, exists func(string) bool) error {
+	if conf == nil {
+		return errConfigIsNil
+	}
+
+	if conf.ServiceName == "" || !exists(conf.ServiceName) {
+		return ErrServiceNotExists
+	}
+
+	if len(conf.Versions) == 0 && len(conf.Rules) == 0 {
+		return errEmptySplit
+	}
+
+	for _, v := range conf.Versions {
+		if v == nil || v.Weight < 0 {
+			return errBadSplitVersion
+		}
+		if !exists(v.ServiceName) {
+			return ErrServiceNotExists
+		}
+	}
+
+	for _, r := range conf.Rules {
+		if r == nil || (r.Header == "") == (r.Cookie == "") {
+			return errBadSplitRule
+		}
+		if !exists(r.ServiceName) {
+			return ErrServiceNotExists
+		}
+	}
+
+	return nil
+}
+
+// refersTo tells if the split, one of its versions or rules refers to the service by the given name.
+func (ts *trafficSplit) refersTo(name string) bool {
+	if ts.ServiceName == name {
+		return true
+	}
+
+	for _, v := range ts.Versions {
+		if v.ServiceName == name {
+			return true
+		}
+	}
+
+	for _, r := range ts.Rules {
+		if r.ServiceName == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pick returns the name of the version which should serve the given request.
+// If none of the rules match and there is no weight, it returns empty string.
+func (ts *trafficSplit) pick(r *http.Request) string {
+	for _, rule := range ts.Rules {
+		if rule.matches(r) {
+			return rule.ServiceName
+		}
+	}
+
+	if ts.totalWeight <= 0 {
+		return ""
+	}
+
+	bucket := func() int {
+		if key := ts.getStickyKey(r); key != "" {
+			h := fnv.New32a()
+			h.Write([]byte(key))
+
+			return int(h.Sum32() % uint32(ts.totalWeight))
+		}
+		return randomIntn(ts.totalWeight)
+	}()
+
+	for _, v := range ts.Versions {
+		if bucket < v.Weight {
+			return v.ServiceName
+		}
+		bucket -= v.Weight
+	}
+
+	return ""
+}
+
+func (ts *trafficSplit) getStickyKey(r *http.Request) string {
+	if ts.StickyHeader != "" {
+		if v := r.Header.Get(ts.StickyHeader); v != "" {
+			return v
+		}
+	}
+
+	if ts.StickyCookie != "" {
+		if c, err := r.Cookie(ts.StickyCookie); err == nil {
+			return c.Value
+		}
+	}
+
+	return ""
+}
+
+func (sr *SplitRule) matches(r *http.Request) bool {
+	value := func() string {
+		if sr.Header != "" {
+			return r.Header.Get(sr.Header)
+		}
+		if c, err := r.Cookie(sr.Cookie); err == nil {
+			return c.Value
+		}
+		return ""
+	}()
+
+	if value == "" {
+		return false
+	}
+
+	return sr.Value == "" || sr.Value == value
+}
@@ -0,0 +1,207 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestValidateTrafficSplit(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *TrafficSplitConfig
+		err  error
+	}
+
+	exists := func(name string) bool {
+		return name == "v1" || name == "v2"
+	}
+
+	tt := []testCase{
+		{
+			name: "the function returns error if the config is nil",
+			conf: nil,
+			err:  errConfigIsNil,
+		},
+		{
+			name: "the function returns error if the split service does not exist",
+			conf: &TrafficSplitConfig{ServiceName: "v3", Versions: []*SplitVersion{{ServiceName: "v1", Weight: 1}}},
+			err:  ErrServiceNotExists,
+		},
+		{
+			name: "the function returns error if there are neither versions nor rules",
+			conf: &TrafficSplitConfig{ServiceName: "v1"},
+			err:  errEmptySplit,
+		},
+		{
+			name: "the function returns error if a weight is negative",
+			conf: &TrafficSplitConfig{ServiceName: "v1", Versions: []*SplitVersion{{ServiceName: "v2", Weight: -1}}},
+			err:  errBadSplitVersion,
+		},
+		{
+			name: "the function returns error if a version does not exist",
+			conf: &TrafficSplitConfig{ServiceName: "v1", Versions: []*SplitVersion{{ServiceName: "v3", Weight: 1}}},
+			err:  ErrServiceNotExists,
+		},
+		{
+			name: "the function returns error if a rule has both header and cookie",
+			conf: &TrafficSplitConfig{ServiceName: "v1", Rules: []*SplitRule{{Header: "X-Canary", Cookie: "canary", ServiceName: "v2"}}},
+			err:  errBadSplitRule,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &TrafficSplitConfig{ServiceName: "v1", Versions: []*SplitVersion{{ServiceName: "v1", Weight: 99}, {ServiceName: "v2", Weight: 1}}},
+			err:  nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateTrafficSplit(tc.conf, exists); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestTrafficSplitPick(t *testing.T) {
+	type testCase struct {
+		name     string
+		conf     *TrafficSplitConfig
+		header   http.Header
+		expected string
+	}
+
+	tt := []testCase{
+		{
+			name: "the matching header rule selects the version",
+			conf: &TrafficSplitConfig{
+				Rules:    []*SplitRule{{Header: "X-Canary", Value: "1", ServiceName: "v2"}},
+				Versions: []*SplitVersion{{ServiceName: "v1", Weight: 1}},
+			},
+			header:   http.Header{"X-Canary": []string{"1"}},
+			expected: "v2",
+		},
+		{
+			name: "the rule with empty value matches any value of the cookie",
+			conf: &TrafficSplitConfig{
+				Rules: []*SplitRule{{Cookie: "beta", ServiceName: "v2"}},
+			},
+			header:   http.Header{"Cookie": []string{"beta=yes"}},
+			expected: "v2",
+		},
+		{
+			name: "the weights are used if no rule matches",
+			conf: &TrafficSplitConfig{
+				Rules:    []*SplitRule{{Header: "X-Canary", Value: "1", ServiceName: "v2"}},
+				Versions: []*SplitVersion{{ServiceName: "v1", Weight: 1}, {ServiceName: "v2", Weight: 0}},
+			},
+			header:   http.Header{"X-Canary": []string{"0"}},
+			expected: "v1",
+		},
+		{
+			name: "the function returns empty string if there is no match and no weight",
+			conf: &TrafficSplitConfig{
+				Rules: []*SplitRule{{Header: "X-Canary", ServiceName: "v2"}},
+			},
+			header:   http.Header{},
+			expected: "",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				ts = newTrafficSplit(tc.conf)
+				r  = &http.Request{Header: tc.header}
+			)
+
+			if got := ts.pick(r); got != tc.expected {
+				t.Errorf("expected version: %s; got version: %s\n", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestTrafficSplitSticky(t *testing.T) {
+	ts := newTrafficSplit(&TrafficSplitConfig{
+		Versions:     []*SplitVersion{{ServiceName: "v1", Weight: 50}, {ServiceName: "v2", Weight: 50}},
+		StickyHeader: "X-User-Id",
+	})
+
+	picked := make(map[string]int)
+
+	for i := 0; i < 100; i++ {
+		r := &http.Request{Header: http.Header{"X-User-Id": []string{fmt.Sprintf("user-%d", i)}}}
+
+		first := ts.pick(r)
+		for j := 0; j < 5; j++ {
+			if got := ts.pick(r); got != first {
+				t.Fatalf("expected the same version for the same client: %s; got version: %s\n", first, got)
+			}
+		}
+
+		picked[first]++
+	}
+
+	if picked["v1"] == 0 || picked["v2"] == 0 {
+		t.Errorf("expected both versions to be picked; got: %v\n", picked)
+	}
+}
+
+func TestResolveSplit(t *testing.T) {
+	r := newRegistry()
+
+	for _, conf := range []*ServiceConfig{
+		{Name: "v1", Prefix: "/api/foo", Host: "localhost", Port: "3001", Protocol: "http"},
+		{Name: "v2", Prefix: "/internal/foo-v2", Host: "localhost", Port: "3002", Protocol: "http"},
+	} {
+		if err := r.addService(conf); err != nil {
+			t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+		}
+	}
+
+	split := &TrafficSplitConfig{
+		ServiceName: "v1",
+		Rules:       []*SplitRule{{Header: "X-Canary", ServiceName: "v2"}},
+	}
+
+	if err := r.setTrafficSplit(split); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	var (
+		s      = r.findService("/api/foo/bar")
+		canary = &http.Request{Header: http.Header{"X-Canary": []string{"1"}}}
+	)
+
+	if got := r.resolveSplit(s, canary); got.Name != "v2" {
+		t.Errorf("expected service: %s; got service: %s\n", "v2", got.Name)
+	}
+
+	if got := r.resolveSplit(s, &http.Request{Header: http.Header{}}); got.Name != "v1" {
+		t.Errorf("expected service: %s; got service: %s\n", "v1", got.Name)
+	}
+
+	// The version can not be removed, while the split refers to it.
+	if err := r.removeService("v2"); !errors.Is(err, errServiceInSplit) {
+		t.Fatalf("expected error: %v; got error: %v\n", errServiceInSplit, err)
+	}
+
+	if got := r.resolveSplit(s, canary); got.Name != "v2" {
+		t.Errorf("expected service: %s; got service: %s\n", "v2", got.Name)
+	}
+
+	if err := r.removeTrafficSplit("v1"); err != nil {
+		t.Errorf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	if err := r.removeService("v2"); err != nil {
+		t.Errorf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	if err := r.removeTrafficSplit("v1"); !errors.Is(err, ErrServiceNotExists) {
+		t.Errorf("expected error: %v; got error: %v\n", ErrServiceNotExists, err)
+	}
+}
@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 32
+	defaultIdleConnTimeout     = 90 * time.Second
+
+	transportDialTimeout = 5 * time.Second
+	transportKeepAlive   = 30 * time.Second
+)
+
+// TransportConfig is the settings of the connections to the instances of a service.
+// The connections are kept alive, and reused by the requests of the service.
+type TransportConfig struct {
+	// The maximum count of idle connections to all the instances. By default it is 100.
+	MaxIdleConns int `json:"maxIdleConns"`
+
+	// The maximum count of idle connections to one instance. By default it is 32.
+	MaxIdleConnsPerHost int `json:"maxIdleConnsPerHost"`
+
+	// The maximum count of connections to one instance – in any state.
+	// The requests above it wait for a free connection. By default there is no limit.
+	MaxConnsPerHost int `json:"maxConnsPerHost"`
+
+	// How long an idle connection is kept – eg. "30s". By default it is 90 seconds.
+	IdleConnTimeout string `json:"idleConnTimeout"`
+
+	// Every connection is closed after its request – as it was before the pooling.
+	DisableKeepAlives bool `json:"disableKeepAlives"`
+
+	// The requests are sent by HTTP/2. With https it is negotiated, falling back to
+	// HTTP/1.1, while with http the instances must speak h2c – HTTP/2 without TLS.
+	HTTP2 bool `json:"http2"`
+}
+
+// newTransport creates the transport – shared by the instances of a
+// service – from the given, already validated config.
+func newTransport(conf *TransportConfig, protocol string, ut *upstreamTLS) http.RoundTripper {
+	if conf == nil {
+		conf = &TransportConfig{}
+	}
+
+	idleConnTimeout := defaultIdleConnTimeout
+	if d, err := time.ParseDuration(conf.IdleConnTimeout); err == nil {
+		idleConnTimeout = d
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   transportDialTimeout,
+		KeepAlive: transportKeepAlive,
+	}
+
+	// The plaintext HTTP/2 can not be negotiated, so the instances are spoken to
+	// by HTTP/2 right away. The requests are multiplexed on one connection per
+	// instance, so the limits and the idle timeout below do not apply.
+	if conf.HTTP2 && protocol == "http" {
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		}
+	}
+
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   conf.HTTP2,
+		MaxIdleConns:        getPositiveOr(conf.MaxIdleConns, defaultMaxIdleConns),
+		MaxIdleConnsPerHost: getPositiveOr(conf.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost),
+		MaxConnsPerHost:     conf.MaxConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+		DisableKeepAlives:   conf.DisableKeepAlives,
+		TLSClientConfig:     ut.getConfig(""),
+
+		TLSHandshakeTimeout:   transportDialTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	// The shared config does not know the instance of the connection, so the
+	// connections are dialed by a copy of it, which verifies their host. Only
+	// the connections through a proxy are verified by the shared config.
+	if ut != nil {
+		t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+
+			d := &tls.Dialer{
+				NetDialer: dialer,
+				Config:    ut.withHost(t.TLSClientConfig, host),
+			}
+
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+
+	return t
+}
+
+// validateTransport validates the given config.
+// It returns the first error that occured.
+func validateTransport(conf *TransportConfig) error {
+	if conf == nil {
+		return nil
+	}
+
+	if conf.MaxIdleConns < 0 || conf.MaxIdleConnsPerHost < 0 || conf.MaxConnsPerHost < 0 {
+		return errBadTransportLimit
+	}
+
+	if conf.IdleConnTimeout != "" {
+		if d, err := time.ParseDuration(conf.IdleConnTimeout); err != nil || d <= 0 {
+			return errBadTransportDuration
+		}
+	}
+
+	return nil
+}
+
+// closeIdleConnections closes the idle connections of the given transport.
+func closeIdleConnections(rt http.RoundTripper) {
+	if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// getPositiveOr returns v, if it is positive, otherwise the given default.
+func getPositiveOr(v int, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,182 @@
+package gateway
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newCountingServer creates a service, which counts its new connections,
+// and responds with the protocol of the request in the body.
+func newCountingServer(conns *int64, isH2C bool) *httptest.Server {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", JsonContentType)
+		w.Write([]byte(r.Proto))
+	})
+
+	if isH2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(conns, 1)
+		}
+	}
+	srv.Start()
+
+	return srv
+}
+
+func TestValidateTransport(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *TransportConfig
+		err  error
+	}
+
+	tt := []testCase{
+		{
+			name: "the function returns nil if there is no config",
+			conf: nil,
+			err:  nil,
+		},
+		{
+			name: "the function returns error if a limit is negative",
+			conf: &TransportConfig{MaxConnsPerHost: -1},
+			err:  errBadTransportLimit,
+		},
+		{
+			name: "the function returns error if the idle timeout is invalid",
+			conf: &TransportConfig{IdleConnTimeout: "foo"},
+			err:  errBadTransportDuration,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &TransportConfig{MaxIdleConns: 10, MaxIdleConnsPerHost: 5, IdleConnTimeout: "30s", HTTP2: true},
+			err:  nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateTransport(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestTransportConnections(t *testing.T) {
+	type testCase struct {
+		name      string
+		conf      *TransportConfig
+		isH2C     bool
+		expConns  int64
+		expProto  string
+		requestsN int
+	}
+
+	tt := []testCase{
+		{
+			name:      "the connection is reused by the requests",
+			conf:      nil,
+			expConns:  1,
+			expProto:  "HTTP/1.1",
+			requestsN: 5,
+		},
+		{
+			name:      "every request opens a new connection, if keep-alive is disabled",
+			conf:      &TransportConfig{DisableKeepAlives: true},
+			expConns:  5,
+			expProto:  "HTTP/1.1",
+			requestsN: 5,
+		},
+		{
+			name:      "the requests are sent by h2c to the plaintext service",
+			conf:      &TransportConfig{HTTP2: true},
+			isH2C:     true,
+			expConns:  1,
+			expProto:  "HTTP/2.0",
+			requestsN: 5,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var conns int64
+
+			srv := newCountingServer(&conns, tc.isH2C)
+			defer srv.Close()
+
+			s := newTestService(t, srv, &ServiceConfig{
+				Name:      "mock-name",
+				Prefix:    "/api/mock",
+				Transport: tc.conf,
+			})
+			defer s.close()
+
+			for i := 0; i < tc.requestsN; i++ {
+				ctx, rec := newTestContext(httptest.NewRequest(http.MethodGet, "/api/mock/foo", nil))
+
+				s.Handle(ctx)
+				ctx.WriteToResponseNow()
+
+				if body := rec.Body.String(); body != tc.expProto {
+					t.Errorf("expected protocol: %s; got protocol: %s\n", tc.expProto, body)
+				}
+			}
+
+			if got := atomic.LoadInt64(&conns); got != tc.expConns {
+				t.Errorf("expected connections: %d; got connections: %d\n", tc.expConns, got)
+			}
+		})
+	}
+}
+
+func BenchmarkHandle(b *testing.B) {
+	type benchCase struct {
+		name  string
+		conf  *TransportConfig
+		isH2C bool
+	}
+
+	bb := []benchCase{
+		{name: "close", conf: &TransportConfig{DisableKeepAlives: true}},
+		{name: "keep-alive", conf: nil},
+		{name: "h2c", conf: &TransportConfig{HTTP2: true}, isH2C: true},
+	}
+
+	for _, bc := range bb {
+		b.Run(bc.name, func(b *testing.B) {
+			var conns int64
+
+			srv := newCountingServer(&conns, bc.isH2C)
+			defer srv.Close()
+
+			s := newTestService(&testing.T{}, srv, &ServiceConfig{
+				Name:      "mock-name",
+				Prefix:    "/api/mock",
+				Transport: bc.conf,
+			})
+			defer s.close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				ctx, _ := newTestContext(httptest.NewRequest(http.MethodGet, "/api/mock/foo", nil))
+
+				s.Handle(ctx)
+				ctx.WriteToResponseNow()
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+const (
+	defaultTLSMinVersion = tls.VersionTLS12
+)
+
+// UpstreamTLSConfig is the TLS settings of the connections to the instances
+// of a service, whose protocol is https – both REST and gRPC. The files
+// are reloaded, once they are changed.
+type UpstreamTLSConfig struct {
+	// The path of the PEM encoded CA bundle, which the certificates of the
+	// instances are verified by. By default the system roots are used.
+	CAFile string `json:"caFile"`
+
+	// The paths of the PEM encoded client certificate and its key,
+	// which are presented to the instances – eg. for mutual TLS.
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+
+	// The server name, which is sent by SNI, and the certificates
+	// are verified against. By default it is the host of the instance.
+	ServerName string `json:"serverName"`
+
+	// The minimum TLS version – one of 1.0, 1.1, 1.2 or 1.3. By default it is 1.2.
+	MinVersion string `json:"minVersion"`
+
+	// The certificates of the instances are not verified at all.
+	// It must only be used for development.
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+}
+
+// watchedFile is a file, which is parsed again, once its modification time changes.
+type watchedFile struct {
+	path    string
+	modTime time.Time
+}
+
+// isChanged tells if the file was modified since it was last seen, and
+// remembers its current modification time. A missing file is not a change.
+func (wf *watchedFile) isChanged() bool {
+	info, err := os.Stat(wf.path)
+	if err != nil || info.ModTime().Equal(wf.modTime) {
+		return false
+	}
+
+	wf.modTime = info.ModTime()
+
+	return true
+}
+
+// certPool is a CA bundle, which is read again, once its file is changed.
+type certPool struct {
+	mu   sync.Mutex
+	file *watchedFile
+	pool *x509.CertPool
+}
+
+// upstreamTLS holds the current CA bundle and client certificate of a service.
+type upstreamTLS struct {
+	insecureSkipVerify bool
+	serverName         string
+
+	roots *certPool
+
+	// The config shared by the connections, whose certificates are
+	// verified against the server name of the handshake.
+	config *tls.Config
+
+	mu       sync.Mutex
+	certFile *watchedFile
+	keyFile  *watchedFile
+	cert     *tls.Certificate
+}
+
+// newUpstreamTLS creates the client TLS of a service from the given – already
+// validated – config. The CA bundle and the client certificate are read on each
+// handshake, if they changed, so they can be rotated without a restart. It
+// returns nil, if there is no config, so the defaults of Go are used.
+func newUpstreamTLS(conf *UpstreamTLSConfig) *upstreamTLS {
+	if conf == nil {
+		return nil
+	}
+
+	ut := &upstreamTLS{
+		insecureSkipVerify: conf.InsecureSkipVerify,
+		serverName:         conf.ServerName,
+	}
+
+	if conf.CAFile != "" {
+		ut.roots = newCertPool(conf.CAFile)
+	}
+
+	if conf.CertFile != "" {
+		ut.certFile = &watchedFile{path: conf.CertFile}
+		ut.keyFile = &watchedFile{path: conf.KeyFile}
+	}
+
+	minVersion, ok := tlsVersions[conf.MinVersion]
+	if !ok {
+		minVersion = defaultTLSMinVersion
+	}
+
+	ut.config = &tls.Config{
+		ServerName: conf.ServerName,
+		MinVersion: minVersion,
+
+		// The verification of Go is skipped, since the certificates
+		// are verified by verifyConnection with the current roots.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return ut.verifyConnection(cs, "")
+		},
+
+		GetClientCertificate: ut.getClientCertificate,
+	}
+
+	return ut
+}
+
+// getConfig returns the TLS config of the connections to the given host, or
+// nil if there is no config, so the defaults of Go are used.
+func (ut *upstreamTLS) getConfig(host string) *tls.Config {
+	if ut == nil {
+		return nil
+	}
+
+	return ut.withHost(ut.config, host)
+}
+
+// withHost returns a copy of the given config, whose certificates are verified
+// against the given host – unless there is a configured server name. The server
+// name of the handshake can not be used for it, since it is empty for the ips.
+func (ut *upstreamTLS) withHost(config *tls.Config, host string) *tls.Config {
+	c := config.Clone()
+
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		return ut.verifyConnection(cs, host)
+	}
+
+	return c
+}
+
+// validateUpstreamTLS validates the given config, and reads its files.
+// It returns the first error that occured.
+func validateUpstreamTLS(conf *UpstreamTLSConfig) error {
+	if conf == nil {
+		return nil
+	}
+
+	if _, ok := tlsVersions[conf.MinVersion]; conf.MinVersion != "" && !ok {
+		return errBadTLSVersion
+	}
+
+	if (conf.CertFile == "") != (conf.KeyFile == "") {
+		return errBadTLSKeyPair
+	}
+
+	if conf.CAFile != "" {
+		if _, err := readCertPool(conf.CAFile); err != nil {
+			return err
+		}
+	}
+
+	if conf.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile); err != nil {
+			return errBadTLSFile
+		}
+	}
+
+	return nil
+}
+
+// readCertPool reads the PEM encoded certificates of the given file into a pool.
+func readCertPool(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errBadTLSFile
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errBadTLSFile
+	}
+
+	return pool, nil
+}
+
+// newCertPool creates the CA bundle of the given file, which is read on first use.
+func newCertPool(path string) *certPool {
+	return &certPool{file: &watchedFile{path: path}}
+}
+
+// get returns the current CA bundle, or nil if there is none, so the system
+// roots are used. If the file can not be read, the last good bundle is kept.
+func (cp *certPool) get() *x509.CertPool {
+	if cp == nil {
+		return nil
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if cp.file.isChanged() || cp.pool == nil {
+		if pool, err := readCertPool(cp.file.path); err == nil {
+			cp.pool = pool
+		}
+	}
+
+	return cp.pool
+}
+
+// getClientCertificate returns the current client certificate. If the
+// files can not be read, the last good certificate is kept.
+func (ut *upstreamTLS) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if ut.certFile == nil {
+		return &tls.Certificate{}, nil
+	}
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	// Both files must be checked, so their times are remembered.
+	certChanged := ut.certFile.isChanged()
+	keyChanged := ut.keyFile.isChanged()
+
+	if certChanged || keyChanged || ut.cert == nil {
+		if cert, err := tls.LoadX509KeyPair(ut.certFile.path, ut.keyFile.path); err == nil {
+			ut.cert = &cert
+		}
+	}
+
+	if ut.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+
+	return ut.cert, nil
+}
+
+// verifyConnection verifies the certificate chain of the instance against the
+// current roots, and the configured server name, or else the given host of the
+// instance, or else the server name of the handshake. If none of them is known,
+// the connection is rejected, so the name is never skipped.
+func (ut *upstreamTLS) verifyConnection(cs tls.ConnectionState, host string) error {
+	if ut.insecureSkipVerify {
+		return nil
+	}
+
+	if len(cs.PeerCertificates) == 0 {
+		return errNoPeerCertificate
+	}
+
+	name := getStringOr(ut.serverName, getStringOr(host, cs.ServerName))
+	if name == "" {
+		return errNoServerName
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         ut.roots.get(),
+		DNSName:       name,
+		Intermediates: x509.NewCertPool(),
+	}
+
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(opts)
+
+	return err
+}
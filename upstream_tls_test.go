@@ -0,0 +1,443 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority, which issues the certificates of the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mock-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a new PEM encoded certificate and key signed by
+// the CA, which is valid for the given name and for 127.0.0.1.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	return ca.issueFor(t, name, usage, net.ParseIP("127.0.0.1"))
+}
+
+// issueFor returns a new PEM encoded certificate and key signed by
+// the CA, which is only valid for the given name and ips.
+func (ca *testCA) issueFor(t *testing.T, name string, usage x509.ExtKeyUsage, ips ...net.IP) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  ips,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// writeTestFile writes the given content into the dir, and returns its path.
+func writeTestFile(t *testing.T, dir string, name string, b []byte) string {
+	path := filepath.Join(dir, name)
+
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	return path
+}
+
+// newMutualTLSServer creates a service, which requires a client certificate issued by the CA.
+func newMutualTLSServer(t *testing.T, ca *testCA) *httptest.Server {
+	certPEM, keyPEM := ca.issue(t, "mock.internal", x509.ExtKeyUsageServerAuth)
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+
+	// The failed handshakes are expected by the tests.
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	srv.StartTLS()
+
+	return srv
+}
+
+// newTestTLSService creates an available https service, which proxies to the given server.
+func newTestTLSService(t *testing.T, srv *httptest.Server, conf *UpstreamTLSConfig) *service {
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	sc := &ServiceConfig{
+		Name:     "mock-name",
+		Prefix:   "/api/mock",
+		Protocol: "https",
+		Host:     u.Hostname(),
+		Port:     u.Port(),
+		TLS:      conf,
+	}
+
+	if err := validateService(sc); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	s := newService(sc)
+	s.setState(StateAvailable)
+
+	return s
+}
+
+func TestValidateUpstreamTLS(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *UpstreamTLSConfig
+		err  error
+	}
+
+	var (
+		dir     = t.TempDir()
+		ca      = newTestCA(t)
+		caFile  = writeTestFile(t, dir, "ca.pem", ca.pem)
+		badFile = writeTestFile(t, dir, "bad.pem", []byte("mock-content"))
+	)
+
+	tt := []testCase{
+		{
+			name: "the function returns nil if there is no config",
+			conf: nil,
+			err:  nil,
+		},
+		{
+			name: "the function returns error if the min version is unknown",
+			conf: &UpstreamTLSConfig{MinVersion: "2.0"},
+			err:  errBadTLSVersion,
+		},
+		{
+			name: "the function returns error if the key is missing",
+			conf: &UpstreamTLSConfig{CertFile: caFile},
+			err:  errBadTLSKeyPair,
+		},
+		{
+			name: "the function returns error if the CA bundle is invalid",
+			conf: &UpstreamTLSConfig{CAFile: badFile},
+			err:  errBadTLSFile,
+		},
+		{
+			name: "the function returns error if the CA bundle does not exist",
+			conf: &UpstreamTLSConfig{CAFile: filepath.Join(dir, "missing.pem")},
+			err:  errBadTLSFile,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &UpstreamTLSConfig{CAFile: caFile, MinVersion: "1.3"},
+			err:  nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateUpstreamTLS(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestUpstreamMutualTLS(t *testing.T) {
+	type testCase struct {
+		name    string
+		getConf func(dir string) *UpstreamTLSConfig
+		expCode int
+	}
+
+	var (
+		ca      = newTestCA(t)
+		otherCA = newTestCA(t)
+
+		clientCert, clientKey = ca.issue(t, "mock-client", x509.ExtKeyUsageClientAuth)
+	)
+
+	srv := newMutualTLSServer(t, ca)
+	defer srv.Close()
+
+	tt := []testCase{
+		{
+			name: "the request is sent with the client certificate, verified by the CA",
+			getConf: func(dir string) *UpstreamTLSConfig {
+				return &UpstreamTLSConfig{
+					CAFile:   writeTestFile(t, dir, "ca.pem", ca.pem),
+					CertFile: writeTestFile(t, dir, "cert.pem", clientCert),
+					KeyFile:  writeTestFile(t, dir, "key.pem", clientKey),
+				}
+			},
+			expCode: http.StatusOK,
+		},
+		{
+			name: "the request fails without client certificate",
+			getConf: func(dir string) *UpstreamTLSConfig {
+				return &UpstreamTLSConfig{CAFile: writeTestFile(t, dir, "ca.pem", ca.pem)}
+			},
+			expCode: http.StatusInternalServerError,
+		},
+		{
+			name: "the request fails, if the certificate is issued by an unknown CA",
+			getConf: func(dir string) *UpstreamTLSConfig {
+				return &UpstreamTLSConfig{
+					CAFile:   writeTestFile(t, dir, "ca.pem", otherCA.pem),
+					CertFile: writeTestFile(t, dir, "cert.pem", clientCert),
+					KeyFile:  writeTestFile(t, dir, "key.pem", clientKey),
+				}
+			},
+			expCode: http.StatusInternalServerError,
+		},
+		{
+			name: "the request fails, if the server name does not match",
+			getConf: func(dir string) *UpstreamTLSConfig {
+				return &UpstreamTLSConfig{
+					CAFile:     writeTestFile(t, dir, "ca.pem", ca.pem),
+					CertFile:   writeTestFile(t, dir, "cert.pem", clientCert),
+					KeyFile:    writeTestFile(t, dir, "key.pem", clientKey),
+					ServerName: "other.internal",
+				}
+			},
+			expCode: http.StatusInternalServerError,
+		},
+		{
+			name: "the request is sent to the matching server name",
+			getConf: func(dir string) *UpstreamTLSConfig {
+				return &UpstreamTLSConfig{
+					CAFile:     writeTestFile(t, dir, "ca.pem", ca.pem),
+					CertFile:   writeTestFile(t, dir, "cert.pem", clientCert),
+					KeyFile:    writeTestFile(t, dir, "key.pem", clientKey),
+					ServerName: "mock.internal",
+				}
+			},
+			expCode: http.StatusOK,
+		},
+		{
+			name: "the unknown CA is accepted, if the verify is skipped",
+			getConf: func(dir string) *UpstreamTLSConfig {
+				return &UpstreamTLSConfig{
+					CAFile:             writeTestFile(t, dir, "ca.pem", otherCA.pem),
+					CertFile:           writeTestFile(t, dir, "cert.pem", clientCert),
+					KeyFile:            writeTestFile(t, dir, "key.pem", clientKey),
+					InsecureSkipVerify: true,
+				}
+			},
+			expCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestTLSService(t, srv, tc.getConf(t.TempDir()))
+			defer s.close()
+
+			ctx, rec := newTestContext(httptest.NewRequest(http.MethodGet, "/api/mock/foo", nil))
+
+			s.Handle(ctx)
+			ctx.WriteToResponseNow()
+
+			if rec.Code != tc.expCode {
+				t.Errorf("expected status code: %d; got status code: %d\n", tc.expCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestUpstreamTLSReload(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		ca      = newTestCA(t)
+		otherCA = newTestCA(t)
+
+		clientCert, clientKey = ca.issue(t, "mock-client", x509.ExtKeyUsageClientAuth)
+	)
+
+	srv := newMutualTLSServer(t, ca)
+	defer srv.Close()
+
+	caFile := writeTestFile(t, dir, "ca.pem", otherCA.pem)
+
+	s := newTestTLSService(t, srv, &UpstreamTLSConfig{
+		CAFile:   caFile,
+		CertFile: writeTestFile(t, dir, "cert.pem", clientCert),
+		KeyFile:  writeTestFile(t, dir, "key.pem", clientKey),
+	})
+	defer s.close()
+
+	send := func() int {
+		ctx, rec := newTestContext(httptest.NewRequest(http.MethodGet, "/api/mock/foo", nil))
+
+		s.Handle(ctx)
+		ctx.WriteToResponseNow()
+
+		return rec.Code
+	}
+
+	if code := send(); code != http.StatusInternalServerError {
+		t.Errorf("expected status code: %d; got status code: %d\n", http.StatusInternalServerError, code)
+	}
+
+	// The rotated bundle is read on the next handshake.
+	writeTestFile(t, dir, "ca.pem", ca.pem)
+
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(caFile, future, future); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	if code := send(); code != http.StatusOK {
+		t.Errorf("expected status code: %d; got status code: %d\n", http.StatusOK, code)
+	}
+}
+
+func TestUpstreamTLSHost(t *testing.T) {
+	type testCase struct {
+		name       string
+		serverName string
+		expCode    int
+		expError   bool
+	}
+
+	ca := newTestCA(t)
+
+	// The certificate is not valid for the ip of the instance.
+	certPEM, keyPEM := ca.issueFor(t, "other.example", x509.ExtKeyUsageServerAuth)
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// The failed handshakes are expected by the tests.
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	tt := []testCase{
+		{
+			name:     "the certificate is verified against the ip of the instance",
+			expCode:  http.StatusInternalServerError,
+			expError: true,
+		},
+		{
+			name:       "the certificate is verified against the configured server name",
+			serverName: "other.example",
+			expCode:    http.StatusOK,
+			expError:   false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestTLSService(t, srv, &UpstreamTLSConfig{
+				CAFile:     writeTestFile(t, t.TempDir(), "ca.pem", ca.pem),
+				ServerName: tc.serverName,
+			})
+			defer s.close()
+
+			ctx, rec := newTestContext(httptest.NewRequest(http.MethodGet, "/api/mock/foo", nil))
+
+			s.Handle(ctx)
+			ctx.WriteToResponseNow()
+
+			if rec.Code != tc.expCode {
+				t.Errorf("expected status code: %d; got status code: %d\n", tc.expCode, rec.Code)
+			}
+
+			// The websocket and the gRPC connections are dialed by the config of the instance.
+			inst := s.instances[0]
+
+			conn, err := tls.Dial("tcp", inst.GetAddress(), inst.tlsConfig)
+			if err == nil {
+				conn.Close()
+			}
+
+			if isErr := err != nil; isErr != tc.expError {
+				t.Errorf("expected error: %t; got error: %v\n", tc.expError, err)
+			}
+		})
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"math/rand"
+	"net/http"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -49,6 +53,19 @@ func includes[T any](arr []T, el T) bool {
 	return false
 }
 
+var (
+	rndMu sync.Mutex
+	rnd   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// randomIntn returns a random number in [0,n) from the shared, seeded source.
+func randomIntn(n int) int {
+	rndMu.Lock()
+	defer rndMu.Unlock()
+
+	return rnd.Intn(n)
+}
+
 // createHash hashes and returns the given slice of bytes.
 func createHash(plain []byte) []byte {
 	ha := sha256.New()
@@ -98,3 +115,26 @@ func getElapsedTime(since, now time.Time) string {
 
 	return timeString
 }
+
+// isRequestUnderPrefix tells if the request is under the given prefix. It matches
+// the same way as the routing of the services does – by characters, so /api/foo
+// covers /api/foobar too –, both on the raw and on the decoded path, so the
+// encoded characters can not hide the prefix either.
+func isRequestUnderPrefix(ctx Context, prefix string) bool {
+	return strings.HasPrefix(ctx.GetCleanedUrl(), prefix) || strings.HasPrefix(ctx.GetRequest().URL.Path, prefix)
+}
+
+// isUnderPrefix tells if the given url is the prefix itself, or it is under it.
+// The empty prefix matches every url.
+func isUnderPrefix(url string, prefix string) bool {
+	return prefix == "" || url == prefix || strings.HasPrefix(url, prefix+"/")
+}
+
+// hasEncodedSlash tells if the path of the request contains an encoded slash or
+// backslash. The services may decode them, so they would see an other path,
+// than the one which the services and the middlewares were matched by.
+func hasEncodedSlash(r *http.Request) bool {
+	path, _, _ := strings.Cut(strings.ToLower(r.RequestURI), "?")
+
+	return strings.Contains(path, "%2f") || strings.Contains(path, "%5c")
+}
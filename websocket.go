@@ -0,0 +1,441 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultWebSocketIdleTimeout = 5 * time.Minute
+)
+
+// WebSocketConfig is the settings of the upgraded – eg. WebSocket – connections
+// of a service. Once the upstream accepts the upgrade, the connection of the
+// client is tunneled to it, until either side closes it.
+type WebSocketConfig struct {
+	// The maximum count of open tunnels. If it is not given, there is no limit.
+	MaxConnections int `json:"maxConnections"`
+
+	// The tunnel is closed, if neither side sends anything
+	// for this long – eg. "1m". By default it is 5 minutes.
+	IdleTimeout string `json:"idleTimeout"`
+}
+
+// webSocketTunnels tracks the open tunnels of a service.
+type webSocketTunnels struct {
+	maxConnections int
+	idleTimeout    time.Duration
+
+	mu       sync.Mutex
+	reserved int
+	open     map[*tunnel]struct{}
+	closed   bool
+
+	total    uint64
+	rejected uint64
+}
+
+// newWebSocketTunnels creates the tracker of the tunnels
+// from the given – already validated – config.
+func newWebSocketTunnels(conf *WebSocketConfig) *webSocketTunnels {
+	wt := &webSocketTunnels{
+		idleTimeout: defaultWebSocketIdleTimeout,
+		open:        make(map[*tunnel]struct{}),
+	}
+
+	if conf == nil {
+		return wt
+	}
+
+	wt.maxConnections = conf.MaxConnections
+
+	if d, err := time.ParseDuration(conf.IdleTimeout); err == nil {
+		wt.idleTimeout = d
+	}
+
+	return wt
+}
+
+// validateWebSocket validates the given config.
+// It returns the first error that occured.
+func validateWebSocket(conf *WebSocketConfig) error {
+	if conf == nil {
+		return nil
+	}
+
+	if conf.MaxConnections < 0 {
+		return errBadWebSocketLimit
+	}
+
+	if conf.IdleTimeout != "" {
+		if d, err := time.ParseDuration(conf.IdleTimeout); err != nil || d <= 0 {
+			return errBadWebSocketDuration
+		}
+	}
+
+	return nil
+}
+
+// reserve takes a slot for a new tunnel. It returns false, if the limit is
+// reached. Every successful reserve must be followed by a call of unreserve.
+func (wt *webSocketTunnels) reserve() bool {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	if wt.closed || (wt.maxConnections > 0 && wt.reserved >= wt.maxConnections) {
+		atomic.AddUint64(&wt.rejected, 1)
+		return false
+	}
+
+	wt.reserved++
+
+	return true
+}
+
+// unreserve frees the slot taken by reserve.
+func (wt *webSocketTunnels) unreserve() {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	wt.reserved--
+}
+
+// track adds the tunnel to the open ones, so it can be closed
+// with the service. It returns false, if the service is already closed.
+func (wt *webSocketTunnels) track(t *tunnel) bool {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	if wt.closed {
+		return false
+	}
+
+	wt.open[t] = struct{}{}
+	atomic.AddUint64(&wt.total, 1)
+
+	return true
+}
+
+// untrack removes the tunnel from the open ones.
+func (wt *webSocketTunnels) untrack(t *tunnel) {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	delete(wt.open, t)
+}
+
+// closeAll closes all the open tunnels, and rejects the new ones.
+func (wt *webSocketTunnels) closeAll() {
+	if wt == nil {
+		return
+	}
+
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	wt.closed = true
+
+	for t := range wt.open {
+		t.close()
+	}
+}
+
+// getInfo returns the public view of the tunnels, or nil if there is no tracker.
+func (wt *webSocketTunnels) getInfo() *WebSocketInfo {
+	if wt == nil {
+		return nil
+	}
+
+	wt.mu.Lock()
+	open := len(wt.open)
+	wt.mu.Unlock()
+
+	return &WebSocketInfo{
+		MaxConnections: wt.maxConnections,
+		Open:           open,
+		Total:          atomic.LoadUint64(&wt.total),
+		Rejected:       atomic.LoadUint64(&wt.rejected),
+	}
+}
+
+// bufferedConn is a connection, whose already buffered bytes are read first.
+type bufferedConn struct {
+	net.Conn
+
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// tunnel copies the bytes between the client and the upstream in both directions.
+type tunnel struct {
+	client   net.Conn
+	upstream net.Conn
+
+	idleTimeout time.Duration
+
+	closeOnce sync.Once
+}
+
+// run copies the bytes until either side closes its connection, or the tunnel is idle.
+func (t *tunnel) run() {
+	t.touch()
+
+	errCh := make(chan error, 2)
+
+	go func() {
+		errCh <- t.copy(t.upstream, t.client)
+	}()
+
+	go func() {
+		errCh <- t.copy(t.client, t.upstream)
+	}()
+
+	// Once one direction is over, the other one is cut too.
+	<-errCh
+	t.close()
+	<-errCh
+}
+
+// copy copies from src to dst, extending the deadline on both sides for each chunk.
+func (t *tunnel) copy(dst io.Writer, src io.Reader) error {
+	chunk := chunkPool.Get().(*[]byte)
+	defer chunkPool.Put(chunk)
+
+	for {
+		n, err := src.Read(*chunk)
+		if n > 0 {
+			t.touch()
+
+			if _, werr := dst.Write((*chunk)[:n]); werr != nil {
+				return werr
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// touch extends the idle deadline of both connections.
+func (t *tunnel) touch() {
+	deadline := time.Now().Add(t.idleTimeout)
+
+	t.client.SetDeadline(deadline)
+	t.upstream.SetDeadline(deadline)
+}
+
+func (t *tunnel) close() {
+	t.closeOnce.Do(func() {
+		t.client.Close()
+		t.upstream.Close()
+	})
+}
+
+// isUpgradeRequest tells if the client asks for switching the
+// protocol of the connection – eg. for a WebSocket handshake.
+func isUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// handleUpgrade proxies the upgrade request to an instance. If the instance
+// switches the protocol, the connection of the client is hijacked, and
+// tunneled to the instance. Otherwise its response is sent as usual.
+func (s *service) handleUpgrade(ctx Context, prefix string, url string) {
+	sw := getStreamWriter(ctx)
+	if sw == nil {
+		ctx.Error("[Handle]: the connection of the upgrade request to service %s can not be hijacked", s.Name)
+
+		ctx.SendInternalServerError()
+
+		return
+	}
+
+	hijacker, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		ctx.Error("[Handle]: the connection of the upgrade request to service %s can not be hijacked", s.Name)
+
+		ctx.SendInternalServerError()
+
+		return
+	}
+
+	if !s.tunnels.reserve() {
+		ctx.Warning("[Handle]: service %s has too many open tunnels, the upgrade is rejected", s.Name)
+
+		ctx.SendRaw([]byte(http.StatusText(http.StatusServiceUnavailable)), http.StatusServiceUnavailable, http.Header{})
+
+		return
+	}
+	defer s.tunnels.unreserve()
+
+	if !s.breaker.allow(time.Now()) {
+		s.sendCircuitOpen(ctx)
+
+		return
+	}
+
+	inst := s.nextInstance()
+	if inst == nil {
+		s.breaker.record(true, 0, time.Now())
+
+		ctx.SetStatusCode(http.StatusServiceUnavailable)
+
+		return
+	}
+
+	inst.acquire()
+	defer inst.release()
+
+	start := time.Now()
+
+	upstream, res, err := s.dialUpgrade(ctx, inst, prefix, url)
+
+	s.recordOutcome(ctx, inst, res, err)
+	s.breaker.record(isFailedResponse(ctx.GetRequest(), res, err), time.Since(start), time.Now())
+
+	if err != nil {
+		ctx.Error("[Handle]: %v", err)
+
+		ctx.SendInternalServerError()
+
+		return
+	}
+	defer upstream.Close()
+
+	// The upstream refused the upgrade, so its response is sent as it is.
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		defer res.Body.Close()
+
+		removeHopByHopHeaders(res.Header)
+		pipeResponse(ctx, res)
+
+		return
+	}
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		ctx.Error("[Handle]: %v", err)
+
+		ctx.SendInternalServerError()
+
+		return
+	}
+
+	// From now on the connection is owned by the tunnel, the
+	// status code is only set, so it is logged by the router.
+	sw.detach()
+	ctx.SetStatusCode(res.StatusCode)
+
+	clientBuf.WriteString("HTTP/1.1 " + res.Status + "\r\n")
+	res.Header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+
+	if err := clientBuf.Flush(); err != nil {
+		clientConn.Close()
+
+		return
+	}
+
+	t := &tunnel{
+		client:      &bufferedConn{Conn: clientConn, r: clientBuf.Reader},
+		upstream:    upstream,
+		idleTimeout: s.tunnels.idleTimeout,
+	}
+
+	if !s.tunnels.track(t) {
+		t.close()
+
+		return
+	}
+	defer s.tunnels.untrack(t)
+
+	t.run()
+}
+
+// dialUpgrade connects to the instance, and sends the upgrade request on the new
+// connection. It returns the connection and the response of the instance. The
+// handshake is bounded by the timeout of the service.
+func (s *service) dialUpgrade(ctx Context, inst *instance, prefix string, url string) (net.Conn, *http.Response, error) {
+	var (
+		reqCtx = ctx.GetRequest().Context()
+		dialer = &net.Dialer{Timeout: s.timeout}
+
+		conn net.Conn
+		err  error
+	)
+
+	if inst.protocol == "https" {
+		config := &tls.Config{}
+		if inst.tlsConfig != nil {
+			config = inst.tlsConfig.Clone()
+		}
+
+		if config.ServerName == "" {
+			config.ServerName = inst.host
+		}
+
+		tlsDialer := &tls.Dialer{
+			NetDialer: dialer,
+			Config:    config,
+		}
+
+		conn, err = tlsDialer.DialContext(reqCtx, "tcp", inst.GetAddress())
+	} else {
+		conn, err = dialer.DialContext(reqCtx, "tcp", inst.GetAddress())
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(reqCtx, ctx.GetRequestMethod(), inst.getAddressWithProtocol()+url, nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	// The upgrade is the only hop-by-hop header, which is forwarded.
+	req.Header = getForwardHeader(ctx.GetRequest(), prefix)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", ctx.GetRequestHeader("Upgrade"))
+
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return &bufferedConn{Conn: conn, r: br}, res, nil
+}
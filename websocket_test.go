@@ -0,0 +1,259 @@
+package gateway
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newEchoUpgradeServer creates a service, which switches the protocol
+// of the upgrade requests, then echoes the lines sent by the client.
+func newEchoUpgradeServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isUpgradeRequest(r) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("expected error: %v; got error: %v\n", nil, err)
+			return
+		}
+		defer conn.Close()
+
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		buf.Flush()
+
+		io.Copy(conn, buf)
+	}))
+}
+
+// dialUpgrade sends an upgrade request to the given address, and returns the
+// connection along with the reader of the response, which is already read.
+func dialUpgrade(t *testing.T, address string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(address, "http://"))
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, address+"/api/mock/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	br := bufio.NewReader(conn)
+
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	return conn, br, res
+}
+
+func TestIsUpgradeRequest(t *testing.T) {
+	type testCase struct {
+		name       string
+		connection string
+		upgrade    string
+		expected   bool
+	}
+
+	tt := []testCase{
+		{
+			name:       "the websocket handshake is an upgrade",
+			connection: "keep-alive, Upgrade",
+			upgrade:    "websocket",
+			expected:   true,
+		},
+		{
+			name:       "the request without upgrade header is not an upgrade",
+			connection: "Upgrade",
+			expected:   false,
+		},
+		{
+			name:       "the request without upgrade token is not an upgrade",
+			connection: "keep-alive",
+			upgrade:    "websocket",
+			expected:   false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/mock", nil)
+			r.Header.Set("Connection", tc.connection)
+			r.Header.Set("Upgrade", tc.upgrade)
+
+			if got := isUpgradeRequest(r); got != tc.expected {
+				t.Errorf("expected: %t; got: %t\n", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidateWebSocket(t *testing.T) {
+	type testCase struct {
+		name string
+		conf *WebSocketConfig
+		err  error
+	}
+
+	tt := []testCase{
+		{
+			name: "the function returns nil if there is no config",
+			conf: nil,
+			err:  nil,
+		},
+		{
+			name: "the function returns error if the limit is negative",
+			conf: &WebSocketConfig{MaxConnections: -1},
+			err:  errBadWebSocketLimit,
+		},
+		{
+			name: "the function returns error if the idle timeout is invalid",
+			conf: &WebSocketConfig{IdleTimeout: "0s"},
+			err:  errBadWebSocketDuration,
+		},
+		{
+			name: "the function returns nil if the config is valid",
+			conf: &WebSocketConfig{MaxConnections: 10, IdleTimeout: "1m"},
+			err:  nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateWebSocket(tc.conf); !errors.Is(err, tc.err) {
+				t.Errorf("expected error: %v; got error: %v\n", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestHandleUpgrade(t *testing.T) {
+	srv := newEchoUpgradeServer(t)
+	defer srv.Close()
+
+	gw := newTestGateway(t, srv, &ServiceConfig{
+		Name:      "mock-name",
+		Prefix:    "/api/mock",
+		WebSocket: &WebSocketConfig{MaxConnections: 1},
+	})
+
+	gwSrv := httptest.NewServer(gw)
+	defer gwSrv.Close()
+
+	s := gw.serviceRegisty.getServiceByName("mock-name")
+
+	conn, br, res := dialUpgrade(t, gwSrv.URL)
+	defer conn.Close()
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status code: %d; got status code: %d\n", http.StatusSwitchingProtocols, res.StatusCode)
+	}
+
+	if up := res.Header.Get("Upgrade"); up != "websocket" {
+		t.Errorf("expected upgrade: %s; got upgrade: %s\n", "websocket", up)
+	}
+
+	// The frames are copied in both directions.
+	io.WriteString(conn, "ping\n")
+
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("expected error: %v; got error: %v\n", nil, err)
+	}
+
+	if line != "ping\n" {
+		t.Errorf("expected line: %q; got line: %q\n", "ping\n", line)
+	}
+
+	if open := s.tunnels.getInfo().Open; open != 1 {
+		t.Errorf("expected open tunnels: %d; got open tunnels: %d\n", 1, open)
+	}
+
+	// The tunnel above the limit is rejected.
+	other, _, res := dialUpgrade(t, gwSrv.URL)
+	defer other.Close()
+
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status code: %d; got status code: %d\n", http.StatusServiceUnavailable, res.StatusCode)
+	}
+
+	if rejected := s.tunnels.getInfo().Rejected; rejected != 1 {
+		t.Errorf("expected rejected tunnels: %d; got rejected tunnels: %d\n", 1, rejected)
+	}
+
+	// Once the client is gone, the tunnel is closed.
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s.tunnels.getInfo().Open != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if open := s.tunnels.getInfo().Open; open != 0 {
+		t.Errorf("expected open tunnels: %d; got open tunnels: %d\n", 0, open)
+	}
+}
+
+func TestHandleUpgradeIdleTimeout(t *testing.T) {
+	srv := newEchoUpgradeServer(t)
+	defer srv.Close()
+
+	gw := newTestGateway(t, srv, &ServiceConfig{
+		Name:      "mock-name",
+		Prefix:    "/api/mock",
+		WebSocket: &WebSocketConfig{IdleTimeout: "50ms"},
+	})
+
+	gwSrv := httptest.NewServer(gw)
+	defer gwSrv.Close()
+
+	conn, br, res := dialUpgrade(t, gwSrv.URL)
+	defer conn.Close()
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status code: %d; got status code: %d\n", http.StatusSwitchingProtocols, res.StatusCode)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := br.ReadByte(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected error: %v; got error: %v\n", io.EOF, err)
+	}
+}
+
+func TestHandleUpgradeRefused(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "forbidden")
+	}))
+	defer srv.Close()
+
+	gw := newTestGateway(t, srv, &ServiceConfig{
+		Name:   "mock-name",
+		Prefix: "/api/mock",
+	})
+
+	gwSrv := httptest.NewServer(gw)
+	defer gwSrv.Close()
+
+	conn, _, res := dialUpgrade(t, gwSrv.URL)
+	defer conn.Close()
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected status code: %d; got status code: %d\n", http.StatusForbidden, res.StatusCode)
+	}
+}